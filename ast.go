@@ -0,0 +1,172 @@
+package main
+
+// ClassNode is the root of the syntax tree of a single Jack class.
+type ClassNode struct {
+	Position     Position          `json:"position"`
+	Name         string            `json:"name"`
+	ClassVarDecs []*VarDecNode     `json:"classVarDecs"`
+	Subroutines  []*SubroutineNode `json:"subroutines"`
+}
+
+// VarDecNode declares one or more variables of the same kind and type, e.g.
+// "field int x, y;" or "var Array a;".
+type VarDecNode struct {
+	Position Position   `json:"position"`
+	Kind     SymbolType `json:"kind"`
+	Type     string     `json:"varType"`
+	Names    []string   `json:"names"`
+}
+
+type ParameterNode struct {
+	Position Position `json:"position"`
+	Type     string   `json:"varType"`
+	Name     string   `json:"name"`
+}
+
+type SubroutineNode struct {
+	Position   Position         `json:"position"`
+	Kind       SubroutineType   `json:"kind"`
+	ReturnType string           `json:"returnType"`
+	Name       string           `json:"name"`
+	Parameters []*ParameterNode `json:"parameters"`
+	VarDecs    []*VarDecNode    `json:"varDecs"`
+	Statements []StatementNode  `json:"statements"`
+}
+
+// StatementNode is implemented by LetStatementNode, IfStatementNode,
+// WhileStatementNode, DoStatementNode and ReturnStatementNode.
+type StatementNode interface {
+	statementNode()
+}
+
+type LetStatementNode struct {
+	Position Position `json:"position"`
+	VarName  string   `json:"varName"`
+	// Position of the variable assigned to
+	VarPosition Position `json:"varPosition"`
+	// Index is nil unless an array element is assigned
+	Index *ExpressionNode `json:"index"`
+	Value *ExpressionNode `json:"value"`
+}
+
+type IfStatementNode struct {
+	Position       Position        `json:"position"`
+	Condition      *ExpressionNode `json:"condition"`
+	Statements     []StatementNode `json:"statements"`
+	ElseStatements []StatementNode `json:"elseStatements"`
+}
+
+type WhileStatementNode struct {
+	Position   Position        `json:"position"`
+	Condition  *ExpressionNode `json:"condition"`
+	Statements []StatementNode `json:"statements"`
+}
+
+type DoStatementNode struct {
+	Position Position            `json:"position"`
+	Call     *SubroutineCallNode `json:"call"`
+	// Index is nil unless the returned array is indexed, e.g.
+	// "do a.items()[i];"
+	Index *ExpressionNode `json:"index"`
+}
+
+type ReturnStatementNode struct {
+	Position Position `json:"position"`
+	// Value is nil for "return;"
+	Value *ExpressionNode `json:"value"`
+}
+
+func (*LetStatementNode) statementNode()    {}
+func (*IfStatementNode) statementNode()     {}
+func (*WhileStatementNode) statementNode()  {}
+func (*DoStatementNode) statementNode()     {}
+func (*ReturnStatementNode) statementNode() {}
+
+// ExpressionNode is a term followed by any number of binary operations. Jack
+// has no operator precedence, operations are applied from left to right.
+type ExpressionNode struct {
+	Position   Position               `json:"position"`
+	Term       TermNode               `json:"term"`
+	Operations []*BinaryOperationNode `json:"operations"`
+}
+
+type BinaryOperationNode struct {
+	Position Position `json:"position"`
+	Operator string   `json:"operator"`
+	Term     TermNode `json:"term"`
+}
+
+// TermNode is implemented by IntegerConstantNode, StringConstantNode,
+// KeywordConstantNode, VarNode, ArrayAccessNode, SubroutineCallNode,
+// IndexedCallNode, ParenthesizedNode and UnaryOperationNode.
+type TermNode interface {
+	termNode()
+}
+
+type IntegerConstantNode struct {
+	Position Position    `json:"position"`
+	Value    MachineWord `json:"value"`
+	// Literal is the source text of constants not written in decimal, e.g.
+	// 'A'
+	Literal string `json:"literal,omitempty"`
+}
+
+type StringConstantNode struct {
+	Position Position `json:"position"`
+	Value    string   `json:"value"`
+}
+
+// KeywordConstantNode is one of true, false, null or this.
+type KeywordConstantNode struct {
+	Position Position `json:"position"`
+	Keyword  string   `json:"keyword"`
+}
+
+type VarNode struct {
+	Position Position `json:"position"`
+	Name     string   `json:"name"`
+}
+
+type ArrayAccessNode struct {
+	Position Position        `json:"position"`
+	Name     string          `json:"name"`
+	Index    *ExpressionNode `json:"index"`
+}
+
+// SubroutineCallNode is a call of the form name(...) or receiver.name(...),
+// where receiver is either a variable or a class name.
+type SubroutineCallNode struct {
+	Position  Position          `json:"position"`
+	Receiver  string            `json:"receiver,omitempty"`
+	Name      string            `json:"name"`
+	Arguments []*ExpressionNode `json:"arguments"`
+}
+
+// IndexedCallNode accesses an element of the array returned by a call, e.g.
+// "a.items()[i]".
+type IndexedCallNode struct {
+	Position Position            `json:"position"`
+	Call     *SubroutineCallNode `json:"call"`
+	Index    *ExpressionNode     `json:"index"`
+}
+
+type ParenthesizedNode struct {
+	Position   Position        `json:"position"`
+	Expression *ExpressionNode `json:"expression"`
+}
+
+type UnaryOperationNode struct {
+	Position Position `json:"position"`
+	Operator string   `json:"operator"`
+	Term     TermNode `json:"term"`
+}
+
+func (*IntegerConstantNode) termNode() {}
+func (*StringConstantNode) termNode()  {}
+func (*KeywordConstantNode) termNode() {}
+func (*VarNode) termNode()             {}
+func (*ArrayAccessNode) termNode()     {}
+func (*SubroutineCallNode) termNode()  {}
+func (*IndexedCallNode) termNode()     {}
+func (*ParenthesizedNode) termNode()   {}
+func (*UnaryOperationNode) termNode()  {}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CodeGenerator walks a syntax tree produced by Parse and emits the same VM
+// code the streaming JackCompiler would.
+type CodeGenerator struct {
+	// CallGraph collects the calls of all generated subroutines if not nil
+	CallGraph *CallGraph
+	// SymbolReport collects the symbols of all generated classes if not nil
+	SymbolReport *SymbolReport
+	// Only restricts the output to the subroutine with this qualified name if
+	// not empty, see CompilerOptions
+	Only string
+	// CheckBounds calls Array.checkBounds on every array index, see
+	// CompilerOptions
+	CheckBounds bool
+	// Annotate names the variable of every push and pop in a comment, see
+	// CompilerOptions
+	Annotate bool
+	// ExtOperators allows the operator "%", see CompilerOptions
+	ExtOperators bool
+	// DropDeadCode omits statements following a return, see CompilerOptions
+	DropDeadCode bool
+	// StaticBase is the index of the class's first static, see
+	// CompilerOptions.SharedStatics
+	StaticBase MachineWord
+
+	symbolTable           SymbolTable
+	output                OutputWriter
+	currentClassName      string
+	currentSubroutineName string
+	currentSubroutineKind SubroutineType
+	currentReturnType     string
+	nextLabelID           uint64
+	// Shared with output, see shareTemps
+	temps *TempSlots
+}
+
+func NewCodeGenerator(output OutputWriter) *CodeGenerator {
+	temps := &TempSlots{}
+	shareTemps(output, temps)
+	return &CodeGenerator{
+		symbolTable: NewSymbolTable(),
+		output:      output,
+		temps:       temps,
+	}
+}
+
+// Generate emits the VM code of class and closes the output.
+func (g *CodeGenerator) Generate(class *ClassNode) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
+		if closeErr := g.output.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+
+	g.generateClass(class)
+	return nil
+}
+
+// generateLabel returns a label prefix unique within the class, namespaced
+// by the current subroutine, e.g. "Foo.bar$WHILE0".
+func (g *CodeGenerator) generateLabel(kind string) string {
+	labelID := g.nextLabelID
+	g.nextLabelID += 1
+	return g.currentClassName + "." + g.currentSubroutineName + "$" + kind + strconv.FormatUint(labelID, 10)
+}
+
+// requireThis panics if what, a use of the this pointer at position, appears
+// in a function, which has no this.
+func (g *CodeGenerator) requireThis(position Position, what string) {
+	if g.currentSubroutineKind == FunctionSubroutineType {
+		panic(fmt.Sprintf("%s used in function %s.%s, which has no this at %s", what, g.currentClassName, g.currentSubroutineName, position))
+	}
+}
+
+func (g *CodeGenerator) generateVariableAccess(varName string, position Position) (VMSegmentType, MachineWord) {
+	symbol, err := g.symbolTable.Lookup(varName)
+	if err != nil {
+		r := textRange(position, varName)
+		panic(&CompileError{Message: fmt.Sprintf("Unknown variable: %q", varName), Position: r.Start, End: r.End})
+	}
+
+	switch symbol.symbolType {
+	case StaticSymbol:
+		return StaticVMSegment, g.StaticBase + symbol.index
+	case ArgumentSymbol:
+		return ArgumentVMSegment, symbol.index
+	case VarSymbol:
+		return LocalVMSegment, symbol.index
+	case FieldSymbol:
+		g.requireThis(position, fmt.Sprintf("field %q", varName))
+		return ThisVMSegment, symbol.index
+	default:
+		panic(fmt.Sprintf("Unknown symbolType: %q\n", symbol.symbolType))
+	}
+}
+
+func (g *CodeGenerator) declare(symbol Symbol, name string, scope Scope) {
+	if _, err := g.symbolTable.Declare(symbol, name, scope); err != nil {
+		panic(&CompileError{Message: err.Error(), Position: symbol.position})
+	}
+}
+
+func (g *CodeGenerator) declareVarDec(varDec *VarDecNode, scope Scope) (numDeclarations MachineWord) {
+	symbol := Symbol{symbolType: varDec.Kind, variableType: varDec.Type, position: varDec.Position}
+	for _, name := range varDec.Names {
+		g.declare(symbol, name, scope)
+		numDeclarations += 1
+	}
+	return numDeclarations
+}
+
+func (g *CodeGenerator) generateClass(class *ClassNode) {
+	g.symbolTable.Clear(ClassScope)
+	g.currentClassName = class.Name
+
+	for _, varDec := range class.ClassVarDecs {
+		g.declareVarDec(varDec, ClassScope)
+	}
+	if g.SymbolReport != nil {
+		g.SymbolReport.AddClass(class.Name, &g.symbolTable)
+	}
+	found := false
+	for _, subroutine := range class.Subroutines {
+		if g.Only == "" || g.Only == class.Name+"."+subroutine.Name {
+			found = true
+			g.generateSubroutine(subroutine)
+			continue
+		}
+		// Generate anyway to report errors
+		output := g.output
+		g.output = NullWriter{}
+		g.generateSubroutine(subroutine)
+		g.output = output
+	}
+	if g.Only != "" && strings.HasPrefix(g.Only, class.Name+".") && !found {
+		panic(fmt.Sprintf("no subroutine %s", g.Only))
+	}
+}
+
+func (g *CodeGenerator) generateSubroutine(subroutine *SubroutineNode) {
+	g.symbolTable.Clear(FunctionScope)
+
+	if subroutine.Kind == MethodSubroutineType {
+		// Method will get an extra argument not captured in the parameter list.
+		thisSymbol := Symbol{
+			symbolType:   ArgumentSymbol,
+			variableType: g.currentClassName,
+		}
+		g.declare(thisSymbol, "this", FunctionScope)
+	}
+
+	for _, parameter := range subroutine.Parameters {
+		symbol := Symbol{symbolType: ArgumentSymbol, variableType: parameter.Type, position: parameter.Position}
+		g.declare(symbol, parameter.Name, FunctionScope)
+	}
+
+	nlocals := MachineWord(0)
+	for _, varDec := range subroutine.VarDecs {
+		nlocals += g.declareVarDec(varDec, FunctionScope)
+	}
+
+	g.currentSubroutineName = subroutine.Name
+	g.currentSubroutineKind = subroutine.Kind
+	g.currentReturnType = subroutine.ReturnType
+	if g.CallGraph != nil {
+		g.CallGraph.AddSubroutine(g.currentClassName + "." + subroutine.Name)
+	}
+	g.output.WriteFunction(g.currentClassName+"."+subroutine.Name, nlocals)
+
+	switch subroutine.Kind {
+	case ConstructorSubroutineType:
+		// Allocate this pointer
+		g.output.WritePush(ConstVMSegment, g.symbolTable.Count(FieldSymbol, ClassScope))
+		g.output.WriteCall("Memory.alloc", 1)
+		g.output.WritePop(PointerVMSegment, 0)
+	case MethodSubroutineType:
+		g.output.WritePush(ArgumentVMSegment, 0)
+		g.output.WritePop(PointerVMSegment, 0)
+	}
+
+	g.generateStatements(subroutine.Statements)
+
+	if subroutine.ReturnType == "void" && !statementsReturn(subroutine.Statements) {
+		// Return implicitly from void subroutines, e.g. ones with empty bodies
+		g.output.WritePush(ConstVMSegment, 0)
+		g.output.WriteReturn()
+	}
+
+	if g.SymbolReport != nil {
+		g.SymbolReport.AddSubroutine(subroutine.Name, &g.symbolTable)
+	}
+}
+
+// statementsReturn reports whether every control path through statements ends
+// in a return statement.
+func statementsReturn(statements []StatementNode) bool {
+	for _, statement := range statements {
+		switch statement := statement.(type) {
+		case *ReturnStatementNode:
+			return true
+		case *IfStatementNode:
+			if statementsReturn(statement.Statements) && statementsReturn(statement.ElseStatements) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g *CodeGenerator) generateStatements(statements []StatementNode) {
+	output := g.output
+	defer func() { g.output = output }()
+
+	for i, statement := range statements {
+		if g.DropDeadCode && statementsReturn(statements[:i]) {
+			// Generate anyway to report errors
+			g.output = NullWriter{}
+		}
+		switch statement := statement.(type) {
+		case *LetStatementNode:
+			g.generateLet(statement)
+		case *IfStatementNode:
+			g.generateIf(statement)
+		case *WhileStatementNode:
+			g.generateWhile(statement)
+		case *DoStatementNode:
+			g.generateSubroutineCall(statement.Call)
+			if statement.Index != nil {
+				g.generateExpression(statement.Index)
+				writeIndexedElement(g.output, g.temps, g.CheckBounds)
+			}
+			// Discard unused return value
+			discard := g.temps.Reserve()
+			g.output.WritePop(TempVMSegment, discard)
+			g.temps.Release(discard)
+		case *ReturnStatementNode:
+			subroutine := SubroutineInfo{name: g.currentSubroutineName, subroutineType: g.currentSubroutineKind, returnType: g.currentReturnType}
+			checkReturn(tokenRange(Token{tokenType: Keyword, terminal: "return", position: statement.Position}), g.currentClassName, subroutine, statement.Value != nil)
+			if statement.Value != nil {
+				g.generateExpression(statement.Value)
+			} else {
+				g.output.WritePush(ConstVMSegment, 0)
+			}
+			g.output.WriteReturn()
+		default:
+			panic(fmt.Sprintf("unexpected statement %T", statement))
+		}
+	}
+}
+
+func (g *CodeGenerator) generateArrayElemPointer(name string, index *ExpressionNode, position Position) {
+	g.generateExpression(index)
+	segment, offset := g.generateVariableAccess(name, position)
+	if g.CheckBounds {
+		writeVariablePush(g.output, segment, offset, name, g.Annotate)
+		g.output.WriteCall(boundsCheckFunction, 2)
+	}
+	writeVariablePush(g.output, segment, offset, name, g.Annotate)
+	g.output.WriteArithmetic(AddVMOperation)
+}
+
+func (g *CodeGenerator) generateLet(let *LetStatementNode) {
+	if _, err := g.symbolTable.Lookup(let.VarName); err != nil {
+		panic(fmt.Sprintf("%s in %s.%s at %s", undeclaredTargetMessage(let.VarName, let.Index != nil), g.currentClassName, g.currentSubroutineName, let.VarPosition))
+	}
+	if let.Index != nil {
+		// The element address stays on the stack while the value is
+		// generated, which may set THAT itself
+		g.generateArrayElemPointer(let.VarName, let.Index, let.VarPosition)
+	}
+
+	g.generateExpression(let.Value)
+
+	if let.Index != nil {
+		// Save result of RHS expression in temp
+		value := g.temps.Reserve()
+		g.output.WritePop(TempVMSegment, value)
+		// Pop array element address into pointer (THAT)
+		g.output.WritePop(PointerVMSegment, 1)
+		// Restore RHS expression result from temp and pop into destination
+		g.output.WritePush(TempVMSegment, value)
+		g.temps.Release(value)
+		g.output.WritePop(ThatVMSegment, 0)
+	} else {
+		segment, index := g.generateVariableAccess(let.VarName, let.VarPosition)
+		writeVariablePop(g.output, segment, index, let.VarName, g.Annotate)
+	}
+}
+
+func (g *CodeGenerator) generateIf(ifStatement *IfStatementNode) {
+	labelPrefix := g.generateLabel("IF")
+
+	g.generateExpression(ifStatement.Condition)
+	g.output.WriteArithmetic(NotVMOperation)
+	g.output.WriteIf(labelPrefix + "_ELSE")
+
+	g.generateStatements(ifStatement.Statements)
+
+	// Without else the ELSE label ends the statement
+	if ifStatement.ElseStatements == nil {
+		g.output.WriteLabel(labelPrefix + "_ELSE")
+		return
+	}
+
+	g.output.WriteGoto(labelPrefix + "_END")
+	g.output.WriteLabel(labelPrefix + "_ELSE")
+
+	g.generateStatements(ifStatement.ElseStatements)
+
+	g.output.WriteLabel(labelPrefix + "_END")
+}
+
+func (g *CodeGenerator) generateWhile(while *WhileStatementNode) {
+	labelPrefix := g.generateLabel("WHILE")
+
+	g.output.WriteLabel(labelPrefix + "_BEGIN")
+
+	g.generateExpression(while.Condition)
+	g.output.WriteArithmetic(NotVMOperation)
+	g.output.WriteIf(labelPrefix + "_EXIT")
+
+	g.generateStatements(while.Statements)
+
+	g.output.WriteGoto(labelPrefix + "_BEGIN")
+	g.output.WriteLabel(labelPrefix + "_EXIT")
+}
+
+func (g *CodeGenerator) generateExpression(expression *ExpressionNode) {
+	g.generateTerm(expression.Term)
+	for _, operation := range expression.Operations {
+		if operation.Operator == "%" && !g.ExtOperators {
+			panic(fmt.Sprintf("operator %% in %s.%s is an extension of Jack and not enabled at %s", g.currentClassName, g.currentSubroutineName, operation.Position))
+		}
+		g.generateTerm(operation.Term)
+		g.output.WriteArithmetic(parseBinaryOp(Token{tokenType: SymbolTokenType, terminal: operation.Operator}))
+	}
+}
+
+func (g *CodeGenerator) generateSubroutineCall(call *SubroutineCallNode) {
+	nargs := MachineWord(len(call.Arguments))
+
+	var name string
+	switch {
+	case call.Receiver == "":
+		g.requireThis(call.Position, fmt.Sprintf("method call %s()", call.Name))
+		// We call a local method, push pointer of this object
+		g.output.WritePush(PointerVMSegment, 0)
+		nargs += 1
+		name = g.currentClassName + "." + call.Name
+	default:
+		if symbol, err := g.symbolTable.Lookup(call.Receiver); err == nil {
+			// Push the object the method is called on as argument 0
+			segment, index := g.generateVariableAccess(call.Receiver, call.Position)
+			writeVariablePush(g.output, segment, index, call.Receiver, g.Annotate)
+			nargs += 1
+			name = symbol.variableType + "." + call.Name
+		} else {
+			// Receiver refers to some class
+			name = call.Receiver + "." + call.Name
+		}
+	}
+
+	for _, argument := range call.Arguments {
+		g.generateExpression(argument)
+	}
+
+	if g.CallGraph != nil {
+		g.CallGraph.AddCall(g.currentClassName+"."+g.currentSubroutineName, name)
+	}
+	g.output.WriteCall(name, nargs)
+}
+
+// generateIntegerConstant pushes value, which may be negative after constant
+// folding.
+func (g *CodeGenerator) generateIntegerConstant(value MachineWord) {
+	switch {
+	case value >= 0:
+		g.output.WritePush(ConstVMSegment, value)
+	case value == MinMachineWord:
+		// 32768 is not a valid constant, use ~32767 instead
+		g.output.WritePush(ConstVMSegment, MaxMachineWord)
+		g.output.WriteArithmetic(NotVMOperation)
+	default:
+		g.output.WritePush(ConstVMSegment, -value)
+		g.output.WriteArithmetic(NegVMOperation)
+	}
+}
+
+func (g *CodeGenerator) generateTerm(term TermNode) {
+	switch term := term.(type) {
+	case *IntegerConstantNode:
+		g.generateIntegerConstant(term.Value)
+	case *StringConstantNode:
+		g.output.WriteStringConstant(term.Value)
+	case *KeywordConstantNode:
+		switch term.Keyword {
+		case "true":
+			g.output.WritePush(ConstVMSegment, 0)
+			g.output.WriteArithmetic(NotVMOperation)
+		case "false", "null":
+			g.output.WritePush(ConstVMSegment, 0)
+		case "this":
+			g.requireThis(term.Position, "this")
+			g.output.WritePush(PointerVMSegment, 0)
+		default:
+			panic(fmt.Sprintf("unexpected keyword %q", term.Keyword))
+		}
+	case *VarNode:
+		segment, index := g.generateVariableAccess(term.Name, term.Position)
+		writeVariablePush(g.output, segment, index, term.Name, g.Annotate)
+	case *ArrayAccessNode:
+		g.generateArrayElemPointer(term.Name, term.Index, term.Position)
+		// Pop into pointer (THAT) and push the value onto the stack
+		g.output.WritePop(PointerVMSegment, 1)
+		g.output.WritePush(ThatVMSegment, 0)
+	case *SubroutineCallNode:
+		g.generateSubroutineCall(term)
+	case *IndexedCallNode:
+		g.generateSubroutineCall(term.Call)
+		g.generateExpression(term.Index)
+		writeIndexedElement(g.output, g.temps, g.CheckBounds)
+	case *ParenthesizedNode:
+		g.generateExpression(term.Expression)
+	case *UnaryOperationNode:
+		g.generateTerm(term.Term)
+		g.output.WriteArithmetic(parseUnaryOp(Token{tokenType: SymbolTokenType, terminal: term.Operator}))
+	default:
+		panic(fmt.Sprintf("unexpected term %T", term))
+	}
+}
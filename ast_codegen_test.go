@@ -0,0 +1,155 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// generateSource compiles the class in source with Parse and CodeGenerator,
+// without folding constants.
+func generateSource(source string) (vm string, err error) {
+	tokenizer := NewTokenizer(strings.NewReader(source))
+	class, err := Parse(&tokenizer)
+	if err != nil {
+		return "", err
+	}
+	vmWriter, buffer := NewBufferVMWriter()
+	err = NewCodeGenerator(vmWriter).Generate(class)
+	return buffer.String(), err
+}
+
+var pipelineSources = map[string]string{
+	"Point": `class Point {
+    field int x, y;
+    static int count;
+
+    constructor Point new(int ax, int ay) {
+        let x = ax;
+        let y = ay;
+        let count = count + 1;
+        return this;
+    }
+
+    method int distance(Point other) {
+        var int dx, dy;
+        let dx = x - other.getX();
+        let dy = y - other.getY();
+        return Math.sqrt((dx * dx) + (dy * dy));
+    }
+
+    method int getX() {
+        return x;
+    }
+
+    method int getY() {
+        return y;
+    }
+
+    method void dispose() {
+        do Memory.deAlloc(this);
+        return;
+    }
+}`,
+	"Main": `class Main {
+    function void main() {
+        var Array a;
+        var int i, sum;
+        var String s;
+        var boolean done;
+        let a = Array.new(10);
+        let i = 0;
+        while (i < 10) {
+            let a[i] = i * 2;
+            let i = i + 1;
+        }
+        let done = false;
+        if (~done & (sum = 0)) {
+            let sum = -a[3] / 2;
+        } else {
+            let sum = 1;
+        }
+        let s = "hi";
+        do Output.printString(s);
+        do Main.show(a[a[1]], null, true);
+        return;
+    }
+
+    function void show(int value, Array rest, boolean flag) {
+        if (flag) {
+            do Output.printInt(value);
+        }
+        return;
+    }
+}`,
+}
+
+func TestCodeGeneratorMatchesJackCompiler(t *testing.T) {
+	for name, source := range pipelineSources {
+		want, _, err := compileSource(name+".jack", source, CompilerOptions{})
+		if err != nil {
+			t.Fatalf("%s: JackCompiler: %v", name, err)
+		}
+		got, err := generateSource(source)
+		if err != nil {
+			t.Fatalf("%s: CodeGenerator: %v", name, err)
+		}
+		if got != want {
+			t.Errorf("%s: CodeGenerator output\n%s\ndiffers from JackCompiler output\n%s", name, got, want)
+		}
+	}
+}
+
+func TestReturnTypeErrorsInBothPipelines(t *testing.T) {
+	tests := []struct {
+		source string
+		want   string
+	}{
+		{`class Main {
+    function int f() {
+        return;
+    }
+}`, "subroutine Main.f of type int must return a value at line 3, col 9"},
+		{`class Main {
+    function void f() {
+        return 1;
+    }
+}`, "void subroutine Main.f must not return a value at line 3, col 9"},
+	}
+	for _, test := range tests {
+		if _, _, err := compileSource("Main.jack", test.source, CompilerOptions{}); err == nil || err.Error() != "Main.jack: "+test.want {
+			t.Errorf("JackCompiler error %v, want %q", err, test.want)
+		}
+		if _, err := generateSource(test.source); err == nil || err.Error() != test.want {
+			t.Errorf("CodeGenerator error %v, want %q", err, test.want)
+		}
+	}
+}
+
+func TestDoStatementIndexingCallResult(t *testing.T) {
+	source := `class Main {
+    function Array arr() {
+        return null;
+    }
+    function void main() {
+        do Main.arr()[0];
+        return;
+    }
+}`
+	want := "call Main.arr 0\npush constant 0\nadd\npop pointer 1\npush that 0\npop temp 0\n"
+	vm, warnings, err := compileSource("Main.jack", source, CompilerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(vm, want) {
+		t.Errorf("JackCompiler output does not contain\n%s\ngot:\n%s", want, vm)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("warnings %q, want none", warnings)
+	}
+	if got, err := generateSource(source); err != nil || got != vm {
+		t.Errorf("CodeGenerator output\n%s\nerror %v, want\n%s", got, err, vm)
+	}
+	if formatted, err := Format(source); err != nil || !strings.Contains(formatted, "do Main.arr()[0];") {
+		t.Errorf("Format output\n%s\nerror %v, want the indexed call kept", formatted, err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+// FoldConstants replaces operations on integer constants in class by their
+// 16 bit result. Since Jack evaluates strictly from left to right only leading
+// constant operations of an expression are folded, e.g. "2 * 3 + x" but not
+// "x + 2 * 3". Returns an error for divisions by a constant zero.
+func FoldConstants(class *ClassNode) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = panicError(r)
+		}
+	}()
+
+	for _, subroutine := range class.Subroutines {
+		foldStatements(subroutine.Statements)
+	}
+	return nil
+}
+
+func foldStatements(statements []StatementNode) {
+	for _, statement := range statements {
+		switch statement := statement.(type) {
+		case *LetStatementNode:
+			foldExpression(statement.Index)
+			foldExpression(statement.Value)
+		case *IfStatementNode:
+			foldExpression(statement.Condition)
+			foldStatements(statement.Statements)
+			foldStatements(statement.ElseStatements)
+		case *WhileStatementNode:
+			foldExpression(statement.Condition)
+			foldStatements(statement.Statements)
+		case *DoStatementNode:
+			foldTerm(statement.Call)
+			foldExpression(statement.Index)
+		case *ReturnStatementNode:
+			foldExpression(statement.Value)
+		}
+	}
+}
+
+func foldExpression(expression *ExpressionNode) {
+	if expression == nil {
+		return
+	}
+
+	expression.Term = foldTerm(expression.Term)
+	for _, operation := range expression.Operations {
+		operation.Term = foldTerm(operation.Term)
+		if divisor, ok := operation.Term.(*IntegerConstantNode); ok && (operation.Operator == "/" || operation.Operator == "%") && divisor.Value == 0 {
+			panic(&CompileError{Message: "division by zero", Position: divisor.Position})
+		}
+	}
+
+	for len(expression.Operations) > 0 {
+		lhs, lhsIsConstant := expression.Term.(*IntegerConstantNode)
+		rhs, rhsIsConstant := expression.Operations[0].Term.(*IntegerConstantNode)
+		if !lhsIsConstant || !rhsIsConstant {
+			return
+		}
+		result, ok := evaluateBinaryOp(expression.Operations[0].Operator, lhs.Value, rhs.Value)
+		if !ok {
+			return
+		}
+		expression.Term = &IntegerConstantNode{Value: result}
+		expression.Operations = expression.Operations[1:]
+	}
+}
+
+// foldTerm folds the expressions nested in term and returns the term that
+// should replace it.
+func foldTerm(term TermNode) TermNode {
+	switch term := term.(type) {
+	case *ArrayAccessNode:
+		foldExpression(term.Index)
+	case *SubroutineCallNode:
+		for _, argument := range term.Arguments {
+			foldExpression(argument)
+		}
+	case *IndexedCallNode:
+		foldTerm(term.Call)
+		foldExpression(term.Index)
+	case *ParenthesizedNode:
+		foldExpression(term.Expression)
+		if len(term.Expression.Operations) == 0 {
+			if constant, ok := term.Expression.Term.(*IntegerConstantNode); ok {
+				return constant
+			}
+		}
+	case *UnaryOperationNode:
+		term.Term = foldTerm(term.Term)
+		if constant, ok := term.Term.(*IntegerConstantNode); ok {
+			switch term.Operator {
+			case "-":
+				return &IntegerConstantNode{Value: toWord(-int(constant.Value))}
+			case "~":
+				return &IntegerConstantNode{Value: ^constant.Value}
+			}
+		}
+	}
+	return term
+}
+
+// evaluateBinaryOp computes lhs operator rhs with 16 bit wraparound. Returns
+// false if the operation can not be evaluated at compile time.
+func evaluateBinaryOp(operator string, lhs MachineWord, rhs MachineWord) (MachineWord, bool) {
+	switch operator {
+	case "+":
+		return toWord(int(lhs) + int(rhs)), true
+	case "-":
+		return toWord(int(lhs) - int(rhs)), true
+	case "*":
+		return toWord(int(lhs) * int(rhs)), true
+	case "/":
+		if rhs == 0 {
+			return 0, false
+		}
+		return toWord(int(lhs) / int(rhs)), true
+	case "%":
+		if rhs == 0 {
+			return 0, false
+		}
+		return toWord(int(lhs) % int(rhs)), true
+	case "&":
+		return lhs & rhs, true
+	case "|":
+		return lhs | rhs, true
+	case "<":
+		return fromBool(lhs < rhs), true
+	case ">":
+		return fromBool(lhs > rhs), true
+	case "=":
+		return fromBool(lhs == rhs), true
+	}
+	return 0, false
+}
+
+// fromBool converts b to Jack's representation of booleans, true being -1.
+func fromBool(b bool) MachineWord {
+	if b {
+		return -1
+	}
+	return 0
+}
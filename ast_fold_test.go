@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDivisionByZeroIsCompileError(t *testing.T) {
+	source := `class Main {
+    function int f(int x) {
+        return x / 0;
+    }
+}`
+	for _, fold := range []bool{false, true} {
+		report := &DiagnosticReport{}
+		_, _, err := compileSource("Main.jack", source, CompilerOptions{FoldConstants: fold, DiagnosticReport: report})
+		if err == nil || !strings.Contains(err.Error(), "division by zero") || !strings.HasSuffix(err.Error(), " at line 3, col 20") {
+			t.Errorf("FoldConstants %v: error %v, want division by zero at line 3, col 20", fold, err)
+			continue
+		}
+		diagnostics := report.Diagnostics
+		if len(diagnostics) != 1 || diagnostics[0].Range != (Range{Start: Position{Line: 3, Column: 20}, End: Position{Line: 3, Column: 21}}) {
+			t.Errorf("FoldConstants %v: diagnostics %+v, want one at 3:20-3:21", fold, diagnostics)
+		}
+	}
+}
+
+func TestFoldConstants(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       string
+	}{
+		{"2 * 3 + x", "push constant 6\npush argument 0\nadd\n"},
+		// Jack evaluates from left to right, 2 * 3 is no leading operation
+		{"x + 2 * 3", "push argument 0\npush constant 2\nadd\npush constant 3\ncall Math.multiply 2\n"},
+		{"(1 + 2) * x", "push constant 3\npush argument 0\ncall Math.multiply 2\n"},
+		{"-(4 - 6)", "push constant 2\n"},
+		{"7 / 2", "push constant 3\n"},
+		// Wraps around to -32768
+		{"32767 + 1", "push constant 32767\nnot\n"},
+		{"200 * 200", "push constant 25536\nneg\n"},
+		{"-32768 - 1", "push constant 32767\n"},
+	}
+	for _, test := range tests {
+		source := "class Main { function int f(int x) { return " + test.expression + "; } }"
+		vm, _, err := compileSource("Main.jack", source, CompilerOptions{FoldConstants: true})
+		if err != nil {
+			t.Fatalf("%s: %v", test.expression, err)
+		}
+		want := "function Main.f 0\n" + test.want + "return\n"
+		if vm != want {
+			t.Errorf("%s: VM code\n%s\nwant\n%s", test.expression, vm, want)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+const formatIndent = "    "
+
+type formatter struct {
+	builder strings.Builder
+	depth   int
+}
+
+// Format parses the class in src and prints it in canonical layout: four
+// spaces of indentation per block, one declaration or statement per line and
+// single spaces around binary operators. Comments are not preserved.
+func Format(src string) (string, error) {
+	// Parse skips the comments, which the lexer tells apart from "//" in
+	// strings
+	tokenizer := NewCommentTokenizer(strings.NewReader(src))
+	class, err := Parse(&tokenizer)
+	if err != nil {
+		return "", err
+	}
+
+	f := formatter{}
+	f.formatClass(class)
+	return f.builder.String(), nil
+}
+
+// line writes the concatenation of parts as an indented line.
+func (f *formatter) line(parts ...string) {
+	f.builder.WriteString(strings.Repeat(formatIndent, f.depth))
+	for _, part := range parts {
+		f.builder.WriteString(part)
+	}
+	f.builder.WriteString("\n")
+}
+
+func (f *formatter) blankLine() {
+	f.builder.WriteString("\n")
+}
+
+func (f *formatter) formatClass(class *ClassNode) {
+	f.line("class ", class.Name, " {")
+	f.depth += 1
+	for _, varDec := range class.ClassVarDecs {
+		f.formatVarDec(varDec)
+	}
+	for i, subroutine := range class.Subroutines {
+		if i > 0 || len(class.ClassVarDecs) > 0 {
+			f.blankLine()
+		}
+		f.formatSubroutine(subroutine)
+	}
+	f.depth -= 1
+	f.line("}")
+}
+
+func (f *formatter) formatVarDec(varDec *VarDecNode) {
+	f.line(string(varDec.Kind), " ", varDec.Type, " ", strings.Join(varDec.Names, ", "), ";")
+}
+
+func (f *formatter) formatSubroutine(subroutine *SubroutineNode) {
+	parameters := make([]string, len(subroutine.Parameters))
+	for i, parameter := range subroutine.Parameters {
+		parameters[i] = parameter.Type + " " + parameter.Name
+	}
+	f.line(string(subroutine.Kind), " ", subroutine.ReturnType, " ", subroutine.Name, "(", strings.Join(parameters, ", "), ") {")
+
+	f.depth += 1
+	for _, varDec := range subroutine.VarDecs {
+		f.formatVarDec(varDec)
+	}
+	if len(subroutine.VarDecs) > 0 && len(subroutine.Statements) > 0 {
+		f.blankLine()
+	}
+	f.formatStatements(subroutine.Statements)
+	f.depth -= 1
+	f.line("}")
+}
+
+func (f *formatter) formatStatements(statements []StatementNode) {
+	for _, statement := range statements {
+		switch statement := statement.(type) {
+		case *LetStatementNode:
+			target := statement.VarName
+			if statement.Index != nil {
+				target += "[" + formatExpression(statement.Index) + "]"
+			}
+			f.line("let ", target, " = ", formatExpression(statement.Value), ";")
+		case *IfStatementNode:
+			f.line("if (", formatExpression(statement.Condition), ") {")
+			f.formatBlock(statement.Statements)
+			if statement.ElseStatements != nil {
+				f.line("} else {")
+				f.formatBlock(statement.ElseStatements)
+			}
+			f.line("}")
+		case *WhileStatementNode:
+			f.line("while (", formatExpression(statement.Condition), ") {")
+			f.formatBlock(statement.Statements)
+			f.line("}")
+		case *DoStatementNode:
+			if statement.Index == nil {
+				f.line("do ", formatTerm(statement.Call), ";")
+			} else {
+				f.line("do ", formatTerm(statement.Call), "[", formatExpression(statement.Index), "];")
+			}
+		case *ReturnStatementNode:
+			if statement.Value == nil {
+				f.line("return;")
+			} else {
+				f.line("return ", formatExpression(statement.Value), ";")
+			}
+		}
+	}
+}
+
+func (f *formatter) formatBlock(statements []StatementNode) {
+	f.depth += 1
+	f.formatStatements(statements)
+	f.depth -= 1
+}
+
+func formatExpression(expression *ExpressionNode) string {
+	var builder strings.Builder
+	builder.WriteString(formatTerm(expression.Term))
+	for _, operation := range expression.Operations {
+		builder.WriteString(" " + operation.Operator + " ")
+		builder.WriteString(formatTerm(operation.Term))
+	}
+	return builder.String()
+}
+
+func formatTerm(term TermNode) string {
+	switch term := term.(type) {
+	case *IntegerConstantNode:
+		if term.Literal != "" {
+			return term.Literal
+		}
+		return strconv.Itoa(int(term.Value))
+	case *StringConstantNode:
+		return `"` + term.Value + `"`
+	case *KeywordConstantNode:
+		return term.Keyword
+	case *VarNode:
+		return term.Name
+	case *ArrayAccessNode:
+		return term.Name + "[" + formatExpression(term.Index) + "]"
+	case *SubroutineCallNode:
+		arguments := make([]string, len(term.Arguments))
+		for i, argument := range term.Arguments {
+			arguments[i] = formatExpression(argument)
+		}
+		name := term.Name
+		if term.Receiver != "" {
+			name = term.Receiver + "." + name
+		}
+		return name + "(" + strings.Join(arguments, ", ") + ")"
+	case *IndexedCallNode:
+		return formatTerm(term.Call) + "[" + formatExpression(term.Index) + "]"
+	case *ParenthesizedNode:
+		return "(" + formatExpression(term.Expression) + ")"
+	case *UnaryOperationNode:
+		return term.Operator + formatTerm(term.Term)
+	}
+	panic("unexpected term")
+}
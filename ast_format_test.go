@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestFormatGolden(t *testing.T) {
+	ugly := `class   Main{static int count;field Array items,  other;
+  /** Entry point */
+constructor Main new( int n ,Array a){let items=a;let count=n+1*2;return this;}
+ method void run(){ var int i; var String s;
+  let i=0; // counter
+  while(i<count){if(~(i=3)){do Output.printString("a // b");}else{let items[i]=-i;}let i=i+1;}
+  do Main.arr()[0];
+  return;}
+function Array arr(){return null;}}
+`
+	want := `class Main {
+    static int count;
+    field Array items, other;
+
+    constructor Main new(int n, Array a) {
+        let items = a;
+        let count = n + 1 * 2;
+        return this;
+    }
+
+    method void run() {
+        var int i;
+        var String s;
+
+        let i = 0;
+        while (i < count) {
+            if (~(i = 3)) {
+                do Output.printString("a // b");
+            } else {
+                let items[i] = -i;
+            }
+            let i = i + 1;
+        }
+        do Main.arr()[0];
+        return;
+    }
+
+    function Array arr() {
+        return null;
+    }
+}
+`
+	got, err := Format(ugly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("Format output\n%s\nwant\n%s", got, want)
+	}
+	if again, err := Format(want); err != nil || again != want {
+		t.Errorf("Format of the canonical layout\n%s\nerror %v, want it unchanged", again, err)
+	}
+}
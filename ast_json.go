@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// ParseToJSON parses the class read from r and serializes its syntax tree.
+// Statements and terms carry a "type" field naming their node type, every
+// node carries the position of its first token.
+func ParseToJSON(r io.Reader) ([]byte, error) {
+	tokenizer := NewTokenizer(r)
+	class, err := Parse(&tokenizer)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(class, "", "  ")
+}
+
+// marshalNode serializes node with an additional "type" field. node has to
+// be a pointer to a struct type without a MarshalJSON method.
+func marshalNode(nodeType string, node interface{}) ([]byte, error) {
+	fields, err := json.Marshal(node)
+	if err != nil {
+		return nil, err
+	}
+	typeField, err := json.Marshal(nodeType)
+	if err != nil {
+		return nil, err
+	}
+
+	result := append([]byte(`{"type":`), typeField...)
+	if len(fields) > 2 {
+		result = append(result, ',')
+	}
+	return append(result, fields[1:]...), nil
+}
+
+// The plain types drop the MarshalJSON methods to avoid infinite recursion
+type (
+	plainLetStatementNode    LetStatementNode
+	plainIfStatementNode     IfStatementNode
+	plainWhileStatementNode  WhileStatementNode
+	plainDoStatementNode     DoStatementNode
+	plainReturnStatementNode ReturnStatementNode
+	plainIntegerConstantNode IntegerConstantNode
+	plainStringConstantNode  StringConstantNode
+	plainKeywordConstantNode KeywordConstantNode
+	plainVarNode             VarNode
+	plainArrayAccessNode     ArrayAccessNode
+	plainSubroutineCallNode  SubroutineCallNode
+	plainIndexedCallNode     IndexedCallNode
+	plainParenthesizedNode   ParenthesizedNode
+	plainUnaryOperationNode  UnaryOperationNode
+)
+
+func (n *LetStatementNode) MarshalJSON() ([]byte, error) {
+	return marshalNode("let", (*plainLetStatementNode)(n))
+}
+
+func (n *IfStatementNode) MarshalJSON() ([]byte, error) {
+	return marshalNode("if", (*plainIfStatementNode)(n))
+}
+
+func (n *WhileStatementNode) MarshalJSON() ([]byte, error) {
+	return marshalNode("while", (*plainWhileStatementNode)(n))
+}
+
+func (n *DoStatementNode) MarshalJSON() ([]byte, error) {
+	return marshalNode("do", (*plainDoStatementNode)(n))
+}
+
+func (n *ReturnStatementNode) MarshalJSON() ([]byte, error) {
+	return marshalNode("return", (*plainReturnStatementNode)(n))
+}
+
+func (n *IntegerConstantNode) MarshalJSON() ([]byte, error) {
+	return marshalNode("integerConstant", (*plainIntegerConstantNode)(n))
+}
+
+func (n *StringConstantNode) MarshalJSON() ([]byte, error) {
+	return marshalNode("stringConstant", (*plainStringConstantNode)(n))
+}
+
+func (n *KeywordConstantNode) MarshalJSON() ([]byte, error) {
+	return marshalNode("keywordConstant", (*plainKeywordConstantNode)(n))
+}
+
+func (n *VarNode) MarshalJSON() ([]byte, error) {
+	return marshalNode("var", (*plainVarNode)(n))
+}
+
+func (n *ArrayAccessNode) MarshalJSON() ([]byte, error) {
+	return marshalNode("arrayAccess", (*plainArrayAccessNode)(n))
+}
+
+func (n *SubroutineCallNode) MarshalJSON() ([]byte, error) {
+	return marshalNode("subroutineCall", (*plainSubroutineCallNode)(n))
+}
+
+func (n *IndexedCallNode) MarshalJSON() ([]byte, error) {
+	return marshalNode("indexedCall", (*plainIndexedCallNode)(n))
+}
+
+func (n *ParenthesizedNode) MarshalJSON() ([]byte, error) {
+	return marshalNode("parenthesized", (*plainParenthesizedNode)(n))
+}
+
+func (n *UnaryOperationNode) MarshalJSON() ([]byte, error) {
+	return marshalNode("unaryOperation", (*plainUnaryOperationNode)(n))
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestParseToJSON(t *testing.T) {
+	source := "class Main {\n  function int f(int a) {\n    let a = a + 1;\n    return a;\n  }\n}"
+	encoded, err := ParseToJSON(strings.NewReader(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tree map[string]any
+	if err := json.Unmarshal(encoded, &tree); err != nil {
+		t.Fatal(err)
+	}
+
+	// path looks up the keys and array indices of path in tree
+	path := func(path ...any) any {
+		var node any = tree
+		for _, step := range path {
+			switch step := step.(type) {
+			case string:
+				node = node.(map[string]any)[step]
+			case int:
+				node = node.([]any)[step]
+			}
+		}
+		return node
+	}
+	subroutine := []any{"subroutines", 0}
+	let := append(subroutine, "statements", 0)
+	tests := []struct {
+		path []any
+		want any
+	}{
+		{[]any{"name"}, "Main"},
+		{append(subroutine, "returnType"), "int"},
+		{append(subroutine, "parameters", 0, "name"), "a"},
+		{append(let, "type"), "let"},
+		{append(let, "position", "line"), 3.0},
+		{append(let, "value", "operations", 0, "operator"), "+"},
+		{append(let, "value", "operations", 0, "term", "type"), "integerConstant"},
+		{append(let, "value", "operations", 0, "term", "position", "column"), 17.0},
+		{append(subroutine, "statements", 1, "type"), "return"},
+	}
+	for _, test := range tests {
+		if got := path(test.path...); got != test.want {
+			t.Errorf("%v = %v, want %v", test.path, got, test.want)
+		}
+	}
+}
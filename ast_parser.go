@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+)
+
+type astParser struct {
+	tokenScanner TokenScanner
+	openBraces   braceStack
+}
+
+// Parse builds the syntax tree of the class read from scanner without
+// emitting any code.
+func Parse(scanner TokenScanner) (class *ClassNode, err error) {
+	p := astParser{tokenScanner: scanner}
+
+	defer func() {
+		if r := recover(); r != nil {
+			class = nil
+			if scanErr := scanner.Err(); scanErr != nil {
+				err = scanErr
+			} else {
+				err = panicError(r)
+			}
+		}
+	}()
+
+	if !scanCode(scanner) || IsTokenType(scanner.Token(), EOF) {
+		panic(emptySourceError)
+	}
+	class = p.parseClass()
+	return
+}
+
+func (p *astParser) nextToken() Token {
+	return p.tokenScanner.Token()
+}
+
+func (p *astParser) advance() Token {
+	if !scanCode(p.tokenScanner) {
+		panic("Could not advance token scanner!")
+	}
+	if IsTokenType(p.nextToken(), EOF) {
+		panic(p.openBraces.endOfSourceError())
+	}
+	return p.nextToken()
+}
+
+func (p *astParser) consume(expectedTerminals ...string) {
+	if len(expectedTerminals) == 0 {
+		p.advance()
+		return
+	}
+
+	for _, expectedTerminal := range expectedTerminals {
+		if !IsTerminal(p.nextToken(), expectedTerminal) {
+			panic(unexpectedTokenError(p.nextToken(), expectedTerminal))
+		}
+		p.openBraces.track(p.nextToken())
+		p.advance()
+	}
+}
+
+func (p *astParser) consumeIdentifier() string {
+	name, err := parseIdentifier(p.nextToken())
+	if err != nil {
+		panic(err)
+	}
+	p.advance()
+	return name
+}
+
+func (p *astParser) consumeType() string {
+	typeName, err := parseType(p.nextToken())
+	if err != nil {
+		panic(err)
+	}
+	p.advance()
+	return typeName
+}
+
+func (p *astParser) parseClass() *ClassNode {
+	class := &ClassNode{Position: p.nextToken().position}
+	p.consume("class")
+	class.Name = p.consumeIdentifier()
+
+	p.consume("{")
+	for IsTerminal(p.nextToken(), "static", "field") {
+		class.ClassVarDecs = append(class.ClassVarDecs, p.parseVarDec())
+	}
+	for IsTerminal(p.nextToken(), "constructor", "function", "method") {
+		class.Subroutines = append(class.Subroutines, p.parseSubroutine())
+	}
+	// The closing } has to be the last token
+	if !IsTerminal(p.nextToken(), "}") || !scanCode(p.tokenScanner) {
+		panic("Unexpected end of class")
+	}
+	if IsTerminal(p.nextToken(), "}") {
+		panic(unmatchedBraceError(p.nextToken()))
+	}
+	if !IsTokenType(p.nextToken(), EOF) {
+		panic("Unexpected end of class")
+	}
+	return class
+}
+
+/*
+ * VarDec: ('static' | 'field' | 'var') type varName (',' varName)* ';'
+ */
+func (p *astParser) parseVarDec() *VarDecNode {
+	varDec := &VarDecNode{Position: p.nextToken().position, Kind: SymbolType(p.nextToken().terminal)}
+	p.consume()
+	varDec.Type = p.consumeType()
+
+	for {
+		varDec.Names = append(varDec.Names, p.consumeIdentifier())
+		if !IsTerminal(p.nextToken(), ",") {
+			break
+		}
+		p.consume(",")
+	}
+	p.consume(";")
+	return varDec
+}
+
+func (p *astParser) parseSubroutine() *SubroutineNode {
+	subroutineType, err := parseSubroutineType(p.nextToken())
+	if err != nil {
+		panic(err)
+	}
+	subroutine := &SubroutineNode{Position: p.nextToken().position, Kind: subroutineType}
+	p.consume()
+
+	subroutine.ReturnType, err = parseReturnType(p.nextToken())
+	if err != nil {
+		panic(err)
+	}
+	p.consume()
+	subroutine.Name = p.consumeIdentifier()
+
+	p.consume("(")
+	for !IsTerminal(p.nextToken(), ")") {
+		parameter := &ParameterNode{Position: p.nextToken().position}
+		parameter.Type = p.consumeType()
+		parameter.Name = p.consumeIdentifier()
+		subroutine.Parameters = append(subroutine.Parameters, parameter)
+		if !IsTerminal(p.nextToken(), ",") {
+			break
+		}
+		p.consume(",")
+	}
+	p.consume(")")
+
+	p.consume("{")
+	for IsTerminal(p.nextToken(), "var") {
+		subroutine.VarDecs = append(subroutine.VarDecs, p.parseVarDec())
+	}
+	subroutine.Statements = p.parseStatements()
+	p.consume("}")
+
+	return subroutine
+}
+
+func (p *astParser) parseStatements() (statements []StatementNode) {
+	for !IsTerminal(p.nextToken(), "}") {
+		var statement StatementNode
+		switch token := p.nextToken(); {
+		case IsTerminal(token, "let"):
+			statement = p.parseLet()
+		case IsTerminal(token, "if"):
+			statement = p.parseIf()
+		case IsTerminal(token, "while"):
+			statement = p.parseWhile()
+		case IsTerminal(token, "do"):
+			statement = p.parseDo()
+		case IsTerminal(token, "return"):
+			statement = p.parseReturn()
+		default:
+			panic("unexpected token " + token.terminal)
+		}
+		statements = append(statements, statement)
+	}
+	return statements
+}
+
+func (p *astParser) parseLet() *LetStatementNode {
+	let := &LetStatementNode{Position: p.nextToken().position}
+	p.consume("let")
+	varNameToken := p.nextToken()
+	if !IsTokenType(varNameToken, Identifier) {
+		panic(letTargetError(varNameToken))
+	}
+	let.VarName, let.VarPosition = p.consumeIdentifier(), varNameToken.position
+	if IsTerminal(p.nextToken(), ".") {
+		panic(memberAccessError(varNameToken, p.advance()))
+	}
+
+	if IsTerminal(p.nextToken(), "[") {
+		p.consume("[")
+		let.Index = p.parseExpression()
+		p.consume("]")
+	}
+
+	p.consume("=")
+	let.Value = p.parseExpression()
+	p.consume(";")
+	return let
+}
+
+func (p *astParser) parseIf() *IfStatementNode {
+	ifStatement := &IfStatementNode{Position: p.nextToken().position}
+	p.consume("if", "(")
+	ifStatement.Condition = p.parseExpression()
+	p.consume(")", "{")
+	ifStatement.Statements = p.parseStatements()
+	p.consume("}")
+
+	if IsTerminal(p.nextToken(), "else") {
+		p.consume("else", "{")
+		// Not nil for an empty else to tell it from a missing one
+		ifStatement.ElseStatements = append([]StatementNode{}, p.parseStatements()...)
+		p.consume("}")
+	}
+	return ifStatement
+}
+
+func (p *astParser) parseWhile() *WhileStatementNode {
+	while := &WhileStatementNode{Position: p.nextToken().position}
+	p.consume("while", "(")
+	while.Condition = p.parseExpression()
+	p.consume(")", "{")
+	while.Statements = p.parseStatements()
+	p.consume("}")
+	return while
+}
+
+func (p *astParser) parseDo() *DoStatementNode {
+	do := &DoStatementNode{Position: p.nextToken().position}
+	p.consume("do")
+	position := p.nextToken().position
+	do.Call = p.parseSubroutineCall(p.consumeIdentifier(), position)
+	if IsTerminal(p.nextToken(), "[") {
+		p.consume("[")
+		do.Index = p.parseExpression()
+		p.consume("]")
+	}
+	p.consume(";")
+	return do
+}
+
+func (p *astParser) parseReturn() *ReturnStatementNode {
+	ret := &ReturnStatementNode{Position: p.nextToken().position}
+	p.consume("return")
+	if !IsSymbol(p.nextToken(), ";") {
+		ret.Value = p.parseExpression()
+	}
+	p.consume(";")
+	return ret
+}
+
+/*
+ * Expression: term (op term)*
+ */
+func (p *astParser) parseExpression() *ExpressionNode {
+	expression := &ExpressionNode{Position: p.nextToken().position}
+	expression.Term = p.parseTerm()
+	for isBinaryOp(p.nextToken()) {
+		operation := &BinaryOperationNode{Position: p.nextToken().position, Operator: p.nextToken().terminal}
+		p.advance()
+		operation.Term = p.parseTerm()
+		expression.Operations = append(expression.Operations, operation)
+	}
+	return expression
+}
+
+/*
+ * Expression list: (expression (, expression)*)?
+ */
+func (p *astParser) parseExpressionList() (expressions []*ExpressionNode) {
+	if IsSymbol(p.nextToken(), ")") {
+		return nil
+	}
+	for {
+		expressions = append(expressions, p.parseExpression())
+		if !IsTerminal(p.nextToken(), ",") {
+			break
+		}
+		p.consume(",")
+	}
+	return expressions
+}
+
+// parseSubroutineCall parses the remainder of a subroutine call after its
+// leading identifier at position, i.e. "(...)" or ".name(...)".
+func (p *astParser) parseSubroutineCall(name string, position Position) *SubroutineCallNode {
+	call := &SubroutineCallNode{Position: position, Name: name}
+	if IsTerminal(p.nextToken(), ".") {
+		p.consume(".")
+		call.Receiver = name
+		call.Name = p.consumeIdentifier()
+		if !IsTerminal(p.nextToken(), "(") {
+			panic(memberAccessError(Token{terminal: name, position: position}, Token{terminal: call.Name}))
+		}
+	}
+
+	p.consume("(")
+	call.Arguments = p.parseExpressionList()
+	p.consume(")")
+	return call
+}
+
+/*
+ * Term:
+ * integerConstant | stringConstant | keywordConstant | varName | varName '[' expression ']' |
+ * subroutineCall | '(' expression ')' | unaryOp term
+ */
+func (p *astParser) parseTerm() TermNode {
+	switch token := p.nextToken(); {
+	case IsTokenType(token, IntegerConstant):
+		constant, err := parseIntegerConstant(token)
+		if err != nil {
+			panic(err)
+		}
+		p.advance()
+		return &IntegerConstantNode{Position: token.position, Value: constant}
+	case IsTokenType(token, CharConstant):
+		constant, err := parseCharConstant(token)
+		if err != nil {
+			panic(err)
+		}
+		p.advance()
+		return &IntegerConstantNode{Position: token.position, Value: constant, Literal: "'" + token.terminal + "'"}
+	case IsTokenType(token, StringConstant):
+		constant, err := parseStringConstant(token)
+		if err != nil {
+			panic(err)
+		}
+		p.advance()
+		return &StringConstantNode{Position: token.position, Value: constant}
+	case IsTokenType(token, Keyword):
+		if !IsTerminal(token, "true", "false", "null", "this") {
+			panic(fmt.Errorf("unexpected keyword %q", token.terminal))
+		}
+		p.advance()
+		return &KeywordConstantNode{Position: token.position, Keyword: token.terminal}
+	case IsTerminal(token, "("):
+		p.consume("(")
+		term := &ParenthesizedNode{Position: token.position, Expression: p.parseExpression()}
+		p.consume(")")
+		return term
+	case isUnaryOp(token):
+		p.advance()
+		if next := p.nextToken(); IsTerminal(token, "-") && next.isMinWordMagnitude() {
+			p.advance()
+			return &IntegerConstantNode{Position: token.position, Value: MinMachineWord}
+		}
+		return &UnaryOperationNode{Position: token.position, Operator: token.terminal, Term: p.parseTerm()}
+	case !IsTokenType(token, Identifier):
+		panic(expectedTermError(token))
+	}
+
+	position := p.nextToken().position
+	name := p.consumeIdentifier()
+	switch {
+	case IsTerminal(p.nextToken(), "["):
+		p.consume("[")
+		term := &ArrayAccessNode{Position: position, Name: name, Index: p.parseExpression()}
+		p.consume("]")
+		return term
+	case IsTerminal(p.nextToken(), "(", "."):
+		call := p.parseSubroutineCall(name, position)
+		if !IsTerminal(p.nextToken(), "[") {
+			return call
+		}
+		p.consume("[")
+		term := &IndexedCallNode{Position: position, Call: call, Index: p.parseExpression()}
+		p.consume("]")
+		return term
+	default:
+		return &VarNode{Position: position, Name: name}
+	}
+}
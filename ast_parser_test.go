@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// fuzzTerminals are the tokens FuzzParser builds token streams from.
+var fuzzTerminals = []Token{
+	{tokenType: Keyword, terminal: "class"},
+	{tokenType: Keyword, terminal: "function"},
+	{tokenType: Keyword, terminal: "method"},
+	{tokenType: Keyword, terminal: "constructor"},
+	{tokenType: Keyword, terminal: "field"},
+	{tokenType: Keyword, terminal: "var"},
+	{tokenType: Keyword, terminal: "int"},
+	{tokenType: Keyword, terminal: "void"},
+	{tokenType: Keyword, terminal: "let"},
+	{tokenType: Keyword, terminal: "do"},
+	{tokenType: Keyword, terminal: "if"},
+	{tokenType: Keyword, terminal: "else"},
+	{tokenType: Keyword, terminal: "while"},
+	{tokenType: Keyword, terminal: "return"},
+	{tokenType: Keyword, terminal: "this"},
+	{tokenType: Keyword, terminal: "true"},
+	{tokenType: Identifier, terminal: "Main"},
+	{tokenType: Identifier, terminal: "main"},
+	{tokenType: Identifier, terminal: "x"},
+	{tokenType: Identifier, terminal: "Output"},
+	{tokenType: IntegerConstant, terminal: "0"},
+	{tokenType: IntegerConstant, terminal: "7"},
+	{tokenType: StringConstant, terminal: "s"},
+	{tokenType: Comment, terminal: "// c"},
+	{tokenType: SymbolTokenType, terminal: "{"},
+	{tokenType: SymbolTokenType, terminal: "}"},
+	{tokenType: SymbolTokenType, terminal: "("},
+	{tokenType: SymbolTokenType, terminal: ")"},
+	{tokenType: SymbolTokenType, terminal: "["},
+	{tokenType: SymbolTokenType, terminal: "]"},
+	{tokenType: SymbolTokenType, terminal: "."},
+	{tokenType: SymbolTokenType, terminal: ","},
+	{tokenType: SymbolTokenType, terminal: ";"},
+	{tokenType: SymbolTokenType, terminal: "="},
+	{tokenType: SymbolTokenType, terminal: "+"},
+	{tokenType: SymbolTokenType, terminal: "-"},
+	{tokenType: SymbolTokenType, terminal: "/"},
+	{tokenType: SymbolTokenType, terminal: "%"},
+	{tokenType: SymbolTokenType, terminal: "~"},
+	{tokenType: SymbolTokenType, terminal: "<"},
+}
+
+// fuzzTokens maps every byte of data to one of fuzzTerminals, one token per
+// line.
+func fuzzTokens(data []byte) []Token {
+	tokens := make([]Token, len(data))
+	for i, b := range data {
+		tokens[i] = fuzzTerminals[int(b)%len(fuzzTerminals)]
+		tokens[i].position = Position{Line: i + 1, Column: 1}
+	}
+	return tokens
+}
+
+func FuzzParser(f *testing.F) {
+	// class Main { function void main ( ) { return ; } }
+	f.Add([]byte{0, 16, 24, 1, 7, 17, 26, 27, 24, 13, 32, 25, 25})
+	f.Add([]byte{0, 16, 24, 25, 25})
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if _, err := Parse(NewSliceScanner(fuzzTokens(data))); err != nil && strings.Contains(err.Error(), "runtime error") {
+			t.Errorf("Parse: %v", err)
+		}
+
+		compiler := NewJackCompiler(NewSliceScanner(fuzzTokens(data)), NullWriter{})
+		compiler.Options.RecoverErrors = true
+		if err := compiler.Compile(); err != nil && strings.Contains(err.Error(), "runtime error") {
+			t.Errorf("Compile: %v", err)
+		}
+	})
+}
+
+func TestParseReturnTypes(t *testing.T) {
+	source := `class Main {
+		function void f() { return; }
+		method int g() { return 1; }
+		function Array h() { return null; }
+	}`
+	tokenizer := NewTokenizer(strings.NewReader(source))
+	class, err := Parse(&tokenizer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var returnTypes []string
+	for _, subroutine := range class.Subroutines {
+		returnTypes = append(returnTypes, subroutine.ReturnType)
+	}
+	if want := []string{"void", "int", "Array"}; strings.Join(returnTypes, " ") != strings.Join(want, " ") {
+		t.Errorf("return types %q, want %q", returnTypes, want)
+	}
+
+	tokenizer = NewTokenizer(strings.NewReader(`class Main { function 5 f() { return; } }`))
+	if _, err := Parse(&tokenizer); err == nil || !strings.Contains(err.Error(), `invalid return type "5"`) {
+		t.Errorf("error %v, want an invalid return type", err)
+	}
+}
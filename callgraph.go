@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+type CallEdge struct {
+	Caller string
+	Callee string
+}
+
+// CallGraph collects calls between subroutines, which are identified by their
+// fully qualified name, e.g. "Main.main".
+type CallGraph struct {
+	subroutines map[string]bool
+	edges       map[CallEdge]bool
+}
+
+func NewCallGraph() *CallGraph {
+	return &CallGraph{
+		subroutines: make(map[string]bool),
+		edges:       make(map[CallEdge]bool),
+	}
+}
+
+// AddSubroutine marks subroutine as defined by the compiled sources.
+func (g *CallGraph) AddSubroutine(subroutine string) {
+	g.subroutines[subroutine] = true
+}
+
+func (g *CallGraph) AddCall(caller string, callee string) {
+	g.edges[CallEdge{Caller: caller, Callee: callee}] = true
+}
+
+// Edges returns all calls sorted by caller and callee.
+func (g *CallGraph) Edges() []CallEdge {
+	edges := make([]CallEdge, 0, len(g.edges))
+	for edge := range g.edges {
+		edges = append(edges, edge)
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].Caller != edges[j].Caller {
+			return edges[i].Caller < edges[j].Caller
+		}
+		return edges[i].Callee < edges[j].Callee
+	})
+	return edges
+}
+
+// WriteDOT writes the call graph in Graphviz DOT format. Subroutines that are
+// called but not defined by the compiled sources, e.g. those of the OS, are
+// drawn dashed.
+func (g *CallGraph) WriteDOT(w io.Writer) error {
+	nodes := make(map[string]bool)
+	for subroutine := range g.subroutines {
+		nodes[subroutine] = true
+	}
+	edges := g.Edges()
+	for _, edge := range edges {
+		nodes[edge.Caller] = true
+		nodes[edge.Callee] = true
+	}
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if _, err := fmt.Fprintln(w, "digraph calls {"); err != nil {
+		return err
+	}
+	for _, name := range names {
+		attributes := ""
+		if !g.subroutines[name] {
+			attributes = " [style=dashed]"
+		}
+		if _, err := fmt.Fprintf(w, "\t%q%s;\n", name, attributes); err != nil {
+			return err
+		}
+	}
+	for _, edge := range edges {
+		if _, err := fmt.Fprintf(w, "\t%q -> %q;\n", edge.Caller, edge.Callee); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
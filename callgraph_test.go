@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCallGraphDOT(t *testing.T) {
+	source := `class Main {
+    function void main() {
+        var Main m;
+        let m = Main.new();
+        do m.run();
+        return;
+    }
+    constructor Main new() {
+        return this;
+    }
+    method void run() {
+        do show(2);
+        return;
+    }
+    method void show(int x) {
+        do Output.printInt(x);
+        return;
+    }
+}`
+	want := `digraph calls {
+	"Main.main";
+	"Main.new";
+	"Main.run";
+	"Main.show";
+	"Output.printInt" [style=dashed];
+	"Main.main" -> "Main.new";
+	"Main.main" -> "Main.run";
+	"Main.run" -> "Main.show";
+	"Main.show" -> "Output.printInt";
+}
+`
+	for _, fold := range []bool{false, true} {
+		callGraph := NewCallGraph()
+		if _, _, err := compileSource("Main.jack", source, CompilerOptions{CallGraph: callGraph, FoldConstants: fold}); err != nil {
+			t.Fatal(err)
+		}
+		var dot strings.Builder
+		if err := callGraph.WriteDOT(&dot); err != nil {
+			t.Fatal(err)
+		}
+		if dot.String() != want {
+			t.Errorf("FoldConstants %v: DOT\n%s\nwant\n%s", fold, dot.String(), want)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import "fmt"
+
+// VMCommand is a VM command recorded by CommandWriter. Only the fields used
+// by Op are set, e.g. {Op: "push", Segment: ConstVMSegment, Index: 5}.
+type VMCommand struct {
+	// "push", "pop", "label", "goto", "if-goto", "call", "function",
+	// "return", an arithmetic command like "add" or, for commands written with
+	// WriteCommand, the verbatim command
+	Op      string
+	Segment VMSegmentType
+	Index   MachineWord
+	// Label of label, goto and if-goto, name of call and function
+	Label string
+	// Number of arguments of call, number of locals of function
+	Args MachineWord
+}
+
+func (c VMCommand) String() string {
+	switch c.Op {
+	case "push", "pop":
+		return fmt.Sprintf("%s %s %d", c.Op, c.Segment, c.Index)
+	case "label", "goto", "if-goto":
+		return c.Op + " " + c.Label
+	case "call", "function":
+		return fmt.Sprintf("%s %s %d", c.Op, c.Label, c.Args)
+	}
+	return c.Op
+}
+
+// CommandWriter is an OutputWriter recording the emitted VM commands as
+// VMCommands in Commands, expanded like VMWriter expands string constants and
+// multiplications. Comments are not recorded.
+type CommandWriter struct {
+	recordingWriter
+	temps *TempSlots
+}
+
+func (w *CommandWriter) WriteArithmetic(operation VMOperation) {
+	if function, ok := arithmeticFunction(operation); ok {
+		w.WriteCall(function, 2)
+		return
+	}
+	w.recordingWriter.WriteArithmetic(operation)
+}
+
+func (w *CommandWriter) WriteStringConstant(constant string) {
+	if w.temps == nil {
+		w.temps = &TempSlots{}
+	}
+	writeStringConstant(w, w.temps, constant)
+}
+
+func (w *CommandWriter) useTemps(temps *TempSlots) {
+	w.temps = temps
+}
+
+func (w *CommandWriter) WriteComment(string) {}
+
+func (w *CommandWriter) writeAnnotatedPush(segment VMSegmentType, index MachineWord, _ string) {
+	w.WritePush(segment, index)
+}
+
+func (w *CommandWriter) writeAnnotatedPop(segment VMSegmentType, index MachineWord, _ string) {
+	w.WritePop(segment, index)
+}
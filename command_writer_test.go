@@ -0,0 +1,178 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// compileReturn compiles the return statement in source, which has to be
+// followed by another token, in a subroutine of kind returning returnType
+// with the int local x.
+func compileReturn(t *testing.T, source string, kind SubroutineType, returnType string) []VMCommand {
+	t.Helper()
+	writer := &CommandWriter{}
+	compiler := newStatementCompiler(scanTokens(t, source), writer)
+	compiler.currentSubroutine.subroutineType = kind
+	compiler.currentSubroutine.returnType = returnType
+	compiler.symbolTable.Declare(Symbol{symbolType: VarSymbol, variableType: "int"}, "x", FunctionScope)
+	compiler.compileReturn()
+	return writer.Commands
+}
+
+func TestCompileReturnCommands(t *testing.T) {
+	tests := []struct {
+		name       string
+		source     string
+		kind       SubroutineType
+		returnType string
+		want       []VMCommand
+	}{
+		{
+			name:       "void",
+			source:     "return; }",
+			kind:       FunctionSubroutineType,
+			returnType: "void",
+			want: []VMCommand{
+				{Op: "push", Segment: ConstVMSegment, Index: 0},
+				{Op: "return"},
+			},
+		},
+		{
+			name:       "value",
+			source:     "return x * 2; }",
+			kind:       FunctionSubroutineType,
+			returnType: "int",
+			want: []VMCommand{
+				{Op: "push", Segment: LocalVMSegment, Index: 0},
+				{Op: "push", Segment: ConstVMSegment, Index: 2},
+				{Op: "call", Label: "Math.multiply", Args: 2},
+				{Op: "return"},
+			},
+		},
+		{
+			name:       "this",
+			source:     "return this; }",
+			kind:       ConstructorSubroutineType,
+			returnType: "Main",
+			want: []VMCommand{
+				{Op: "push", Segment: PointerVMSegment, Index: 0},
+				{Op: "return"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := compileReturn(t, test.source, test.kind, test.returnType)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("commands %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCommandWriterExpandsStringConstants(t *testing.T) {
+	writer := &CommandWriter{}
+	writer.WriteStringConstant("A")
+	writer.WriteComment("not recorded")
+
+	var lines []string
+	for _, command := range writer.Commands {
+		lines = append(lines, command.String())
+	}
+	want := "push constant 1\ncall String.new 1\npop temp 0\npush temp 0\npush constant 65\ncall String.appendChar 2\npop temp 1\npush temp 0"
+	if got := strings.Join(lines, "\n"); got != want {
+		t.Errorf("commands\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestRecordingWriterReplaysAllCommands(t *testing.T) {
+	recorder := &recordingWriter{}
+	recorder.WriteFunction("Main.main", 1)
+	recorder.WriteComment("x")
+	recorder.WriteCommand("push local 0 // x")
+	recorder.WriteStringConstant("s")
+	recorder.WriteArithmetic(MulVMOperation)
+	recorder.WriteIf("L_END")
+	recorder.WriteLabel("L_END")
+	recorder.WriteReturn()
+
+	replayed, replayedBuffer := NewBufferVMWriter()
+	recorder.Replay(replayed)
+	replayed.Close()
+
+	direct, directBuffer := NewBufferVMWriter()
+	direct.WriteFunction("Main.main", 1)
+	direct.WriteComment("x")
+	direct.WriteCommand("push local 0 // x")
+	direct.WriteStringConstant("s")
+	direct.WriteArithmetic(MulVMOperation)
+	direct.WriteIf("L_END")
+	direct.WriteLabel("L_END")
+	direct.WriteReturn()
+	direct.Close()
+
+	if replayedBuffer.String() != directBuffer.String() {
+		t.Errorf("replayed\n%s\nwant\n%s", replayedBuffer, directBuffer)
+	}
+}
+
+func TestCompileLetArrayElementCommands(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []VMCommand
+	}{
+		{
+			source: "let a[b[0]] = c[1]; }",
+			want: []VMCommand{
+				// Destination address a + b[0], left on the stack
+				{Op: "push", Segment: ConstVMSegment, Index: 0},
+				{Op: "push", Segment: LocalVMSegment, Index: 1},
+				{Op: "add"},
+				{Op: "pop", Segment: PointerVMSegment, Index: 1},
+				{Op: "push", Segment: ThatVMSegment, Index: 0},
+				{Op: "push", Segment: LocalVMSegment, Index: 0},
+				{Op: "add"},
+				// Value c[1], which moves THAT
+				{Op: "push", Segment: ConstVMSegment, Index: 1},
+				{Op: "push", Segment: LocalVMSegment, Index: 2},
+				{Op: "add"},
+				{Op: "pop", Segment: PointerVMSegment, Index: 1},
+				{Op: "push", Segment: ThatVMSegment, Index: 0},
+				// THAT is set to the destination only after the value is stashed
+				{Op: "pop", Segment: TempVMSegment, Index: 0},
+				{Op: "pop", Segment: PointerVMSegment, Index: 1},
+				{Op: "push", Segment: TempVMSegment, Index: 0},
+				{Op: "pop", Segment: ThatVMSegment, Index: 0},
+			},
+		},
+		{
+			source: "let a[0] = a[1]; }",
+			want: []VMCommand{
+				{Op: "push", Segment: ConstVMSegment, Index: 0},
+				{Op: "push", Segment: LocalVMSegment, Index: 0},
+				{Op: "add"},
+				{Op: "push", Segment: ConstVMSegment, Index: 1},
+				{Op: "push", Segment: LocalVMSegment, Index: 0},
+				{Op: "add"},
+				{Op: "pop", Segment: PointerVMSegment, Index: 1},
+				{Op: "push", Segment: ThatVMSegment, Index: 0},
+				{Op: "pop", Segment: TempVMSegment, Index: 0},
+				{Op: "pop", Segment: PointerVMSegment, Index: 1},
+				{Op: "push", Segment: TempVMSegment, Index: 0},
+				{Op: "pop", Segment: ThatVMSegment, Index: 0},
+			},
+		},
+	}
+	for _, test := range tests {
+		writer := &CommandWriter{}
+		compiler := newStatementCompiler(scanTokens(t, test.source), writer)
+		for _, name := range []string{"a", "b", "c"} {
+			compiler.symbolTable.Declare(Symbol{symbolType: VarSymbol, variableType: "Array"}, name, FunctionScope)
+		}
+		compiler.compileLet()
+		if !reflect.DeepEqual(writer.Commands, test.want) {
+			t.Errorf("%q: commands\n%v\nwant\n%v", test.source, writer.Commands, test.want)
+		}
+	}
+}
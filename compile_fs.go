@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+)
+
+// CompileFS compiles root, a .jack file or a directory containing .jack
+// files in fsys, and writes the VM code of each file to the writer out
+// returns for the name of its .vm file, e.g. "dir/Main.vm". All files are
+// compiled, the errors of the failing ones are returned as CompileErrors.
+func CompileFS(fsys fs.FS, root string, out func(name string) (io.WriteCloser, error)) error {
+	files, err := collectFSFiles(fsys, root)
+	if err != nil {
+		return err
+	}
+
+	var errs CompileErrors
+	for _, file := range files {
+		if err := compileFSFile(fsys, file, out); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if errs != nil {
+		return errs
+	}
+	return nil
+}
+
+// collectFSFiles returns root if it is a file, else the .jack files in the
+// directory root.
+func collectFSFiles(fsys fs.FS, root string) ([]string, error) {
+	info, err := fs.Stat(fsys, root)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot stat file/dir %q: %v", root, err)
+	}
+	if !info.IsDir() {
+		return []string{root}, nil
+	}
+
+	entries, err := fs.ReadDir(fsys, root)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open directory %q: %v", root, err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && path.Ext(entry.Name()) == ".jack" {
+			files = append(files, path.Join(root, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+func compileFSFile(fsys fs.FS, name string, out func(name string) (io.WriteCloser, error)) (err error) {
+	input, err := fsys.Open(name)
+	if err != nil {
+		return fmt.Errorf("Could not open file %q for reading: %v", name, err)
+	}
+	defer input.Close()
+
+	outputName := getOutputPath(name, ".vm")
+	output, err := out(outputName)
+	if err != nil {
+		return fmt.Errorf("Could not open output file %q for writing: %v", outputName, err)
+	}
+	defer func() {
+		if closeErr := output.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("Could not write output file %q: %v", outputName, closeErr)
+		}
+	}()
+
+	tokenizer := NewTokenizer(input)
+	_, err = compileFile(name, &tokenizer, output, CompilerOptions{})
+	return err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// closingBuilder is an in-memory io.WriteCloser.
+type closingBuilder struct {
+	strings.Builder
+	closed bool
+}
+
+func (b *closingBuilder) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestCompileFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"src/Main.jack":   {Data: []byte("class Main { function void main() { do Util.f(); return; } }\n")},
+		"src/Util.jack":   {Data: []byte("class Util { function void f() { return; } }\n")},
+		"src/Broken.jack": {Data: []byte("class Broken { function void f() { return } }\n")},
+		"src/README.md":   {Data: []byte("not compiled")},
+	}
+	outputs := make(map[string]*closingBuilder)
+	out := func(name string) (io.WriteCloser, error) {
+		outputs[name] = &closingBuilder{}
+		return outputs[name], nil
+	}
+
+	err := CompileFS(fsys, "src", out)
+	errs, ok := err.(CompileErrors)
+	if !ok || len(errs) != 1 || !strings.HasPrefix(errs[0].Error(), "src/Broken.jack: ") {
+		t.Errorf("error %v, want only the error of src/Broken.jack", err)
+	}
+
+	want := map[string]string{
+		"src/Main.vm": "function Main.main 0\ncall Util.f 0\npop temp 0\npush constant 0\nreturn\n",
+		"src/Util.vm": "function Util.f 0\npush constant 0\nreturn\n",
+	}
+	for name, vm := range want {
+		output, ok := outputs[name]
+		if !ok {
+			t.Errorf("%s not written", name)
+			continue
+		}
+		if output.String() != vm || !output.closed {
+			t.Errorf("%s is %q, closed %v, want %q closed", name, output.String(), output.closed, vm)
+		}
+	}
+	if _, ok := outputs["src/README.vm"]; ok {
+		t.Error("compiled src/README.md")
+	}
+
+	outputs = make(map[string]*closingBuilder)
+	if err := CompileFS(fsys, "src/Util.jack", out); err != nil || len(outputs) != 1 || outputs["src/Util.vm"] == nil {
+		t.Errorf("compiling a single file wrote %v, %v, want src/Util.vm", outputs, err)
+	}
+}
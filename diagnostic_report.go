@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+)
+
+// DiagnosticReport collects the diagnostics of several files.
+type DiagnosticReport struct {
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// Add adds diagnostics found in file.
+func (r *DiagnosticReport) Add(file string, diagnostics ...Diagnostic) {
+	for _, diagnostic := range diagnostics {
+		diagnostic.File = file
+		r.Diagnostics = append(r.Diagnostics, diagnostic)
+	}
+}
+
+func (r *DiagnosticReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// The subset of SARIF 2.1.0 written by WriteSARIF
+type (
+	sarifLog struct {
+		Schema  string     `json:"$schema"`
+		Version string     `json:"version"`
+		Runs    []sarifRun `json:"runs"`
+	}
+	sarifRun struct {
+		Tool    sarifTool     `json:"tool"`
+		Results []sarifResult `json:"results"`
+	}
+	sarifTool struct {
+		Driver struct {
+			Name string `json:"name"`
+		} `json:"driver"`
+	}
+	sarifResult struct {
+		Level     string          `json:"level"`
+		Message   sarifMessage    `json:"message"`
+		Locations []sarifLocation `json:"locations,omitempty"`
+	}
+	sarifMessage struct {
+		Text string `json:"text"`
+	}
+	sarifLocation struct {
+		PhysicalLocation struct {
+			ArtifactLocation struct {
+				URI string `json:"uri"`
+			} `json:"artifactLocation"`
+			Region *sarifRegion `json:"region,omitempty"`
+		} `json:"physicalLocation"`
+	}
+	sarifRegion struct {
+		StartLine   int `json:"startLine"`
+		StartColumn int `json:"startColumn"`
+		EndLine     int `json:"endLine"`
+		EndColumn   int `json:"endColumn"`
+	}
+)
+
+// sarifLevels maps severities to SARIF result levels
+var sarifLevels = map[Severity]string{
+	ErrorSeverity:   "error",
+	WarningSeverity: "warning",
+	InfoSeverity:    "note",
+}
+
+// WriteSARIF writes the diagnostics as a SARIF log for code scanning tools.
+func (r *DiagnosticReport) WriteSARIF(w io.Writer) error {
+	run := sarifRun{Results: []sarifResult{}}
+	run.Tool.Driver.Name = "jackcompiler"
+	for _, diagnostic := range r.Diagnostics {
+		result := sarifResult{Level: sarifLevels[diagnostic.Severity], Message: sarifMessage{Text: diagnostic.Message}}
+		if diagnostic.File != "" {
+			var location sarifLocation
+			location.PhysicalLocation.ArtifactLocation.URI = filepath.ToSlash(diagnostic.File)
+			// Lines start at 1, 0 means the position is unknown
+			if start, end := diagnostic.Range.Start, diagnostic.Range.End; start.Line > 0 {
+				location.PhysicalLocation.Region = &sarifRegion{StartLine: start.Line, StartColumn: start.Column, EndLine: end.Line, EndColumn: end.Column}
+			}
+			result.Locations = []sarifLocation{location}
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(sarifLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	})
+}
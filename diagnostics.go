@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+type Severity string
+
+const (
+	ErrorSeverity   Severity = "error"
+	WarningSeverity Severity = "warning"
+	InfoSeverity    Severity = "info"
+)
+
+// Range of source text, End is the position just after its last character.
+type Range struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+	// File the diagnostic refers to, empty if unknown
+	File  string `json:"file,omitempty"`
+	Range Range  `json:"range"`
+}
+
+func (d Diagnostic) String() string {
+	if d.File != "" {
+		return fmt.Sprintf("%s: %s: %s: %s", d.File, d.Range.Start, d.Severity, d.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", d.Range.Start, d.Severity, d.Message)
+}
+
+// textRange returns the range of text starting at start. text must not
+// span multiple lines.
+func textRange(start Position, text string) Range {
+	end := start
+	end.Column += len([]rune(text))
+	return Range{Start: start, End: end}
+}
+
+func tokenRange(token Token) Range {
+	switch {
+	case IsTokenType(token, StringConstant):
+		return textRange(token.position, `"`+token.terminal+`"`)
+	case IsTokenType(token, CharConstant):
+		return textRange(token.position, "'"+token.terminal+"'")
+	}
+	return textRange(token.position, token.terminal)
+}
+
+// Diagnostics compiles the class read from r without emitting code and
+// returns all errors and warnings found. Errors within statements are
+// recovered from, so independent errors are reported together, up to
+// defaultMaxErrors of them. Any other error ends the analysis.
+func Diagnostics(r io.Reader, filename string) []Diagnostic {
+	tokenizer := NewTokenizer(r)
+	compiler := NewJackCompiler(&tokenizer, NullWriter{})
+	compiler.Filename = filename
+	compiler.Options = CompilerOptions{
+		WarnTypes:          true,
+		WarnChainedCompare: true,
+		WarnUnused:         true,
+		RecoverErrors:      true,
+		MaxErrors:          defaultMaxErrors,
+	}
+	compiler.Compile()
+	return compiler.diagnostics
+}
+
+// CompileError is an error at a position of the source.
+type CompileError struct {
+	Message  string
+	Position Position
+	// End of the source text in error, just after its last character. Zero
+	// if the error refers to the single character at Position.
+	End Position
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("%s at %s", e.Message, e.Position)
+}
+
+// Range returns the range of the source text in error.
+func (e *CompileError) Range() Range {
+	end := e.End
+	if end == (Position{}) {
+		end = e.Position
+		end.Column += 1
+	}
+	return Range{Start: e.Position, End: end}
+}
+
+// tokenError returns a CompileError about token.
+func tokenError(token Token, message string) *CompileError {
+	r := tokenRange(token)
+	return &CompileError{Message: message, Position: r.Start, End: r.End}
+}
+
+// unexpectedTokenError reports token found instead of expectedTerminal.
+func unexpectedTokenError(token Token, expectedTerminal string) *CompileError {
+	return tokenError(token, fmt.Sprintf("Expected terminal %q, got %q", expectedTerminal, token.terminal))
+}
+
+// braceStack holds the positions of the "{" not closed yet, for reporting
+// where an unbalanced brace was opened.
+type braceStack []Position
+
+// track pushes the position of an opening brace and pops it for a closing one.
+func (s *braceStack) track(token Token) {
+	switch {
+	case IsTerminal(token, "{"):
+		*s = append(*s, token.position)
+	case IsTerminal(token, "}") && len(*s) > 0:
+		*s = (*s)[:len(*s)-1]
+	}
+}
+
+// endOfSourceError is the error for a source ending unexpectedly, pointing
+// to the innermost unclosed brace if any.
+func (s braceStack) endOfSourceError() any {
+	if len(s) == 0 {
+		return "unexpected end of source"
+	}
+	return &CompileError{Message: "unclosed '{' opened", Position: s[len(s)-1]}
+}
+
+// unmatchedBraceError reports a "}" following the end of the class.
+func unmatchedBraceError(token Token) *CompileError {
+	return tokenError(token, "'}' without matching '{'")
+}
+
+// diagnosticRange returns the source range a recovered panic refers to, the
+// range of a CompileError or else of current.
+func diagnosticRange(r any, current Token) Range {
+	if err, ok := r.(*CompileError); ok {
+		return err.Range()
+	}
+	return tokenRange(current)
+}
+
+// panicError returns the value of a recovered panic as an error, keeping the
+// range of a CompileError.
+func panicError(r any) error {
+	if err, ok := r.(error); ok {
+		return err
+	}
+	return fmt.Errorf("%v", r)
+}
+
+// diagnosticMessage formats the value of a recovered panic. The position of
+// a CompileError is left out as diagnostics carry their range.
+func diagnosticMessage(r any) string {
+	if err, ok := r.(*CompileError); ok {
+		return err.Message
+	}
+	return strings.TrimSpace(fmt.Sprint(r))
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestStrayCharacterError(t *testing.T) {
+	source := "class Main {\n    function void main() {\n        var int x;\n        let x = 1 @ 2;\n        return;\n    }\n}\n"
+	_, _, err := compileSource("Main.jack", source, CompilerOptions{})
+	want := "unexpected character '@' at line 4, col 19"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("error %v, want it to contain %q", err, want)
+	}
+}
+
+func TestStrayCharacterDiagnosticRange(t *testing.T) {
+	source := "class Main {\n    function void main() {\n        var int x;\n        let x = 1 @ 2;\n        return;\n    }\n}\n"
+	diagnostics := Diagnostics(strings.NewReader(source), "Main.jack")
+	var errors []Diagnostic
+	for _, diagnostic := range diagnostics {
+		if diagnostic.Severity == ErrorSeverity {
+			errors = append(errors, diagnostic)
+		}
+	}
+	if len(errors) != 1 {
+		t.Fatalf("errors %v, want exactly one", errors)
+	}
+	want := Range{Start: Position{Line: 4, Column: 19}, End: Position{Line: 4, Column: 20}}
+	if errors[0].Range != want || errors[0].Message != "unexpected character '@'" {
+		t.Errorf("error %q at %v, want \"unexpected character '@'\" at %v", errors[0].Message, errors[0].Range, want)
+	}
+}
+
+func TestCompileErrorRangeSpansToken(t *testing.T) {
+	token := NewToken(Identifier, "count", Position{Line: 2, Column: 5})
+	got := tokenError(token, "message").Range()
+	want := Range{Start: Position{Line: 2, Column: 5}, End: Position{Line: 2, Column: 10}}
+	if got != want {
+		t.Errorf("Range() = %v, want %v", got, want)
+	}
+}
+
+func TestUnterminatedStringRange(t *testing.T) {
+	tokenizer := NewTokenizer(strings.NewReader(`let s = "abc`))
+	for tokenizer.Scan() {
+	}
+	err, ok := tokenizer.Err().(*CompileError)
+	if !ok {
+		t.Fatalf("Err() = %v, want a *CompileError", tokenizer.Err())
+	}
+	want := Range{Start: Position{Line: 1, Column: 9}, End: Position{Line: 1, Column: 13}}
+	if err.Range() != want {
+		t.Errorf("Range() = %v, want %v", err.Range(), want)
+	}
+}
+
+func TestDiagnosticsReportsAllProblems(t *testing.T) {
+	source := "class Main {\n  function int f() {\n    var int unused;\n    let x = 1;\n    do Output.printInt(;\n  }\n}"
+	want := []struct {
+		severity Severity
+		message  string
+		start    Position
+	}{
+		{ErrorSeverity, "cannot assign to undeclared variable x in Main.f", Position{Line: 4, Column: 9}},
+		{ErrorSeverity, `expected term, got ";"`, Position{Line: 5, Column: 24}},
+		{WarningSeverity, "subroutine Main.f of type int may end without returning a value", Position{Line: 6, Column: 3}},
+		{WarningSeverity, `unused variable "unused" in Main.f`, Position{Line: 3, Column: 13}},
+	}
+	diagnostics := Diagnostics(strings.NewReader(source), "Main.jack")
+	if len(diagnostics) != len(want) {
+		t.Fatalf("diagnostics %v, want %d", diagnostics, len(want))
+	}
+	for i, diagnostic := range diagnostics {
+		if diagnostic.Severity != want[i].severity || diagnostic.Message != want[i].message || diagnostic.Range.Start != want[i].start {
+			t.Errorf("diagnostic %d is %s %q at %v, want %s %q at %v", i, diagnostic.Severity, diagnostic.Message, diagnostic.Range.Start, want[i].severity, want[i].message, want[i].start)
+		}
+	}
+}
+
+func TestMaxErrors(t *testing.T) {
+	source := "class Main {\n  function void f() {\n" + strings.Repeat("    let x = 1;\n", 30) + "    return;\n  }\n}\n"
+	diagnostics := Diagnostics(strings.NewReader(source), "Main.jack")
+	if len(diagnostics) != defaultMaxErrors+1 {
+		t.Fatalf("%d diagnostics, want %d errors and a note", len(diagnostics), defaultMaxErrors)
+	}
+	for _, diagnostic := range diagnostics[:defaultMaxErrors] {
+		if diagnostic.Severity != ErrorSeverity {
+			t.Errorf("diagnostic %v, want an error", diagnostic)
+		}
+	}
+	note := diagnostics[defaultMaxErrors]
+	if note.Severity != InfoSeverity || note.Message != "too many errors, stopped after 20" {
+		t.Errorf("last diagnostic %v, want the too many errors note", note)
+	}
+
+	_, _, err := compileSource("Main.jack", source, CompilerOptions{RecoverErrors: true, MaxErrors: 3})
+	want := "Main.jack: cannot assign to undeclared variable x in Main.f at line 3, col 9\n" +
+		"Main.jack: cannot assign to undeclared variable x in Main.f at line 4, col 9\n" +
+		"Main.jack: cannot assign to undeclared variable x in Main.f at line 5, col 9\n" +
+		"Main.jack: too many errors, stopped after 3"
+	if err == nil || err.Error() != want {
+		t.Errorf("error\n%v\nwant\n%s", err, want)
+	}
+	if _, _, err := compileSource("Main.jack", source, CompilerOptions{RecoverErrors: true}); err == nil || strings.Count(err.Error(), "\n") != 29 {
+		t.Errorf("without a limit error\n%v\nwant all 30 errors", err)
+	}
+}
+
+func TestUnknownVariablePosition(t *testing.T) {
+	source := "class Main {\n  function void f() {\n    var int x;\n    let x = yy + 1;\n    return;\n  }\n}\n"
+	want := Range{Start: Position{Line: 4, Column: 13}, End: Position{Line: 4, Column: 15}}
+	diagnostics := Diagnostics(strings.NewReader(source), "Main.jack")
+	if len(diagnostics) == 0 || diagnostics[0].Message != `Unknown variable: "yy"` || diagnostics[0].Range != want {
+		t.Errorf("diagnostics %v, want the unknown variable at %v", diagnostics, want)
+	}
+
+	for _, options := range []CompilerOptions{{}, {FoldConstants: true}} {
+		_, _, err := compileSource("Main.jack", source, options)
+		if want := `Main.jack: Unknown variable: "yy" at line 4, col 13`; err == nil || err.Error() != want {
+			t.Errorf("FoldConstants %v: error %v, want %q", options.FoldConstants, err, want)
+		}
+	}
+
+	// The CodeGenerator reports it alike without a preceding check
+	_, err := generateSource(source)
+	compileErr, ok := err.(*CompileError)
+	if !ok || compileErr.Message != `Unknown variable: "yy"` || compileErr.Range() != want {
+		t.Errorf("CodeGenerator error %#v, want the unknown variable at %v", err, want)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+type SubroutineDoc struct {
+	// Name qualified by the class name, e.g. "Main.main"
+	Name      string `json:"name"`
+	Signature string `json:"signature"`
+	Doc       string `json:"doc"`
+}
+
+type ClassDoc struct {
+	Name        string          `json:"name"`
+	Doc         string          `json:"doc"`
+	Subroutines []SubroutineDoc `json:"subroutines"`
+}
+
+// Documentation lists the declarations of classes with the /** */ comments
+// immediately preceding them.
+type Documentation struct {
+	Classes []ClassDoc `json:"classes"`
+}
+
+// docScanner skips comments, remembering doc comments by the position of
+// the token following them.
+type docScanner struct {
+	TokenScanner
+	docs map[Position]string
+}
+
+func (s *docScanner) Scan() bool {
+	doc := ""
+	for s.TokenScanner.Scan() {
+		token := s.Token()
+		if !IsTokenType(token, Comment) {
+			if doc != "" {
+				s.docs[token.position] = doc
+			}
+			return true
+		}
+		doc = ""
+		if strings.HasPrefix(token.terminal, "/**") {
+			doc = token.terminal
+		}
+	}
+	return false
+}
+
+// docText strips the delimiters and leading asterisks of the lines of a doc
+// comment.
+func docText(comment string) string {
+	comment = strings.TrimSuffix(strings.TrimPrefix(comment, "/**"), "*/")
+	lines := strings.Split(comment, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "*"))
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// Add parses the class read from r and adds its documentation.
+func (d *Documentation) Add(r io.Reader) error {
+	tokenizer := NewCommentTokenizer(r)
+	scanner := &docScanner{TokenScanner: &tokenizer, docs: make(map[Position]string)}
+	class, err := Parse(scanner)
+	if err != nil {
+		return err
+	}
+
+	classDoc := ClassDoc{
+		Name:        class.Name,
+		Doc:         docText(scanner.docs[class.Position]),
+		Subroutines: []SubroutineDoc{},
+	}
+	for _, subroutine := range class.Subroutines {
+		parameters := make([]string, len(subroutine.Parameters))
+		for i, parameter := range subroutine.Parameters {
+			parameters[i] = parameter.Type + " " + parameter.Name
+		}
+		classDoc.Subroutines = append(classDoc.Subroutines, SubroutineDoc{
+			Name:      class.Name + "." + subroutine.Name,
+			Signature: fmt.Sprintf("%s %s %s(%s)", subroutine.Kind, subroutine.ReturnType, subroutine.Name, strings.Join(parameters, ", ")),
+			Doc:       docText(scanner.docs[subroutine.Position]),
+		})
+	}
+	d.Classes = append(d.Classes, classDoc)
+	return nil
+}
+
+func (d *Documentation) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(d)
+}
+
+func (d *Documentation) WriteMarkdown(w io.Writer) error {
+	for _, class := range d.Classes {
+		if _, err := fmt.Fprintf(w, "# %s\n\n", class.Name); err != nil {
+			return err
+		}
+		if class.Doc != "" {
+			if _, err := fmt.Fprintf(w, "%s\n\n", class.Doc); err != nil {
+				return err
+			}
+		}
+		for _, subroutine := range class.Subroutines {
+			if _, err := fmt.Fprintf(w, "## %s\n\n`%s`\n\n", subroutine.Name, subroutine.Signature); err != nil {
+				return err
+			}
+			if subroutine.Doc != "" {
+				if _, err := fmt.Fprintf(w, "%s\n\n", subroutine.Doc); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const documentedSource = `/** A point
+ * in the plane. */
+class Point {
+    field int x;
+
+    /** Returns the x coordinate. */
+    method int getX() {
+        return x;
+    }
+
+    /* Not a doc comment */
+    function void reset() {
+        return;
+    }
+
+    /** Separated from its subroutine */
+    // by another comment
+    method void dispose() {
+        return;
+    }
+}
+`
+
+func TestDocumentation(t *testing.T) {
+	documentation := &Documentation{}
+	if err := documentation.Add(strings.NewReader(documentedSource)); err != nil {
+		t.Fatal(err)
+	}
+	want := []ClassDoc{{
+		Name: "Point",
+		Doc:  "A point\nin the plane.",
+		Subroutines: []SubroutineDoc{
+			{Name: "Point.getX", Signature: "method int getX()", Doc: "Returns the x coordinate."},
+			{Name: "Point.reset", Signature: "function void reset()"},
+			{Name: "Point.dispose", Signature: "method void dispose()"},
+		},
+	}}
+	if !reflect.DeepEqual(documentation.Classes, want) {
+		t.Errorf("documentation %+v, want %+v", documentation.Classes, want)
+	}
+
+	var markdown strings.Builder
+	if err := documentation.WriteMarkdown(&markdown); err != nil {
+		t.Fatal(err)
+	}
+	if want := "## Point.getX\n\n`method int getX()`\n\nReturns the x coordinate.\n\n"; !strings.Contains(markdown.String(), want) {
+		t.Errorf("Markdown\n%s\nlacks\n%s", markdown.String(), want)
+	}
+}
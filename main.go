@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 func removeExtension(filePath string) string {
@@ -17,38 +22,379 @@ func getClassName(filePath string) string {
 	return removeExtension(filepath.Base(filePath))
 }
 
-func getOutputPath(filePath string) string {
-	return removeExtension(filePath) + ".vm"
+// getOutputPath replaces the extension of filePath by extension, which
+// includes the leading dot.
+func getOutputPath(filePath string, extension string) string {
+	return removeExtension(filePath) + extension
 }
 
-func compileFile(r io.Reader, w io.Writer) {
-	tokenizer := NewTokenizer(r)
-	writer := NewVMWriter(w)
+// countingScanner counts the tokens scanned by the wrapped TokenScanner.
+type countingScanner struct {
+	TokenScanner
+	count int
+}
+
+func (s *countingScanner) Scan() bool {
+	if !s.TokenScanner.Scan() {
+		return false
+	}
+	if !IsTokenType(s.Token(), EOF) {
+		s.count += 1
+	}
+	return true
+}
+
+func compileFile(filename string, tokenizer TokenScanner, w io.Writer, options CompilerOptions) (warnings []string, err error) {
+	vmWriter := NewVMWriter(w)
+	vmWriter.SeparateFunctions = options.SeparateFunctions
+	return compileToWriter(filename, tokenizer, &vmWriter, options)
+}
+
+// compileToWriter compiles the class read from tokenizer to writer, wrapped
+// by the writers the options require.
+func compileToWriter(filename string, tokenizer TokenScanner, writer OutputWriter, options CompilerOptions) (warnings []string, err error) {
+	if options.Stubs != nil {
+		writer = options.Stubs.Writer(writer)
+	}
+
+	if options.PoolStrings {
+		writer = NewStringPoolWriter(writer)
+	}
+
+	if options.Peephole {
+		writer = NewPeepholeWriter(writer)
+	}
+
+	if options.FoldConstants {
+		return foldToWriter(filename, tokenizer, writer, options)
+	}
 
-	compiler := NewJackCompiler(&tokenizer, &writer)
-	compiler.Compile()
+	compiler := NewJackCompiler(tokenizer, writer)
+	compiler.Options = options
+	compiler.Filename = filename
+	err = compiler.Compile()
+	return compiler.Warnings(), err
 }
 
-func processFile(path string) (outputPath string, err error) {
+// foldToWriter compiles the class read from tokenizer to writer through its
+// syntax tree, folding constants. The JackCompiler checks the class first,
+// without output, so both pipelines report the same errors and warnings.
+func foldToWriter(filename string, tokenizer TokenScanner, writer OutputWriter, options CompilerOptions) (warnings []string, err error) {
+	// The checker advances SharedStatics past the class
+	var staticBase MachineWord
+	if options.SharedStatics != nil {
+		staticBase = *options.SharedStatics
+	}
+	recorder := &tokenRecorder{TokenScanner: tokenizer}
+	checker := NewJackCompiler(recorder, NullWriter{})
+	checker.Options = options
+	// Collected by the CodeGenerator
+	checker.Options.CallGraph = nil
+	checker.Options.SymbolReport = nil
+	checker.Filename = filename
+	if err := checker.Compile(); err != nil {
+		return checker.Warnings(), err
+	}
+
+	class, err := Parse(newTokenBuffer(recorder.tokens))
+	if err == nil {
+		err = FoldConstants(class)
+	}
+	if err == nil {
+		generator := NewCodeGenerator(writer)
+		generator.CallGraph = options.CallGraph
+		generator.SymbolReport = options.SymbolReport
+		generator.Only = options.Only
+		generator.CheckBounds = options.CheckBounds
+		generator.Annotate = options.Annotate
+		generator.ExtOperators = options.ExtOperators
+		generator.DropDeadCode = options.DropDeadCode
+		generator.StaticBase = staticBase
+		err = generator.Generate(class)
+	}
+	if err != nil {
+		if options.DiagnosticReport != nil {
+			options.DiagnosticReport.Add(filename, Diagnostic{Severity: ErrorSeverity, Message: diagnosticMessage(err), Range: diagnosticRange(err, Token{})})
+		}
+		return checker.Warnings(), fmt.Errorf("%s: %v", filename, err)
+	}
+	return checker.Warnings(), nil
+}
+
+// processFile compiles the file at path into outputPath. Reports the number
+// of tokens and the time taken to verbose if not nil.
+func processFile(path string, outputPath string, options CompilerOptions, verbose io.Writer) (warnings []string, err error) {
 	// Open file for reading
 	handle, openErr := os.Open(path)
 	if openErr != nil {
-		return "", fmt.Errorf("Could not open file %q for reading: %v", path, err)
+		return nil, fmt.Errorf("Could not open file %q for reading: %v", path, openErr)
 	}
 	defer handle.Close()
 
 	// Open file for writing
-	outputPath = getOutputPath(path)
 	output, openErr := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if openErr != nil {
+		return nil, fmt.Errorf("Could not open output file %q for writing: %v", outputPath, openErr)
+	}
+	defer output.Close()
+
+	vmWriter := NewVMWriter(output)
+	vmWriter.SeparateFunctions = options.SeparateFunctions
+	return translateFile(path, handle, &vmWriter, options, verbose)
+}
+
+// translateFile compiles the source read from r, named path, to writer.
+func translateFile(path string, r io.Reader, writer OutputWriter, options CompilerOptions, verbose io.Writer) (warnings []string, err error) {
+	start := time.Now()
+	tokenizer := NewTokenizer(r)
+	tokenizer.SetTabWidth(options.TabWidth)
+	scanner := &countingScanner{TokenScanner: &tokenizer}
+	warnings, err = compileToWriter(path, scanner, writer, options)
+	if verbose != nil {
+		fmt.Fprintf(verbose, "%s: %d tokens in %v\n", path, scanner.count, time.Since(start))
+	}
+	return warnings, err
+}
+
+// checkFile compiles the file at path without writing any output.
+func checkFile(path string, options CompilerOptions, verbose io.Writer) (warnings []string, err error) {
+	handle, openErr := os.Open(path)
+	if openErr != nil {
+		return nil, fmt.Errorf("Could not open file %q for reading: %v", path, openErr)
+	}
+	defer handle.Close()
+
+	return translateFile(path, handle, NullWriter{}, options, verbose)
+}
+
+// streamFile compiles the file at path and writes its VM code to w, preceded
+// by a comment naming the file. Nothing is written if compilation fails.
+func streamFile(path string, w io.Writer, options CompilerOptions, verbose io.Writer) (warnings []string, err error) {
+	handle, openErr := os.Open(path)
+	if openErr != nil {
+		return nil, fmt.Errorf("Could not open file %q for reading: %v", path, openErr)
+	}
+	defer handle.Close()
+
+	var output bytes.Buffer
+	fmt.Fprintf(&output, "// ==== %s ====\n", filepath.Base(path))
+	vmWriter := NewVMWriter(&output)
+	vmWriter.SeparateFunctions = options.SeparateFunctions
+	warnings, err = translateFile(path, handle, &vmWriter, options, verbose)
+	if err != nil {
+		return warnings, err
+	}
+	_, err = output.WriteTo(w)
+	return warnings, err
+}
+
+func writeCallGraph(path string, callGraph *CallGraph) error {
+	output, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Could not open output file %q for writing: %v", path, err)
+	}
+	defer output.Close()
+
+	if err := callGraph.WriteDOT(output); err != nil {
+		return fmt.Errorf("Could not write call graph to %q: %v", path, err)
+	}
+	return nil
+}
+
+func writeMathRoutines(path string) error {
+	output, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("Could not open output file %q for writing: %v", path, err)
+	}
+	defer output.Close()
+
+	if err := WriteMathRoutines(output); err != nil {
+		return fmt.Errorf("Could not write Math routines to %q: %v", path, err)
+	}
+	return nil
+}
+
+// writeStubs writes the stubs of the functions missing from stubs to the file
+// at path.
+func writeStubs(path string, stubs *Stubs) error {
+	output, err := os.Create(path)
 	if err != nil {
-		return outputPath, fmt.Errorf("Could not open output file %q for writing: %v", outputPath, err)
+		return fmt.Errorf("Could not open output file %q for writing: %v", path, err)
 	}
 	defer output.Close()
 
-	// Translate
-	compileFile(handle, output)
+	if err := stubs.WriteVM(output); err != nil {
+		return fmt.Errorf("Could not write stubs to %q: %v", path, err)
+	}
+	return nil
+}
+
+// writeDocumentation prints the documentation of the .jack files among files
+// and returns the process exit code.
+func writeDocumentation(files []string, format string) int {
+	documentation := &Documentation{Classes: []ClassDoc{}}
+	failed := false
+	for _, file := range files {
+		if filepath.Ext(file) != ".jack" {
+			continue
+		}
+		handle, err := os.Open(file)
+		if err != nil {
+			fmt.Printf("Could not open file %q for reading: %v\n", file, err)
+			failed = true
+			continue
+		}
+		err = documentation.Add(handle)
+		handle.Close()
+		if err != nil {
+			fmt.Printf("Failed to parse %q: %s\n", file, err)
+			failed = true
+		}
+	}
+
+	var err error
+	if format == "json" {
+		err = documentation.WriteJSON(os.Stdout)
+	} else {
+		err = documentation.WriteMarkdown(os.Stdout)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// writeStats prints the statistics of the .jack files among files and
+// returns the process exit code.
+func writeStats(files []string, format string) int {
+	stats := &SourceStats{Files: []FileStats{}}
+	failed := false
+	for _, file := range files {
+		if filepath.Ext(file) != ".jack" {
+			continue
+		}
+		handle, err := os.Open(file)
+		if err != nil {
+			fmt.Printf("Could not open file %q for reading: %v\n", file, err)
+			failed = true
+			continue
+		}
+		err = stats.Add(file, handle)
+		handle.Close()
+		if err != nil {
+			fmt.Printf("Failed to parse %q: %s\n", file, err)
+			failed = true
+		}
+	}
+
+	var err error
+	if format == "json" {
+		err = stats.WriteJSON(os.Stdout)
+	} else {
+		err = stats.WriteText(os.Stdout)
+	}
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
 
-	return outputPath, nil
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// writeTokens prints the tokens of the .jack files among files as JSON and
+// returns the process exit code.
+func writeTokens(files []string, tabWidth int) int {
+	dump := &TokenDump{Files: []FileTokens{}, TabWidth: tabWidth}
+	failed := false
+	for _, file := range files {
+		if filepath.Ext(file) != ".jack" {
+			continue
+		}
+		handle, err := os.Open(file)
+		if err != nil {
+			fmt.Printf("Could not open file %q for reading: %v\n", file, err)
+			failed = true
+			continue
+		}
+		err = dump.Add(file, handle)
+		handle.Close()
+		if err != nil {
+			fmt.Printf("Failed to tokenize %q: %s\n", file, err)
+			failed = true
+		}
+	}
+
+	if err := dump.WriteJSON(os.Stdout); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// watchInterval is the time between two polls of the watched files
+var watchInterval = 500 * time.Millisecond
+
+// modTimes returns the modification times of the files in the files and
+// directories paths.
+func modTimes(paths []string) map[string]time.Time {
+	times := make(map[string]time.Time)
+	files, _ := collectAllFiles(paths)
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			times[file] = info.ModTime()
+		}
+	}
+	return times
+}
+
+// watchFiles polls the files in the files and directories paths every
+// interval until stop is closed and calls compile for every file modified
+// since it was last compiled. A file is compiled once it was left unchanged
+// for an interval, so rapid saves are compiled only once.
+func watchFiles(paths []string, interval time.Duration, stop <-chan struct{}, compile func(file string)) {
+	compiled := modTimes(paths)
+	previous := modTimes(paths)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		current := modTimes(paths)
+		for file, modTime := range current {
+			if modTime.Equal(previous[file]) && !modTime.Equal(compiled[file]) {
+				compiled[file] = modTime
+				compile(file)
+			}
+		}
+		previous = current
+	}
+}
+
+// upToDate reports whether outputPath exists and was modified no earlier
+// than the source at path, like make does.
+func upToDate(path string, outputPath string) bool {
+	source, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	output, err := os.Stat(outputPath)
+	return err == nil && !output.ModTime().Before(source.ModTime())
 }
 
 func collectFiles(fileOrDir string) (files []string, err error) {
@@ -76,31 +422,425 @@ func collectFiles(fileOrDir string) (files []string, err error) {
 	return
 }
 
-func main() {
-	filename := flag.String("d", "", ".jack file to compile or directory containing .jack files")
+// collectAllFiles returns the files of all paths, see collectFiles.
+func collectAllFiles(paths []string) (files []string, err error) {
+	for _, path := range paths {
+		pathFiles, err := collectFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, pathFiles...)
+	}
+	return files, nil
+}
 
-	flag.Parse()
+// run compiles the files given by the command line arguments args and returns
+// the process exit code.
+func run(args []string) int {
+	flags := flag.NewFlagSet("jackcompiler", flag.ContinueOnError)
+	filename := flags.String("d", "", ".jack file to compile or directory containing .jack files, more of them may follow the flags as arguments")
+	warnTypes := flags.Bool("warn-types", false, "warn about arithmetic on booleans and boolean array indices")
+	foldConstants := flags.Bool("fold-constants", false, "evaluate constant integer expressions at compile time")
+	dropDeadCode := flags.Bool("drop-dead-code", false, "omit unreachable statements following a return")
+	peephole := flags.Bool("peephole", false, "remove redundant VM command sequences")
+	poolStrings := flags.Bool("pool-strings", false, "build repeated string constants only once per function")
+	strictClassNames := flags.Bool("strict-class-names", false, "fail if a class is not declared in a file of the same name")
+	relaxedVarDecs := flags.Bool("relaxed-vars", false, "allow var declarations between statements")
+	warnStyle := flags.Bool("warn-style", false, "warn about empty if, else and while bodies and self-assignments like let x = x;")
+	warnChainedCompare := flags.Bool("warn-chained-compare", false, "warn about comparisons of comparison results like a < b < c")
+	callGraphPath := flags.String("callgraph", "", "write the call graph of the compiled files in DOT format to this file")
+	symbols := flags.Bool("symbols", false, "print the symbols of each compiled class")
+	doc := flags.Bool("doc", false, "print the documentation comments of the classes in Markdown instead of compiling them")
+	stats := flags.Bool("stats", false, "print the number of tokens, subroutines, fields, statics and lines of code of each file instead of compiling them")
+	symbolsFormat := flags.String("format", "text", "format of the -symbols, -doc and -stats reports, \"text\" or \"json\"")
+	keepGoing := flags.Bool("keep-going", true, "continue compiling the remaining files after a failure")
+	failFast := flags.Bool("fail-fast", false, "stop at the first file that fails to compile, overrides -keep-going")
+	extension := flags.String("ext", ".vm", "extension of the output files")
+	var dryRun bool
+	flags.BoolVar(&dryRun, "dry-run", false, "list the files that would be compiled and their output files without compiling")
+	flags.BoolVar(&dryRun, "n", false, "shorthand for -dry-run")
+	deps := flags.Bool("deps", false, "check the files without writing any output and print the functions they call that no compiled class defines, e.g. those of the OS")
+	emitStubs := flags.Bool("emit-stubs", false, "write stubs returning 0 for the called functions no compiled class defines, e.g. those of the OS, to Stubs.vm next to the compiled files")
+	emitMath := flags.Bool("emit-math", false, "write Math.multiply, Math.divide and Math.mod next to the compiled files for running without the OS")
+	tokensJSON := flags.Bool("tokens-json", false, "print the tokens of each file with their type and position as JSON instead of compiling them")
+	verbose := flags.Bool("v", false, "print the number of tokens and compile time of each file to stderr")
+	strictCalls := flags.Bool("strict-calls", false, "fail if a subroutine of the compiled class is called as method but is none or vice versa")
+	only := flags.String("only", "", "compile only the subroutine with this name, e.g. Main.main, and only the file of its class")
+	recoverErrors := flags.Bool("recover", false, "report all errors within statements of a file instead of only the first")
+	maxErrors := flags.Int("max-errors", defaultMaxErrors, "stop compiling a file with -recover after this many errors, 0 for no limit")
+	shortCircuit := flags.Bool("short-circuit", false, "skip the right operand of & and | on boolean operands if the left one determines the result")
+	checkBounds := flags.Bool("check-bounds", false, "call Array.checkBounds(index, array), which has to be provided by the program, before every array access")
+	diagnosticsFormat := flags.String("diagnostics-format", "", "print the errors and warnings of all files to stdout at the end, \"json\" or \"sarif\", not with -watch")
+	separateFunctions := flags.Bool("separate-functions", false, "precede each function in the output by a blank line and a comment naming it")
+	watch := flags.Bool("watch", false, "recompile changed files until interrupted")
+	check := flags.Bool("check", false, "check the files for errors without writing any output")
+	multipleClasses := flags.Bool("multiple-classes", false, "allow several classes in one file")
+	stdoutVM := flags.Bool("stdout-vm", false, "print the VM code of all files to stdout instead of writing .vm files, progress messages go to stderr")
+	single := flags.String("single", "", "write the VM code of all files to this file instead of one .vm file per class")
+	incremental := flags.Bool("incremental", false, "skip files whose output file is not older than the source")
+	force := flags.Bool("force", false, "compile all files even with -incremental")
+	tabWidth := flags.Int("tabwidth", 1, "distance of the tab stops in reported columns, 1 counts a tab as one column")
+	annotate := flags.Bool("annotate", false, "follow every push and pop of a variable by a comment naming it")
+	extOperators := flags.Bool("ext-operators", false, "allow the operator %, compiled to a call of Math.mod, which -emit-math provides")
+	var includeDirs []string
+	flags.Func("I", "check calls to the classes of the .jack files in this directory, e.g. the OS, for the kind and number of arguments, may be repeated", func(dir string) error {
+		includeDirs = append(includeDirs, dir)
+		return nil
+	})
 
-	if *filename == "" {
-		flag.Usage()
-		return
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	paths := flags.Args()
+	if *filename != "" {
+		paths = append([]string{*filename}, paths...)
+	}
+	if len(paths) == 0 {
+		flags.Usage()
+		return 2
 	}
 
-	files, err := collectFiles(*filename)
+	if *extension == "" || *extension == ".jack" {
+		fmt.Printf("Invalid output file extension %q\n", *extension)
+		return 2
+	}
+
+	if *tabWidth < 1 {
+		fmt.Printf("Invalid tab width %d\n", *tabWidth)
+		return 2
+	}
+	if *maxErrors < 0 {
+		fmt.Printf("Invalid maximum number of errors %d\n", *maxErrors)
+		return 2
+	}
+
+	if *shortCircuit && *foldConstants {
+		fmt.Println("-short-circuit cannot be combined with -fold-constants")
+		return 2
+	}
+	if *diagnosticsFormat != "" && *diagnosticsFormat != "json" && *diagnosticsFormat != "sarif" {
+		fmt.Printf("Unknown diagnostics format %q\n", *diagnosticsFormat)
+		return 2
+	}
+	// The report is printed at the end, which -watch never reaches
+	if *diagnosticsFormat != "" && (*stdoutVM || *watch) {
+		fmt.Println("-diagnostics-format cannot be combined with -stdout-vm or -watch")
+		return 2
+	}
+	if *multipleClasses && *foldConstants {
+		fmt.Println("-multiple-classes cannot be combined with -fold-constants")
+		return 2
+	}
+	if *single != "" && (*stdoutVM || *check || *watch) {
+		fmt.Println("-single cannot be combined with -stdout-vm, -check or -watch")
+		return 2
+	}
+	if *incremental && (*stdoutVM || *single != "" || *check) {
+		fmt.Println("-incremental cannot be combined with -stdout-vm, -single or -check")
+		return 2
+	}
+	if *deps && (*stdoutVM || *single != "" || *watch || *incremental) {
+		fmt.Println("-deps cannot be combined with -stdout-vm, -single, -watch or -incremental")
+		return 2
+	}
+	if *deps {
+		// The dependencies are collected by checking the files
+		*check = true
+	}
+	if *emitStubs && *incremental {
+		fmt.Println("-emit-stubs cannot be combined with -incremental")
+		return 2
+	}
+	if *relaxedVarDecs && *foldConstants {
+		fmt.Println("-relaxed-vars cannot be combined with -fold-constants")
+		return 2
+	}
+
+	options := CompilerOptions{
+		WarnTypes:          *warnTypes,
+		FoldConstants:      *foldConstants,
+		DropDeadCode:       *dropDeadCode,
+		Peephole:           *peephole,
+		PoolStrings:        *poolStrings,
+		StrictClassNames:   *strictClassNames,
+		RelaxedVarDecs:     *relaxedVarDecs,
+		WarnChainedCompare: *warnChainedCompare,
+		WarnStyle:          *warnStyle,
+		RecoverErrors:      *recoverErrors,
+		MaxErrors:          *maxErrors,
+		Only:               *only,
+		StrictCalls:        *strictCalls,
+		ShortCircuit:       *shortCircuit,
+		CheckBounds:        *checkBounds,
+		MultipleClasses:    *multipleClasses,
+		SeparateFunctions:  *separateFunctions,
+		TabWidth:           *tabWidth,
+		Annotate:           *annotate,
+		ExtOperators:       *extOperators,
+	}
+	if *callGraphPath != "" {
+		options.CallGraph = NewCallGraph()
+	}
+	if *symbolsFormat != "text" && *symbolsFormat != "json" {
+		fmt.Printf("Unknown report format %q\n", *symbolsFormat)
+		return 2
+	}
+	if *symbols {
+		options.SymbolReport = &SymbolReport{}
+	}
+	if (*emitStubs && !*check) || *deps {
+		options.Stubs = NewStubs()
+	}
+	if *diagnosticsFormat != "" {
+		options.DiagnosticReport = &DiagnosticReport{Diagnostics: []Diagnostic{}}
+	}
+	for _, dir := range includeDirs {
+		signatures, err := ScanSignatures(dir)
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		if options.Signatures == nil {
+			options.Signatures = make(Signatures)
+		}
+		for name, signature := range signatures {
+			options.Signatures[name] = signature
+		}
+	}
+
+	files, err := collectAllFiles(paths)
 	if err != nil {
 		fmt.Println(err)
-		return
+		return 1
 	}
 
+	if *doc {
+		return writeDocumentation(files, *symbolsFormat)
+	}
+	if *stats {
+		return writeStats(files, *symbolsFormat)
+	}
+	if *tokensJSON {
+		return writeTokens(files, *tabWidth)
+	}
+
+	var timings io.Writer
+	if *verbose {
+		timings = os.Stderr
+	}
+
+	// Keep stdout free for the VM code or diagnostics
+	var messages io.Writer = os.Stdout
+	if *stdoutVM || options.DiagnosticReport != nil || *deps {
+		messages = os.Stderr
+	}
+
+	// All files are written to combined with -stdout-vm and -single
+	var combined io.Writer
+	if *stdoutVM {
+		combined = os.Stdout
+	}
+	if *single != "" && !dryRun {
+		output, err := os.Create(*single)
+		if err != nil {
+			fmt.Printf("Could not open output file %q for writing: %v\n", *single, err)
+			return 1
+		}
+		defer output.Close()
+		combined = output
+	}
+	// The classes share the static segment of the combined output
+	if combined != nil {
+		options.SharedStatics = new(MachineWord)
+	}
+
+	// compiled reports whether file is one of the files to compile
+	compiled := func(file string) bool {
+		return filepath.Ext(file) == ".jack" && (*only == "" || strings.HasPrefix(*only, getClassName(file)+"."))
+	}
+	compile := func(file string) error {
+		outputPath := getOutputPath(file, *extension)
+		var warnings []string
+		var err error
+		switch {
+		case *check:
+			fmt.Fprintf(messages, "Checking file %q\n", file)
+			warnings, err = checkFile(file, options, timings)
+		case combined != nil:
+			fmt.Fprintf(messages, "Compiling file %q\n", file)
+			warnings, err = streamFile(file, combined, options, timings)
+		default:
+			fmt.Fprintf(messages, "Compiling file %q\n", file)
+			warnings, err = processFile(file, outputPath, options, timings)
+		}
+		for _, warning := range warnings {
+			fmt.Fprintf(messages, "Warning: %s\n", warning)
+		}
+		if err != nil {
+			fmt.Fprintf(messages, "Failed to compile %q: %s\n", file, err)
+			return err
+		}
+		if combined == nil && !*check {
+			fmt.Fprintf(messages, "Saved as %q\n", outputPath)
+		}
+		return nil
+	}
+
+	failed := false
 	for _, file := range files {
-		if filepath.Ext(file) != ".jack" {
+		if !compiled(file) {
 			continue
 		}
-		fmt.Printf("Compiling file %q\n", file)
-		outputPath, err := processFile(file)
+		if *incremental && !*force && upToDate(file, getOutputPath(file, *extension)) {
+			fmt.Fprintf(messages, "%q is up to date\n", file)
+			continue
+		}
+		if dryRun && *check {
+			fmt.Fprintf(messages, "Would check %q\n", file)
+			continue
+		}
+		if dryRun {
+			outputPath := getOutputPath(file, *extension)
+			if *stdoutVM {
+				outputPath = "stdout"
+			}
+			if *single != "" {
+				outputPath = *single
+			}
+			fmt.Fprintf(messages, "Would compile %q to %q\n", file, outputPath)
+			continue
+		}
+		if err := compile(file); err != nil {
+			failed = true
+			if *failFast || !*keepGoing {
+				break
+			}
+		}
+	}
+
+	if *emitMath && !*check && len(files) > 0 {
+		mathPath := filepath.Join(filepath.Dir(files[0]), getOutputPath("Math.jack", *extension))
+		mathCompiled := false
+		for _, file := range files {
+			if filepath.Base(file) == "Math.jack" {
+				mathCompiled = true
+			}
+		}
+		switch {
+		case mathCompiled:
+			fmt.Fprintf(messages, "Warning: not writing Math routines, Math.jack is compiled\n")
+		case dryRun:
+			fmt.Fprintf(messages, "Would write Math routines to %q\n", mathPath)
+		case combined != nil:
+			fmt.Fprintf(combined, "// ==== Math.jack ====\n")
+			if err := WriteMathRoutines(combined); err != nil {
+				fmt.Fprintln(messages, err)
+				return 1
+			}
+		default:
+			if err := writeMathRoutines(mathPath); err != nil {
+				fmt.Println(err)
+				return 1
+			}
+			fmt.Printf("Saved Math routines as %q\n", mathPath)
+		}
+		if options.Stubs != nil && !mathCompiled {
+			for function := range MathRoutines() {
+				options.Stubs.Define(function)
+			}
+		}
+	}
+
+	if options.Stubs != nil && !*check && len(files) > 0 {
+		stubsPath := filepath.Join(filepath.Dir(files[0]), getOutputPath("Stubs.jack", *extension))
+		switch {
+		case dryRun:
+			fmt.Fprintf(messages, "Would write stubs to %q\n", stubsPath)
+		case len(options.Stubs.Missing()) == 0:
+		case combined != nil:
+			fmt.Fprintf(combined, "// ==== Stubs ====\n")
+			if err := options.Stubs.WriteVM(combined); err != nil {
+				fmt.Fprintln(messages, err)
+				return 1
+			}
+		default:
+			if err := writeStubs(stubsPath, options.Stubs); err != nil {
+				fmt.Println(err)
+				return 1
+			}
+			fmt.Printf("Saved stubs as %q\n", stubsPath)
+		}
+	}
+
+	if dryRun {
+		return 0
+	}
+	if *deps {
+		fmt.Println(strings.Join(options.Stubs.Missing(), ", "))
+	}
+	if *single != "" {
+		fmt.Fprintf(messages, "Saved as %q\n", *single)
+	}
+
+	if options.CallGraph != nil {
+		if err := writeCallGraph(*callGraphPath, options.CallGraph); err != nil {
+			fmt.Println(err)
+			return 1
+		}
+		fmt.Fprintf(messages, "Saved call graph as %q\n", *callGraphPath)
+	}
+
+	if options.SymbolReport != nil {
+		if *symbolsFormat == "json" {
+			err = options.SymbolReport.WriteJSON(os.Stdout)
+		} else {
+			err = options.SymbolReport.WriteText(os.Stdout)
+		}
+		if err != nil {
+			fmt.Println(err)
+			return 1
+		}
+	}
+
+	if *watch {
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		stop := make(chan struct{})
+		go func() {
+			<-interrupt
+			close(stop)
+		}()
+		fmt.Fprintf(messages, "Watching %q for changes\n", strings.Join(paths, " "))
+		watchFiles(paths, watchInterval, stop, func(file string) {
+			if compiled(file) {
+				compile(file)
+			}
+		})
+		return 0
+	}
+
+	if options.DiagnosticReport != nil {
+		if *diagnosticsFormat == "sarif" {
+			err = options.DiagnosticReport.WriteSARIF(os.Stdout)
+		} else {
+			err = options.DiagnosticReport.WriteJSON(os.Stdout)
+		}
 		if err != nil {
-			fmt.Printf("Failed to compile %q: %s\n", file, err)
+			fmt.Fprintln(messages, err)
+			return 1
 		}
-		fmt.Printf("Saved as %q\n", outputPath)
 	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
 }
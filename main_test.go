@@ -0,0 +1,612 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFoldConstantsReportsCompilerWarnings(t *testing.T) {
+	source := `class Main {
+    function void main() {
+        var int unused;
+        return;
+        do Output.printInt(1);
+    }
+}`
+	options := CompilerOptions{WarnUnused: true}
+	_, want, err := compileSource("Main.jack", source, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.FoldConstants = true
+	_, got, err := compileSource("Main.jack", source, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(want) != 2 || !reflect.DeepEqual(got, want) {
+		t.Errorf("FoldConstants warnings %q, want %q", got, want)
+	}
+}
+
+func TestFoldConstantsAppliesCompilerChecks(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		options CompilerOptions
+		want    string
+	}{
+		{"strict class names", `class Other {
+}`, CompilerOptions{StrictClassNames: true}, "Other"},
+		{"strict calls", `class Main {
+    method void m() {
+        return;
+    }
+    function void main() {
+        do Main.m();
+        return;
+    }
+}`, CompilerOptions{StrictCalls: true}, "Main.m"},
+		{"recover", `class Main {
+    function void main() {
+        let x = 1;
+        let y = 2;
+        return;
+    }
+}`, CompilerOptions{RecoverErrors: true}, "variable y"},
+	}
+	for _, test := range tests {
+		test.options.FoldConstants = true
+		_, _, err := compileSource("Main.jack", test.source, test.options)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: error %v, want one mentioning %s", test.name, err, test.want)
+		}
+	}
+}
+
+func TestFoldConstantsDropsDeadCode(t *testing.T) {
+	source := `class Main {
+    function int main() {
+        var int x;
+        let x = 1;
+        if (x) {
+            return 1;
+            while (x) {
+                let x = x - 1;
+            }
+        }
+        while (x) {
+            let x = 0;
+        }
+        return x;
+        let x = 2;
+    }
+}`
+	options := CompilerOptions{DropDeadCode: true}
+	want, _, err := compileSource("Main.jack", source, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	options.FoldConstants = true
+	got, _, err := compileSource("Main.jack", source, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("FoldConstants output\n%s\ndiffers from JackCompiler output\n%s", got, want)
+	}
+	if strings.Contains(got, "push constant 2") {
+		t.Errorf("dead code in output:\n%s", got)
+	}
+}
+
+func TestWatchFilesCompilesModifiedFiles(t *testing.T) {
+	dir := t.TempDir()
+	modified := filepath.Join(dir, "Main.jack")
+	unchanged := filepath.Join(dir, "Other.jack")
+	for _, file := range []string{modified, unchanged} {
+		if err := os.WriteFile(file, []byte("class X {}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	compiled := make(chan string, 10)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		watchFiles([]string{dir}, 10*time.Millisecond, stop, func(file string) { compiled <- file })
+		close(done)
+	}()
+
+	// Let watchFiles record the initial modification times. They may be
+	// coarse, so set one that differs for sure.
+	time.Sleep(50 * time.Millisecond)
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(modified, later, later); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case file := <-compiled:
+		if file != modified {
+			t.Errorf("compiled %q, want %q", file, modified)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("modified file not compiled")
+	}
+
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watchFiles did not return after stop was closed")
+	}
+	if len(compiled) != 0 {
+		t.Errorf("compiled %q as well, want each modification compiled once", <-compiled)
+	}
+}
+
+func TestRunKeepGoingAndFailFast(t *testing.T) {
+	sources := map[string]string{
+		"A.jack": "class A { function void f() { let x = 1; return; } }\n",
+		"B.jack": "class B { function void f() { return; } }\n",
+	}
+
+	dir := writeSources(t, sources)
+	code, stdout, _ := runCaptured(t, dir)
+	if code != 1 {
+		t.Errorf("exit code %d, want 1", code)
+	}
+	if strings.Contains(stdout, filepath.Join(dir, "A.vm")) {
+		t.Errorf("output claims the broken file was saved:\n%s", stdout)
+	}
+	if !strings.Contains(stdout, "Saved as "+`"`+filepath.Join(dir, "B.vm")) {
+		t.Errorf("output does not report the valid file as saved:\n%s", stdout)
+	}
+
+	dir = writeSources(t, sources)
+	code, stdout, _ = runCaptured(t, "-fail-fast", dir)
+	if code != 1 {
+		t.Errorf("-fail-fast exit code %d, want 1", code)
+	}
+	if strings.Contains(stdout, "B.jack") {
+		t.Errorf("-fail-fast compiled the file after the failure:\n%s", stdout)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "B.vm")); err == nil {
+		t.Error("-fail-fast wrote B.vm")
+	}
+}
+
+func TestRunExitCode(t *testing.T) {
+	dir := writeSources(t, map[string]string{"Main.jack": "class Main { function void main() { return; } }\n"})
+	if code, stdout, _ := runCaptured(t, filepath.Join(dir, "Main.jack")); code != 0 {
+		t.Errorf("exit code %d, want 0:\n%s", code, stdout)
+	}
+
+	dir = writeSources(t, map[string]string{"Main.jack": "class Main { function void main() { return }\n"})
+	if code, _, _ := runCaptured(t, filepath.Join(dir, "Main.jack")); code != 1 {
+		t.Errorf("exit code %d, want 1", code)
+	}
+}
+
+func TestRunOutputExtension(t *testing.T) {
+	dir := writeSources(t, map[string]string{"Main.jack": "class Main { function void main() { return; } }\n"})
+	if code, stdout, _ := runCaptured(t, "-ext", ".gen.vm", dir); code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, stdout)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Main.gen.vm")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Main.vm")); err == nil {
+		t.Error("wrote Main.vm as well")
+	}
+
+	for _, extension := range []string{"", ".jack"} {
+		if code, _, _ := runCaptured(t, "-ext", extension, dir); code != 2 {
+			t.Errorf("-ext %q exit code %d, want 2", extension, code)
+		}
+	}
+}
+
+func TestRunDryRunWritesNothing(t *testing.T) {
+	dir := writeSources(t, map[string]string{
+		"Main.jack": "class Main { function void main() { return; } }\n",
+		"Util.jack": "class Util { function void f() { return; } }\n",
+	})
+	for _, flag := range []string{"-dry-run", "-n"} {
+		code, stdout, _ := runCaptured(t, flag, "-emit-math", dir)
+		if code != 0 {
+			t.Errorf("%s exit code %d, want 0", flag, code)
+		}
+		for _, plan := range []string{
+			`Would compile "` + filepath.Join(dir, "Main.jack") + `" to "` + filepath.Join(dir, "Main.vm") + `"`,
+			`Would compile "` + filepath.Join(dir, "Util.jack") + `" to "` + filepath.Join(dir, "Util.vm") + `"`,
+			`Would write Math routines to "` + filepath.Join(dir, "Math.vm") + `"`,
+		} {
+			if !strings.Contains(stdout, plan) {
+				t.Errorf("%s output lacks %s:\n%s", flag, plan, stdout)
+			}
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 {
+			t.Errorf("%s created files, directory contains %v", flag, entries)
+		}
+	}
+}
+
+func TestRunVerboseReportsTimings(t *testing.T) {
+	dir := writeSources(t, map[string]string{
+		"B.jack":    "class B {}\n",
+		"Main.jack": "class Main { function void main() { return; } }\n",
+	})
+	code, stdout, stderr := runCaptured(t, "-v", dir)
+	if code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, stdout)
+	}
+	lines := strings.Split(strings.TrimSpace(stderr), "\n")
+	wants := []string{
+		regexp.QuoteMeta(filepath.Join(dir, "B.jack")) + `: 4 tokens in \S+s$`,
+		regexp.QuoteMeta(filepath.Join(dir, "Main.jack")) + `: 13 tokens in \S+s$`,
+	}
+	if len(lines) != len(wants) {
+		t.Fatalf("stderr %q, want a timing line per file", stderr)
+	}
+	for i, want := range wants {
+		if !regexp.MustCompile(want).MatchString(lines[i]) {
+			t.Errorf("timing line %q, want it to match %s", lines[i], want)
+		}
+	}
+
+	if _, _, stderr := runCaptured(t, dir); stderr != "" {
+		t.Errorf("stderr %q without -v, want none", stderr)
+	}
+}
+
+func TestRunEmitMath(t *testing.T) {
+	dir := writeSources(t, map[string]string{"Main.jack": "class Main { function int main() { return 6 * 7; } }\n"})
+	if code, stdout, _ := runCaptured(t, "-emit-math", dir); code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, stdout)
+	}
+	main, err := os.ReadFile(filepath.Join(dir, "Main.vm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	math, err := os.ReadFile(filepath.Join(dir, "Math.vm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := runVM(t, string(main)+string(math), "Main.main"); got != 42 {
+		t.Errorf("Main.main() = %d, want 42", got)
+	}
+}
+
+func TestRunOnlyCompilesFileOfClass(t *testing.T) {
+	dir := writeSources(t, map[string]string{
+		"Main.jack":  "class Main { function void main() { return; } function void other() { return; } }\n",
+		"Other.jack": "class Other { function void f() { return; } }\n",
+	})
+	if code, stdout, _ := runCaptured(t, "-only", "Main.main", dir); code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, stdout)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "Other.vm")); err == nil {
+		t.Error("compiled Other.jack as well")
+	}
+	vm, err := os.ReadFile(filepath.Join(dir, "Main.vm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "function Main.main 0\npush constant 0\nreturn\n"; string(vm) != want {
+		t.Errorf("Main.vm %q, want %q", vm, want)
+	}
+}
+
+func TestRunStdoutVM(t *testing.T) {
+	dir := writeSources(t, map[string]string{
+		"Main.jack":  "class Main { function void main() { return; } }\n",
+		"Other.jack": "class Other { function int f() { return 1; } }\n",
+	})
+	code, stdout, stderr := runCaptured(t, "-stdout-vm", dir)
+	if code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, stderr)
+	}
+	want := "// ==== Main.jack ====\nfunction Main.main 0\npush constant 0\nreturn\n" +
+		"// ==== Other.jack ====\nfunction Other.f 0\npush constant 1\nreturn\n"
+	if stdout != want {
+		t.Errorf("stdout\n%s\nwant\n%s", stdout, want)
+	}
+	if !strings.Contains(stderr, "Compiling file") {
+		t.Errorf("progress messages not on stderr:\n%s", stderr)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("-stdout-vm wrote files, directory contains %v", entries)
+	}
+}
+
+func TestRunCheck(t *testing.T) {
+	dir := writeSources(t, map[string]string{"Main.jack": "class Main { function void main() { return; } }\n"})
+	code, stdout, _ := runCaptured(t, "-check", dir)
+	if code != 0 {
+		t.Errorf("exit code %d, want 0:\n%s", code, stdout)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("-check wrote files, directory contains %v", entries)
+	}
+
+	dir = writeSources(t, map[string]string{"Main.jack": "class Main { function void main() { let x = 1; return; } }\n"})
+	code, stdout, _ = runCaptured(t, "-check", dir)
+	if code != 1 {
+		t.Errorf("exit code %d, want 1", code)
+	}
+	if !strings.Contains(stdout, "undeclared variable x") {
+		t.Errorf("output does not report the error:\n%s", stdout)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("-check wrote files, directory contains %v", entries)
+	}
+}
+
+func TestRunIncludeDirectory(t *testing.T) {
+	lib := writeSources(t, map[string]string{"Lib.jack": "class Lib { function void init(int size) { return; } }\n"})
+	for _, flags := range [][]string{nil, {"-fold-constants"}} {
+		dir := writeSources(t, map[string]string{"Main.jack": "class Main { function void main() { do Lib.init(); return; } }\n"})
+		args := append(append([]string{"-I", lib}, flags...), dir)
+		code, stdout, _ := runCaptured(t, args...)
+		if code != 1 || !strings.Contains(stdout, "Lib.init expects 1 arguments but got 0 in Main.main") {
+			t.Errorf("%q: exit code %d, want 1 for the arity mismatch:\n%s", flags, code, stdout)
+		}
+		if code, stdout, _ := runCaptured(t, append(flags, dir)...); code != 0 {
+			t.Errorf("%q: exit code %d without -I, want 0:\n%s", flags, code, stdout)
+		}
+	}
+}
+
+func TestRunSingleOutputFile(t *testing.T) {
+	dir := writeSources(t, map[string]string{
+		"Main.jack":  "class Main { function int main() { return Other.f() + 1; } }\n",
+		"Other.jack": "class Other { function int f() { return 41; } }\n",
+	})
+	output := filepath.Join(t.TempDir(), "out.vm")
+	code, stdout, _ := runCaptured(t, "-single", output, dir)
+	if code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, stdout)
+	}
+	vm, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, function := range []string{"function Main.main 0\n", "function Other.f 0\n"} {
+		if !strings.Contains(string(vm), function) {
+			t.Errorf("combined file lacks %q:\n%s", function, vm)
+		}
+	}
+	if strings.Contains(string(vm), "Compiling") {
+		t.Errorf("progress messages in combined file:\n%s", vm)
+	}
+	if got := runVM(t, string(vm), "Main.main"); got != 42 {
+		t.Errorf("Main.main() = %d, want 42", got)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("-single wrote files next to the sources, directory contains %v", entries)
+	}
+}
+
+func TestRunIncremental(t *testing.T) {
+	dir := writeSources(t, map[string]string{
+		"Fresh.jack": "class Fresh { function int f() { return 1; } }\n",
+		"Stale.jack": "class Stale { function int f() { return 2; } }\n",
+	})
+	source := time.Now().Add(-time.Hour)
+	for _, name := range []string{"Fresh", "Stale"} {
+		if err := os.Chtimes(filepath.Join(dir, name+".jack"), source, source); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name+".vm"), []byte("// old\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stale := source.Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(dir, "Stale.vm"), stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	code, stdout, _ := runCaptured(t, "-incremental", dir)
+	if code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, stdout)
+	}
+	if want := fmt.Sprintf("%q is up to date", filepath.Join(dir, "Fresh.jack")); !strings.Contains(stdout, want) {
+		t.Errorf("stdout lacks %s:\n%s", want, stdout)
+	}
+	outputs := map[string]string{"Fresh": "// old\n", "Stale": "function Stale.f 0\npush constant 2\nreturn\n"}
+	for name, want := range outputs {
+		if vm, _ := os.ReadFile(filepath.Join(dir, name+".vm")); string(vm) != want {
+			t.Errorf("%s.vm is %q, want %q", name, vm, want)
+		}
+	}
+
+	if code, stdout, _ := runCaptured(t, "-incremental", "-force", dir); code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, stdout)
+	}
+	if vm, _ := os.ReadFile(filepath.Join(dir, "Fresh.vm")); string(vm) != "function Fresh.f 0\npush constant 1\nreturn\n" {
+		t.Errorf("-force did not rebuild Fresh.vm: %q", vm)
+	}
+}
+
+func TestRunPositionalArguments(t *testing.T) {
+	dir := writeSources(t, map[string]string{
+		"A.jack": "class A { function int f() { return 1; } }\n",
+		"B.jack": "class B { function int f() { return 2; } }\n",
+		"C.jack": "class C { function int f() { return 3; } }\n",
+	})
+	code, stdout, _ := runCaptured(t, "-d", filepath.Join(dir, "C.jack"), filepath.Join(dir, "A.jack"), filepath.Join(dir, "B.jack"))
+	if code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, stdout)
+	}
+	for i, name := range []string{"A", "B", "C"} {
+		want := fmt.Sprintf("function %s.f 0\npush constant %d\nreturn\n", name, i+1)
+		if vm, err := os.ReadFile(filepath.Join(dir, name+".vm")); err != nil || string(vm) != want {
+			t.Errorf("%s.vm is %q, %v, want %q", name, vm, err, want)
+		}
+	}
+
+	if code, _, _ := runCaptured(t); code != 2 {
+		t.Errorf("exit code %d without files, want 2", code)
+	}
+}
+
+func TestRunEmitStubs(t *testing.T) {
+	dir := writeSources(t, map[string]string{
+		"Main.jack":  "class Main { function int main() { do Output.printInt(Other.f() * 2); return Other.f(); } }\n",
+		"Other.jack": "class Other { function int f() { return 21; } }\n",
+	})
+	if code, stdout, _ := runCaptured(t, "-emit-stubs", dir); code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, stdout)
+	}
+	stubs, err := os.ReadFile(filepath.Join(dir, "Stubs.vm"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "// Math.multiply, called with 2 argument(s)\nfunction Math.multiply 0\npush constant 0\nreturn\n" +
+		"// Output.printInt, called with 1 argument(s)\nfunction Output.printInt 0\npush constant 0\nreturn\n"
+	if string(stubs) != want {
+		t.Errorf("Stubs.vm\n%s\nwant\n%s", stubs, want)
+	}
+
+	// The compiled classes link with the stubs
+	var vm strings.Builder
+	for _, name := range []string{"Main.vm", "Other.vm", "Stubs.vm"} {
+		code, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		vm.Write(code)
+	}
+	if got := runVM(t, vm.String(), "Main.main"); got != 21 {
+		t.Errorf("Main.main() = %d, want 21", got)
+	}
+}
+
+func TestRunTabWidth(t *testing.T) {
+	dir := writeSources(t, map[string]string{"Main.jack": "class Main {\n\tfunction void f() {\n\t\tlet x = 1;\n\t}\n}\n"})
+	for width, want := range map[string]string{"1": "line 3, col 7", "4": "line 3, col 13"} {
+		code, stdout, _ := runCaptured(t, "-tabwidth", width, dir)
+		if code != 1 || !strings.Contains(stdout, "undeclared variable x in Main.f at "+want) {
+			t.Errorf("-tabwidth %s: exit code %d, want the error at %s:\n%s", width, code, want, stdout)
+		}
+	}
+	if code, _, _ := runCaptured(t, "-tabwidth", "0", dir); code != 2 {
+		t.Errorf("exit code %d for -tabwidth 0, want 2", code)
+	}
+}
+
+func TestRunDeps(t *testing.T) {
+	dir := writeSources(t, map[string]string{
+		"Main.jack":  "class Main { function void main() { do Output.printString(\"hi\"); do Output.printInt(Other.f() * 2); do Main.g(); return; } function void g() { return; } }\n",
+		"Other.jack": "class Other { function int f() { return 21; } }\n",
+	})
+	code, stdout, _ := runCaptured(t, "-deps", dir)
+	if code != 0 {
+		t.Fatalf("exit code %d:\n%s", code, stdout)
+	}
+	// Functions of the compiled classes are no dependencies
+	if want := "Math.multiply, Output.printInt, Output.printString, String.appendChar, String.new\n"; stdout != want {
+		t.Errorf("stdout %q, want %q", stdout, want)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("-deps wrote files, directory contains %v", entries)
+	}
+}
+
+func TestRunSingleSeparatesStatics(t *testing.T) {
+	dir := writeSources(t, map[string]string{
+		"A.jack":    "class A { static int a, b; function void set() { let a = 1; let b = 2; return; } function int get() { return a + b; } }\n",
+		"B.jack":    "class B { static int c; function void set() { let c = 40; return; } function int get() { return c; } }\n",
+		"Main.jack": "class Main { function int main() { do A.set(); do B.set(); return A.get() + B.get(); } }\n",
+	})
+	for _, flags := range [][]string{nil, {"-fold-constants"}} {
+		output := filepath.Join(t.TempDir(), "out.vm")
+		code, stdout, _ := runCaptured(t, append(flags, "-single", output, dir)...)
+		if code != 0 {
+			t.Fatalf("%v: exit code %d:\n%s", flags, code, stdout)
+		}
+		vm, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// B's static follows those of A
+		for _, command := range []string{"pop static 0", "pop static 1", "pop static 2"} {
+			if !strings.Contains(string(vm), command+"\n") {
+				t.Errorf("%v: combined file lacks %q:\n%s", flags, command, vm)
+			}
+		}
+		machine := newVMMachine(string(vm))
+		machine.file = "out"
+		machine.steps = 10000
+		if got, err := machine.call("Main.main"); err != nil || got != 43 {
+			t.Errorf("%v: Main.main() = %d, %v, want 43", flags, got, err)
+		}
+	}
+}
+
+func TestRunDiagnosticsFormat(t *testing.T) {
+	dir := writeSources(t, map[string]string{"Main.jack": "class Main {\n  function void main() {\n    let x = 1;\n    return;\n  }\n}\n"})
+	file := filepath.Join(dir, "Main.jack")
+	message := "cannot assign to undeclared variable x in Main.main"
+
+	code, stdout, _ := runCaptured(t, "-diagnostics-format", "json", dir)
+	if code != 1 {
+		t.Errorf("json: exit code %d, want 1", code)
+	}
+	var report DiagnosticReport
+	if err := json.Unmarshal([]byte(stdout), &report); err != nil {
+		t.Fatalf("json: %v:\n%s", err, stdout)
+	}
+	want := []Diagnostic{{
+		Severity: ErrorSeverity,
+		Message:  message,
+		File:     file,
+		Range:    Range{Start: Position{Line: 3, Column: 9}, End: Position{Line: 3, Column: 10}},
+	}}
+	if !reflect.DeepEqual(report.Diagnostics, want) {
+		t.Errorf("json: diagnostics %v, want %v", report.Diagnostics, want)
+	}
+
+	code, stdout, _ = runCaptured(t, "-diagnostics-format", "sarif", dir)
+	if code != 1 {
+		t.Errorf("sarif: exit code %d, want 1", code)
+	}
+	var log sarifLog
+	if err := json.Unmarshal([]byte(stdout), &log); err != nil {
+		t.Fatalf("sarif: %v:\n%s", err, stdout)
+	}
+	if len(log.Runs) != 1 || len(log.Runs[0].Results) != 1 || len(log.Runs[0].Results[0].Locations) != 1 {
+		t.Fatalf("sarif: want one result with a location:\n%s", stdout)
+	}
+	result := log.Runs[0].Results[0]
+	location := result.Locations[0].PhysicalLocation
+	if result.Level != "error" || result.Message.Text != message {
+		t.Errorf("sarif: result %s %q, want error %q", result.Level, result.Message.Text, message)
+	}
+	if location.ArtifactLocation.URI != filepath.ToSlash(file) {
+		t.Errorf("sarif: uri %q, want %q", location.ArtifactLocation.URI, filepath.ToSlash(file))
+	}
+	if region := location.Region; region == nil || *region != (sarifRegion{StartLine: 3, StartColumn: 9, EndLine: 3, EndColumn: 10}) {
+		t.Errorf("sarif: region %+v, want line 3, col 9 to 10", region)
+	}
+
+	// The report is printed at the end, which -watch never reaches
+	if code, _, _ := runCaptured(t, "-diagnostics-format", "json", "-watch", dir); code != 2 {
+		t.Errorf("exit code %d with -watch, want 2", code)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io"
+	"strings"
+)
+
+// mathRoutinesSource implements Math.multiply and Math.divide of the
+// nand2tetris OS without depending on any other OS class, and Math.mod for
+// the operator "%". Division by zero halts instead of calling Sys.error.
+const mathRoutinesSource = `
+class Math {
+	/** Returns x * y, computed by shifted additions. */
+	function int multiply(int x, int y) {
+		var int sum, shiftedX, mask;
+		let sum = 0;
+		let shiftedX = x;
+		let mask = 1;
+		// mask overflows to 0 after the 16th bit
+		while (~(mask = 0)) {
+			if (~((y & mask) = 0)) {
+				let sum = sum + shiftedX;
+			}
+			let shiftedX = shiftedX + shiftedX;
+			let mask = mask + mask;
+		}
+		return sum;
+	}
+
+	/** Returns the integer part of x / y, rounded towards zero. */
+	function int divide(int x, int y) {
+		var int q;
+		var boolean negative;
+		if (y = 0) {
+			while (true) {
+			}
+		}
+		let negative = ~((x < 0) = (y < 0));
+		if (x < 0) {
+			let x = -x;
+		}
+		if (y < 0) {
+			let y = -y;
+		}
+		let q = Math.divideNonNegative(x, y);
+		if (negative) {
+			return -q;
+		}
+		return q;
+	}
+
+	/** Returns x / y for x >= 0 and y > 0. */
+	function int divideNonNegative(int x, int y) {
+		var int q;
+		// y < 0 if doubling y overflowed
+		if ((y > x) | (y < 0)) {
+			return 0;
+		}
+		let q = Math.divideNonNegative(x, y + y);
+		if ((x - Math.multiply(q + q, y)) < y) {
+			return q + q;
+		}
+		return q + q + 1;
+	}
+
+	/** Returns the remainder of x / y, which has the sign of x. */
+	function int mod(int x, int y) {
+		return x - Math.multiply(Math.divide(x, y), y);
+	}
+}
+`
+
+// MathRoutines returns the signatures of the functions written by
+// WriteMathRoutines.
+func MathRoutines() Signatures {
+	signatures := make(Signatures)
+	if err := signatures.Add(strings.NewReader(mathRoutinesSource)); err != nil {
+		panic(err)
+	}
+	return signatures
+}
+
+// WriteMathRoutines writes the VM code of Math.multiply, Math.divide and
+// Math.mod to w, for running compiled programs without the OS.
+func WriteMathRoutines(w io.Writer) error {
+	tokenizer := NewTokenizer(strings.NewReader(mathRoutinesSource))
+	_, err := compileFile("Math.jack", &tokenizer, w, CompilerOptions{})
+	return err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMathRoutines(t *testing.T) {
+	var vm strings.Builder
+	if err := WriteMathRoutines(&vm); err != nil {
+		t.Fatal(err)
+	}
+	for name := range MathRoutines() {
+		if !strings.Contains(vm.String(), "function "+name+" ") {
+			t.Errorf("VM code lacks %s", name)
+		}
+	}
+
+	values := []int16{0, 1, -1, 2, 3, -7, 10, 100, 181, -256, 1000, 12345, 32767, -32767}
+	for _, x := range values {
+		for _, y := range values {
+			if got, want := runVM(t, vm.String(), "Math.multiply", x, y), x*y; got != want {
+				t.Errorf("Math.multiply(%d, %d) = %d, want %d", x, y, got, want)
+			}
+			if y == 0 {
+				continue
+			}
+			if got, want := runVM(t, vm.String(), "Math.divide", x, y), x/y; got != want {
+				t.Errorf("Math.divide(%d, %d) = %d, want %d", x, y, got, want)
+			}
+			if got, want := runVM(t, vm.String(), "Math.mod", x, y), x%y; got != want {
+				t.Errorf("Math.mod(%d, %d) = %d, want %d", x, y, got, want)
+			}
+		}
+	}
+
+	// Division by zero halts
+	machine := newVMMachine(vm.String())
+	machine.steps = 10000
+	if result, err := machine.call("Math.divide", 1, 0); err == nil {
+		t.Errorf("Math.divide(1, 0) returned %d, want it to halt", result)
+	}
+}
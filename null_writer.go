@@ -0,0 +1,31 @@
+package main
+
+// NullWriter is an OutputWriter discarding all commands, for running the
+// compiler only for its checks.
+type NullWriter struct{}
+
+func (NullWriter) WriteCommand(string) {}
+
+func (NullWriter) WritePush(VMSegmentType, MachineWord) {}
+
+func (NullWriter) WritePop(VMSegmentType, MachineWord) {}
+
+func (NullWriter) WriteArithmetic(VMOperation) {}
+
+func (NullWriter) WriteLabel(string) {}
+
+func (NullWriter) WriteGoto(string) {}
+
+func (NullWriter) WriteIf(string) {}
+
+func (NullWriter) WriteCall(string, MachineWord) {}
+
+func (NullWriter) WriteFunction(string, MachineWord) {}
+
+func (NullWriter) WriteStringConstant(string) {}
+
+func (NullWriter) WriteReturn() {}
+
+func (NullWriter) WriteComment(string) {}
+
+func (NullWriter) Close() error { return nil }
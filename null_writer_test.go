@@ -0,0 +1,22 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompileToNullWriter(t *testing.T) {
+	var _ OutputWriter = NullWriter{}
+
+	tokenizer := NewTokenizer(strings.NewReader(syntheticSource(3)))
+	if err := NewJackCompiler(&tokenizer, NullWriter{}).Compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The checks still run
+	tokenizer = NewTokenizer(strings.NewReader(`class Main { function void main() { let x = 1; return; } }`))
+	err := NewJackCompiler(&tokenizer, NullWriter{}).Compile()
+	if err == nil || !strings.Contains(err.Error(), "undeclared variable x") {
+		t.Errorf("error %v, want an undeclared variable", err)
+	}
+}
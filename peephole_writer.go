@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type peepholeCommand struct {
+	command string
+	write   func(OutputWriter)
+}
+
+// PeepholeWriter wraps an OutputWriter and removes redundant command
+// sequences before passing them on:
+//   - push x / pop x
+//   - neg / neg and not / not
+//   - goto L / label L
+//
+// Commands are buffered until the next function declaration or Flush.
+type PeepholeWriter struct {
+	output  OutputWriter
+	pending []peepholeCommand
+}
+
+func NewPeepholeWriter(output OutputWriter) *PeepholeWriter {
+	return &PeepholeWriter{output: output}
+}
+
+func cancelsOut(previous string, next string) bool {
+	switch {
+	case previous == next:
+		return next == string(NegVMOperation) || next == string(NotVMOperation)
+	case len(previous) > 5 && previous[:5] == "push ":
+		return next == "pop "+previous[5:]
+	}
+	return false
+}
+
+func (w *PeepholeWriter) add(command string, write func(OutputWriter)) {
+	if n := len(w.pending); n > 0 {
+		previous := w.pending[n-1].command
+		if cancelsOut(previous, command) {
+			w.pending = w.pending[:n-1]
+			return
+		}
+		if len(command) > 6 && command[:6] == "label " && previous == "goto "+command[6:] {
+			// Jumping to the next command is a no-op
+			w.pending = w.pending[:n-1]
+		}
+	}
+	w.pending = append(w.pending, peepholeCommand{command: command, write: write})
+}
+
+// Close flushes the buffered commands and closes the wrapped OutputWriter.
+func (w *PeepholeWriter) Close() error {
+	w.Flush()
+	return w.output.Close()
+}
+
+// Flush passes all buffered commands on to the wrapped OutputWriter.
+func (w *PeepholeWriter) Flush() {
+	for _, command := range w.pending {
+		command.write(w.output)
+	}
+	w.pending = w.pending[:0]
+}
+
+func (w *PeepholeWriter) WriteCommand(command string) {
+	w.add(command, func(o OutputWriter) { o.WriteCommand(command) })
+}
+
+func (w *PeepholeWriter) WritePush(segment VMSegmentType, index MachineWord) {
+	w.add(fmt.Sprintf("push %s %d", segment, index), func(o OutputWriter) { o.WritePush(segment, index) })
+}
+
+func (w *PeepholeWriter) WritePop(segment VMSegmentType, index MachineWord) {
+	w.add(fmt.Sprintf("pop %s %d", segment, index), func(o OutputWriter) { o.WritePop(segment, index) })
+}
+
+// writeAnnotatedPush is optimized like WritePush, the comment is kept if the
+// push is.
+func (w *PeepholeWriter) writeAnnotatedPush(segment VMSegmentType, index MachineWord, comment string) {
+	w.add(fmt.Sprintf("push %s %d", segment, index), func(o OutputWriter) { writeVariablePush(o, segment, index, comment, true) })
+}
+
+func (w *PeepholeWriter) writeAnnotatedPop(segment VMSegmentType, index MachineWord, comment string) {
+	w.add(fmt.Sprintf("pop %s %d", segment, index), func(o OutputWriter) { writeVariablePop(o, segment, index, comment, true) })
+}
+
+func (w *PeepholeWriter) WriteArithmetic(operation VMOperation) {
+	w.add(string(operation), func(o OutputWriter) { o.WriteArithmetic(operation) })
+}
+
+func (w *PeepholeWriter) WriteLabel(label string) {
+	w.add("label "+label, func(o OutputWriter) { o.WriteLabel(label) })
+}
+
+func (w *PeepholeWriter) WriteGoto(label string) {
+	w.add("goto "+label, func(o OutputWriter) { o.WriteGoto(label) })
+}
+
+func (w *PeepholeWriter) WriteIf(label string) {
+	w.add("if-goto "+label, func(o OutputWriter) { o.WriteIf(label) })
+}
+
+func (w *PeepholeWriter) WriteCall(label string, nargs MachineWord) {
+	w.add("call "+label+" "+strconv.Itoa(int(nargs)), func(o OutputWriter) { o.WriteCall(label, nargs) })
+}
+
+func (w *PeepholeWriter) useTemps(temps *TempSlots) {
+	shareTemps(w.output, temps)
+}
+
+func (w *PeepholeWriter) WriteFunction(label string, nlocals MachineWord) {
+	w.Flush()
+	w.output.WriteFunction(label, nlocals)
+}
+
+func (w *PeepholeWriter) WriteStringConstant(constant string) {
+	// Opaque to the optimizer
+	w.add("", func(o OutputWriter) { o.WriteStringConstant(constant) })
+}
+
+func (w *PeepholeWriter) WriteReturn() {
+	w.add("return", func(o OutputWriter) { o.WriteReturn() })
+}
+
+func (w *PeepholeWriter) WriteComment(comment string) {
+	w.add("// "+comment, func(o OutputWriter) { o.WriteComment(comment) })
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPeepholeWriterRemovesRedundantSequences(t *testing.T) {
+	recorder := &RecordingWriter{}
+	writer := NewPeepholeWriter(recorder)
+	writer.WriteFunction("Main.main", 1)
+	writer.WritePush(LocalVMSegment, 0)
+	writer.WritePop(LocalVMSegment, 0)
+	writer.WriteArithmetic(NegVMOperation)
+	writer.WriteArithmetic(NegVMOperation)
+	writer.WritePush(ConstVMSegment, 1)
+	writer.WriteArithmetic(NotVMOperation)
+	writer.WriteArithmetic(NotVMOperation)
+	writer.WriteGoto("L")
+	writer.WriteLabel("L")
+	// Different segments do not cancel out
+	writer.WritePop(TempVMSegment, 0)
+	writer.WriteReturn()
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []RecordedCall{
+		{"WriteFunction", []any{"Main.main", MachineWord(1)}},
+		{"WritePush", []any{ConstVMSegment, MachineWord(1)}},
+		{"WriteLabel", []any{"L"}},
+		{"WritePop", []any{TempVMSegment, MachineWord(0)}},
+		{"WriteReturn", nil},
+		{"Close", nil},
+	}
+	if !reflect.DeepEqual(recorder.Calls, want) {
+		t.Errorf("recorded calls %v, want %v", recorder.Calls, want)
+	}
+}
+
+func TestPeepholeWriterKeepsStringConstants(t *testing.T) {
+	recorder := &RecordingWriter{}
+	writer := NewPeepholeWriter(recorder)
+	writer.WritePush(LocalVMSegment, 0)
+	writer.WriteStringConstant("x")
+	writer.WritePop(LocalVMSegment, 0)
+	writer.Close()
+
+	want := []RecordedCall{
+		{"WritePush", []any{LocalVMSegment, MachineWord(0)}},
+		{"WriteStringConstant", []any{"x"}},
+		{"WritePop", []any{LocalVMSegment, MachineWord(0)}},
+		{"Close", nil},
+	}
+	if !reflect.DeepEqual(recorder.Calls, want) {
+		t.Errorf("recorded calls %v, want %v", recorder.Calls, want)
+	}
+}
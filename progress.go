@@ -0,0 +1,53 @@
+package main
+
+import "io"
+
+// progressWriter reports the share of tokens compiled whenever a function
+// is written.
+type progressWriter struct {
+	OutputWriter
+	scanner  *tokenBuffer
+	progress func(stage string, pct float64)
+}
+
+func (w *progressWriter) useTemps(temps *TempSlots) {
+	shareTemps(w.OutputWriter, temps)
+}
+
+func (w *progressWriter) writeAnnotatedPush(segment VMSegmentType, index MachineWord, comment string) {
+	writeVariablePush(w.OutputWriter, segment, index, comment, true)
+}
+
+func (w *progressWriter) writeAnnotatedPop(segment VMSegmentType, index MachineWord, comment string) {
+	writeVariablePop(w.OutputWriter, segment, index, comment, true)
+}
+
+func (w *progressWriter) WriteFunction(label string, nlocals MachineWord) {
+	w.progress("compiling "+label, 100*w.scanner.consumed())
+	w.OutputWriter.WriteFunction(label, nlocals)
+}
+
+// CompileWithProgress compiles the class read from r and writes its VM code
+// to w. progress is called with the stage and the percentage of the work done:
+// "tokenizing" at 0, "compiling Class.name" at the start of each subroutine
+// with the percentage of tokens consumed so far, and "done" at 100.
+func CompileWithProgress(r io.Reader, w io.Writer, progress func(stage string, pct float64)) error {
+	progress("tokenizing", 0)
+	tokenizer := NewTokenizer(r)
+	var tokens []Token
+	for tokenizer.Scan() {
+		tokens = append(tokens, tokenizer.Token())
+	}
+	if err := tokenizer.Err(); err != nil {
+		return err
+	}
+
+	scanner := newTokenBuffer(tokens)
+	vmWriter := NewVMWriter(w)
+	compiler := NewJackCompiler(scanner, &progressWriter{OutputWriter: &vmWriter, scanner: scanner, progress: progress})
+	if err := compiler.Compile(); err != nil {
+		return err
+	}
+	progress("done", 100)
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompileWithProgress(t *testing.T) {
+	source := `class Main {
+		function void f() { return; }
+		method int g() { return 1; }
+		constructor Main new() { return this; }
+	}`
+	var stages []string
+	var percentages []float64
+	var vm bytes.Buffer
+	err := CompileWithProgress(strings.NewReader(source), &vm, func(stage string, pct float64) {
+		stages = append(stages, stage)
+		percentages = append(percentages, pct)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"tokenizing", "compiling Main.f", "compiling Main.g", "compiling Main.new", "done"}
+	if !reflect.DeepEqual(stages, want) {
+		t.Errorf("stages %q, want %q", stages, want)
+	}
+	for i := 1; i < len(percentages); i++ {
+		if percentages[i] < percentages[i-1] || percentages[i] > 100 {
+			t.Errorf("progress %v is not increasing up to 100", percentages)
+			break
+		}
+	}
+	if !strings.HasPrefix(vm.String(), "function Main.f 0\n") {
+		t.Errorf("unexpected VM code %q", vm.String())
+	}
+}
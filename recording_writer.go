@@ -0,0 +1,115 @@
+package main
+
+// Ops of the commands recordingWriter records for WriteStringConstant and
+// WriteComment, which are no VM commands. The constant or comment is the
+// Label. The Label of a push or pop is its annotation, if any.
+const (
+	stringConstantOp = "<string>"
+	commentOp        = "//"
+)
+
+// recordingWriter is an OutputWriter that records commands as VMCommands,
+// e.g. to replay them on another OutputWriter later.
+type recordingWriter struct {
+	// Commands in the order they were written
+	Commands []VMCommand
+}
+
+func (w *recordingWriter) record(command VMCommand) {
+	w.Commands = append(w.Commands, command)
+}
+
+// Replay writes all recorded commands to output.
+func (w *recordingWriter) Replay(output OutputWriter) {
+	for _, command := range w.Commands {
+		switch command.Op {
+		case "push":
+			writeVariablePush(output, command.Segment, command.Index, command.Label, command.Label != "")
+		case "pop":
+			writeVariablePop(output, command.Segment, command.Index, command.Label, command.Label != "")
+		case "label":
+			output.WriteLabel(command.Label)
+		case "goto":
+			output.WriteGoto(command.Label)
+		case "if-goto":
+			output.WriteIf(command.Label)
+		case "call":
+			output.WriteCall(command.Label, command.Args)
+		case "function":
+			output.WriteFunction(command.Label, command.Args)
+		case "return":
+			output.WriteReturn()
+		case stringConstantOp:
+			output.WriteStringConstant(command.Label)
+		case commentOp:
+			output.WriteComment(command.Label)
+		default:
+			if operation := VMOperation(command.Op); operation.IsValid() {
+				output.WriteArithmetic(operation)
+			} else {
+				output.WriteCommand(command.Op)
+			}
+		}
+	}
+}
+
+func (w *recordingWriter) WriteCommand(command string) {
+	w.record(VMCommand{Op: command})
+}
+
+func (w *recordingWriter) WritePush(segment VMSegmentType, index MachineWord) {
+	w.record(VMCommand{Op: "push", Segment: segment, Index: index})
+}
+
+func (w *recordingWriter) WritePop(segment VMSegmentType, index MachineWord) {
+	w.record(VMCommand{Op: "pop", Segment: segment, Index: index})
+}
+
+func (w *recordingWriter) writeAnnotatedPush(segment VMSegmentType, index MachineWord, comment string) {
+	w.record(VMCommand{Op: "push", Segment: segment, Index: index, Label: comment})
+}
+
+func (w *recordingWriter) writeAnnotatedPop(segment VMSegmentType, index MachineWord, comment string) {
+	w.record(VMCommand{Op: "pop", Segment: segment, Index: index, Label: comment})
+}
+
+func (w *recordingWriter) WriteArithmetic(operation VMOperation) {
+	w.record(VMCommand{Op: string(operation)})
+}
+
+func (w *recordingWriter) WriteLabel(label string) {
+	w.record(VMCommand{Op: "label", Label: label})
+}
+
+func (w *recordingWriter) WriteGoto(label string) {
+	w.record(VMCommand{Op: "goto", Label: label})
+}
+
+func (w *recordingWriter) WriteIf(label string) {
+	w.record(VMCommand{Op: "if-goto", Label: label})
+}
+
+func (w *recordingWriter) WriteCall(label string, nargs MachineWord) {
+	w.record(VMCommand{Op: "call", Label: label, Args: nargs})
+}
+
+func (w *recordingWriter) WriteFunction(label string, nlocals MachineWord) {
+	w.record(VMCommand{Op: "function", Label: label, Args: nlocals})
+}
+
+func (w *recordingWriter) WriteStringConstant(constant string) {
+	w.record(VMCommand{Op: stringConstantOp, Label: constant})
+}
+
+func (w *recordingWriter) WriteReturn() {
+	w.record(VMCommand{Op: "return"})
+}
+
+func (w *recordingWriter) WriteComment(comment string) {
+	w.record(VMCommand{Op: commentOp, Label: comment})
+}
+
+// Close does nothing, the recorded commands are kept for Replay.
+func (w *recordingWriter) Close() error {
+	return nil
+}
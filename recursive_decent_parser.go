@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type SubroutineType string
@@ -15,10 +18,33 @@ const (
 	ConstructorSubroutineType SubroutineType = "constructor"
 )
 
+type SubroutineInfo struct {
+	name           string
+	subroutineType SubroutineType
+	returnType     string
+	nlocals        MachineWord
+}
+
+// classCall is a call of a subroutine of the compiled class.
+type classCall struct {
+	function string
+	// Whether the call passes an object, i.e. is of the form name(...) or
+	// var.name(...)
+	asMethod bool
+	// Whether a do statement discards the result
+	discarded bool
+	// Subroutine containing the call
+	caller string
+	// Range of the call's first token
+	source Range
+}
+
 type TokenScanner interface {
 	Token() Token
 	Err() error
 	Scan() bool
+	// Position returns the line and column of the current token
+	Position() (line, col int)
 }
 
 type OutputWriter interface {
@@ -33,74 +59,397 @@ type OutputWriter interface {
 	WriteFunction(string, MachineWord)
 	WriteStringConstant(string)
 	WriteReturn()
+	WriteComment(string)
+	// Close writes any buffered commands and returns the first error
+	// encountered while writing
+	Close() error
+}
+
+// CompilerOptions enables optional checks of the JackCompiler.
+type CompilerOptions struct {
+	// WarnTypes warns about arithmetic on booleans and boolean array indices
+	WarnTypes bool
+	// FoldConstants evaluates constant expressions at compile time. Requires
+	// compiling through the syntax tree, see FoldConstants. The JackCompiler
+	// still checks the class, without output.
+	FoldConstants bool
+	// DropDeadCode omits statements following a return from the output
+	DropDeadCode bool
+	// Peephole removes redundant VM command sequences, see PeepholeWriter
+	Peephole bool
+	// PoolStrings builds repeated string constants once per function, see
+	// StringPoolWriter
+	PoolStrings bool
+	// StrictClassNames makes a class name differing from the file name an
+	// error instead of a warning
+	StrictClassNames bool
+	// RelaxedVarDecs allows var declarations between statements. Not
+	// supported with FoldConstants.
+	RelaxedVarDecs bool
+	// WarnChainedCompare warns about comparisons of comparison results, e.g.
+	// "a < b < c"
+	WarnChainedCompare bool
+	// WarnUnused warns about local variables that are never referenced
+	WarnUnused bool
+	// WarnStyle warns about empty if, else and while bodies and
+	// self-assignments like "let x = x;"
+	WarnStyle bool
+	// RecoverErrors records errors within statements and continues with the
+	// next statement instead of aborting, see CompileErrors
+	RecoverErrors bool
+	// MaxErrors stops compiling a class with RecoverErrors once this many
+	// errors are recorded, adding an info diagnostic "too many errors". 0
+	// means no limit.
+	MaxErrors int
+	// StrictCalls makes calling a method of the compiled class without an
+	// object, or a function or constructor with one, an error
+	StrictCalls bool
+	// ShortCircuit skips the right operand of "&" and "|" if the left operand
+	// is a boolean that determines the result. Not supported with
+	// FoldConstants, which has no operand types.
+	ShortCircuit bool
+	// CheckBounds passes every array index to a user provided
+	// "function int checkBounds(int index, Array array)" of class Array before
+	// accessing the element. Jack arrays do not know their length, so the
+	// function has to rely on a convention of the program, e.g. storing the
+	// length in front of the elements. It has to return index, or halt if it
+	// is out of bounds.
+	CheckBounds bool
+	// MultipleClasses allows several classes in one source, compiled one
+	// after another. Class names are not checked against the file name.
+	// Not supported with FoldConstants.
+	MultipleClasses bool
+	// SeparateFunctions precedes each function in the output by a blank line
+	// and a comment naming it, see VMWriter
+	SeparateFunctions bool
+	// TabWidth of the tokenizer the command line driver creates, see
+	// Tokenizer.SetTabWidth
+	TabWidth int
+	// ExtOperators allows the operator "%", the remainder of the division
+	// rounded towards zero. It is compiled to a call of Math.mod, which is not
+	// part of the OS and has to be provided by the program or WriteMathRoutines.
+	ExtOperators bool
+	// Annotate follows every push and pop of a variable by a comment naming
+	// it, e.g. "push local 2 // x"
+	Annotate bool
+	// Signatures of classes compiled separately, e.g. the OS, if not nil.
+	// Calls to their subroutines are checked for the kind and number of
+	// arguments.
+	Signatures Signatures
+	// Only restricts the output to the subroutine with this qualified name,
+	// e.g. "Main.main", if not empty. The other subroutines are still checked.
+	Only string
+	// CallGraph collects the calls of all compiled subroutines if not nil
+	CallGraph *CallGraph
+	// SymbolReport collects the symbols of all compiled classes if not nil
+	SymbolReport *SymbolReport
+	// Stubs collects the functions called and defined by the output of all
+	// compiled classes if not nil
+	Stubs *Stubs
+	// DiagnosticReport collects the errors and warnings of all compiled files
+	// if not nil
+	DiagnosticReport *DiagnosticReport
+	// SharedStatics counts the statics of all compiled classes if not nil.
+	// The statics of each class follow those of the classes compiled before,
+	// for writing several files to one VM file, which has one static segment.
+	SharedStatics *MachineWord
 }
 
 type JackCompiler struct {
-	tokenScanner     TokenScanner
-	symbolTable      SymbolTable
-	output           OutputWriter
-	currentClassName string
-	nextLabelID      uint64
+	Options CompilerOptions
+	// Filename of the compiled source, used to prefix diagnostics
+	Filename string
+
+	tokenScanner      TokenScanner
+	symbolTable       SymbolTable
+	output            OutputWriter
+	currentClassName  string
+	currentSubroutine SubroutineInfo
+	nextLabelID       uint64
+	// Shared with output, see shareTemps
+	temps      *TempSlots
+	openBraces braceStack
+	// Number of tokens advanced over, for telling how many tokens a
+	// construct spans
+	advances int
+	// Type of the most recently compiled term or expression, empty if unknown
+	termType string
+	warnings []string
+	// Errors and warnings with their source ranges
+	diagnostics []Diagnostic
+	// Names of the symbols referenced by the current subroutine
+	usedSymbols map[string]bool
+	// The class's subroutines by function name
+	subroutines map[string]SubroutineInfo
+	// Calls checked against subroutines once all of them are known
+	classCalls []classCall
+	// Number of statics of the previous classes of the source, which share
+	// the static segment of the output file with MultipleClasses
+	staticBase MachineWord
 }
 
 func NewJackCompiler(tokenScanner TokenScanner, output OutputWriter) *JackCompiler {
+	temps := &TempSlots{}
+	shareTemps(output, temps)
 	return &JackCompiler{
 		tokenScanner: tokenScanner,
 		symbolTable:  NewSymbolTable(),
 		output:       output,
+		temps:        temps,
+		usedSymbols:  make(map[string]bool),
+	}
+}
+
+// Reset prepares the compiler for compiling another source read from
+// tokenScanner to output. Options are kept, everything learned about the
+// previously compiled classes is forgotten.
+func (c *JackCompiler) Reset(tokenScanner TokenScanner, output OutputWriter) {
+	c.tokenScanner = tokenScanner
+	c.output = output
+	c.Filename = ""
+	c.symbolTable.Clear(ClassScope)
+	c.symbolTable.Clear(FunctionScope)
+	c.currentClassName = ""
+	c.currentSubroutine = SubroutineInfo{}
+	c.staticBase = 0
+	c.nextLabelID = 0
+	c.temps = &TempSlots{}
+	shareTemps(output, c.temps)
+	c.termType = ""
+	c.warnings = nil
+	c.diagnostics = nil
+	for name := range c.usedSymbols {
+		delete(c.usedSymbols, name)
+	}
+	c.subroutines = nil
+	c.classCalls = nil
+}
+
+// Warnings returns the warnings collected during compilation.
+func (c *JackCompiler) Warnings() []string {
+	return c.warnings
+}
+
+func (c *JackCompiler) warn(format string, args ...any) {
+	c.warnAt(tokenRange(c.nextToken()), format, args...)
+}
+
+func (c *JackCompiler) warnAt(r Range, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	c.warnings = append(c.warnings, c.location()+": "+message)
+	c.diagnostics = append(c.diagnostics, Diagnostic{Severity: WarningSeverity, Message: message, Range: r})
+}
+
+// fail aborts compilation with an error at r. If errors are recovered, the
+// error is only recorded.
+func (c *JackCompiler) fail(r Range, message string) {
+	if !c.Options.RecoverErrors {
+		panic(&CompileError{Message: message, Position: r.Start, End: r.End})
+	}
+	c.addError(Diagnostic{Severity: ErrorSeverity, Message: message, Range: r})
+}
+
+// errTooManyErrors aborts compilation once Options.MaxErrors is reached.
+var errTooManyErrors = errors.New("too many errors")
+
+// addError records a recovered error. Panics with errTooManyErrors if it is
+// the last one allowed by Options.MaxErrors.
+func (c *JackCompiler) addError(diagnostic Diagnostic) {
+	c.diagnostics = append(c.diagnostics, diagnostic)
+	if c.Options.MaxErrors <= 0 {
+		return
+	}
+	count := 0
+	for _, diagnostic := range c.diagnostics {
+		if diagnostic.Severity == ErrorSeverity {
+			count += 1
+		}
+	}
+	if count >= c.Options.MaxErrors {
+		c.diagnostics = append(c.diagnostics, Diagnostic{Severity: InfoSeverity, Message: tooManyErrorsMessage(c.Options.MaxErrors), Range: diagnostic.Range})
+		panic(errTooManyErrors)
+	}
+}
+
+// defaultMaxErrors is the MaxErrors of Diagnostics and the command line
+const defaultMaxErrors = 20
+
+func tooManyErrorsMessage(maxErrors int) string {
+	return fmt.Sprintf("too many errors, stopped after %d", maxErrors)
+}
+
+// location describes the compiled file or, if unknown, class for diagnostics.
+func (c *JackCompiler) location() string {
+	switch {
+	case c.Filename != "":
+		return c.Filename
+	case c.currentClassName != "":
+		return "class " + c.currentClassName
+	default:
+		return "<input>"
 	}
 }
 
-func (c *JackCompiler) generateLabel() string {
+// generateLabel returns a label prefix unique within the class, namespaced
+// by the current subroutine, e.g. "Foo.bar$WHILE0".
+func (c *JackCompiler) generateLabel(kind string) string {
 	labelID := c.nextLabelID
 	c.nextLabelID += 1
-	return "L" + strconv.FormatUint(labelID, 10) + ":"
+	return c.currentClassName + "." + c.currentSubroutine.name + "$" + kind + strconv.FormatUint(labelID, 10)
 }
 
 func (c *JackCompiler) writeFunction(functionName string, nargs MachineWord) {
+	if c.Options.CallGraph != nil {
+		c.Options.CallGraph.AddSubroutine(c.currentClassName + "." + functionName)
+	}
 	c.output.WriteFunction(c.currentClassName+"."+functionName, nargs)
 }
 
-func (c *JackCompiler) generateVariableAccess(varName string) (VMSegmentType, MachineWord) {
+func (c *JackCompiler) writeCall(function string, nargs MachineWord) {
+	if c.Options.CallGraph != nil {
+		c.Options.CallGraph.AddCall(c.currentClassName+"."+c.currentSubroutine.name, function)
+	}
+	c.output.WriteCall(function, nargs)
+}
+
+func (c *JackCompiler) declare(symbol Symbol, name string, scope Scope) {
+	if _, err := c.symbolTable.Declare(symbol, name, scope); err != nil {
+		c.fail(textRange(symbol.position, name), fmt.Sprintf("%v, redeclared in %s", err, c.scopeName(scope)))
+	}
+}
+
+func (c *JackCompiler) scopeName(scope Scope) string {
+	if scope == ClassScope {
+		return "class " + c.currentClassName
+	}
+	return "subroutine " + c.currentClassName + "." + c.currentSubroutine.name
+}
+
+// requireThis fails if what, a use of the this pointer at token, appears in a
+// function, which has no this.
+func (c *JackCompiler) requireThis(token Token, what string) {
+	if c.currentSubroutine.subroutineType == FunctionSubroutineType {
+		c.fail(tokenRange(token), fmt.Sprintf("%s used in function %s.%s, which has no this", what, c.currentClassName, c.currentSubroutine.name))
+	}
+}
+
+func (c *JackCompiler) generateVariableAccess(varToken Token) (VMSegmentType, MachineWord) {
+	varName := varToken.terminal
 	symbol, err := c.symbolTable.Lookup(varName)
 	if err != nil {
-		panic(fmt.Sprintf("Unknown variable: %q\n", varName))
+		panic(tokenError(varToken, fmt.Sprintf("Unknown variable: %q", varName)))
 	}
+	c.usedSymbols[varName] = true
 
 	switch symbol.symbolType {
 	case StaticSymbol:
-		return StaticVMSegment, symbol.index
+		return StaticVMSegment, c.staticBase + symbol.index
 	case ArgumentSymbol:
 		return ArgumentVMSegment, symbol.index
 	case VarSymbol:
 		return LocalVMSegment, symbol.index
 	case FieldSymbol:
+		c.requireThis(varToken, fmt.Sprintf("field %q", varName))
 		return ThisVMSegment, symbol.index
 	default:
 		panic(fmt.Sprintf("Unknown symbolType: %q\n", symbol.symbolType))
 	}
 }
 
-func (c *JackCompiler) generateArrayElemPointer(name string) {
+// annotatingWriter is implemented by OutputWriters that can follow a push or
+// pop by a comment on the same line. Wrappers pass the comment on, others
+// write the bare command.
+type annotatingWriter interface {
+	writeAnnotatedPush(segment VMSegmentType, index MachineWord, comment string)
+	writeAnnotatedPop(segment VMSegmentType, index MachineWord, comment string)
+}
+
+// writeVariablePush pushes the variable name stored at segment index. With
+// annotate the command is followed by a comment naming the variable, e.g.
+// "push local 2 // x", if output supports it.
+func writeVariablePush(output OutputWriter, segment VMSegmentType, index MachineWord, name string, annotate bool) {
+	if annotator, ok := output.(annotatingWriter); ok && annotate {
+		annotator.writeAnnotatedPush(segment, index, name)
+		return
+	}
+	output.WritePush(segment, index)
+}
+
+// writeVariablePop pops into the variable name stored at segment index, see
+// writeVariablePush.
+func writeVariablePop(output OutputWriter, segment VMSegmentType, index MachineWord, name string, annotate bool) {
+	if annotator, ok := output.(annotatingWriter); ok && annotate {
+		annotator.writeAnnotatedPop(segment, index, name)
+		return
+	}
+	output.WritePop(segment, index)
+}
+
+func (c *JackCompiler) generateArrayElemPointer(nameToken Token) {
+	name := nameToken.terminal
 	// Stores offset on top of stack
-	c.compileExpression()
+	if err := c.compileExpression(); err != nil {
+		panic(err)
+	}
+	if c.Options.WarnTypes && c.termType == "boolean" {
+		c.warn("boolean used as index of array %q", name)
+	}
 
 	// Emit code that moves the that pointer
 	// Store base addr on stack
-	segment, index := c.generateVariableAccess(name)
-	c.output.WritePush(segment, index)
+	segment, index := c.generateVariableAccess(nameToken)
+	if c.Options.CheckBounds {
+		writeVariablePush(c.output, segment, index, name, c.Options.Annotate)
+		c.output.WriteCall(boundsCheckFunction, 2)
+	}
+	writeVariablePush(c.output, segment, index, name, c.Options.Annotate)
 	// Add together
 	c.output.WriteArithmetic(AddVMOperation)
 }
 
+// writeIndexedElement replaces the array and the index on top of the stack
+// by the element. With checkBounds, the operands are reordered through temp
+// for calling boundsCheckFunction. No slot is held across the call, which may
+// use temp itself.
+func writeIndexedElement(output OutputWriter, temps *TempSlots, checkBounds bool) {
+	if checkBounds {
+		index := temps.Reserve()
+		array := temps.Reserve()
+		output.WritePop(TempVMSegment, index)
+		output.WritePop(TempVMSegment, array)
+		output.WritePush(TempVMSegment, array)
+		output.WritePush(TempVMSegment, index)
+		output.WritePush(TempVMSegment, array)
+		temps.Release(array)
+		temps.Release(index)
+		output.WriteCall(boundsCheckFunction, 2)
+	}
+	output.WriteArithmetic(AddVMOperation)
+	// Pop the address into pointer (THAT) and push the element
+	output.WritePop(PointerVMSegment, 1)
+	output.WritePush(ThatVMSegment, 0)
+}
+
+// boundsCheckFunction is called with the index and the array on the stack
+// with CompilerOptions.CheckBounds and returns the index.
+const boundsCheckFunction = "Array.checkBounds"
+
 func (c *JackCompiler) nextToken() Token {
 	return c.tokenScanner.Token()
 }
 
+// emptySourceError is reported for sources containing only whitespace and
+// comments.
+const emptySourceError = "empty source, no class found"
+
 func (c *JackCompiler) advance() Token {
-	if !c.tokenScanner.Scan() {
+	if !scanCode(c.tokenScanner) {
 		panic("Could not advance token scanner!")
 	}
+	if IsTokenType(c.nextToken(), EOF) {
+		panic(c.openBraces.endOfSourceError())
+	}
+	c.advances += 1
 	return c.nextToken()
 }
 
@@ -112,38 +461,140 @@ func (c *JackCompiler) consume(expectedTerminals ...string) {
 
 	for _, expectedTerminal := range expectedTerminals {
 		if !IsTerminal(c.nextToken(), expectedTerminal) {
-			panic("Expected terminal \"" + expectedTerminal + "\", got \"" + c.nextToken().terminal + "\"")
+			panic(unexpectedTokenError(c.nextToken(), expectedTerminal))
 		}
+		c.openBraces.track(c.nextToken())
 		c.advance()
 	}
 }
 
-func (c *JackCompiler) Compile() {
-	c.advance()
-	c.compileClass()
-	return
+// CompileErrors are all errors recovered from while compiling a class.
+type CompileErrors []error
+
+func (e CompileErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// compileError formats diagnostic, an error in the compiled source, the same
+// whether errors are recovered or not, e.g. "Main.jack: expected term, got
+// \";\" at line 3, col 17".
+func (c *JackCompiler) compileError(diagnostic Diagnostic) error {
+	if diagnostic.Range.Start.Line == 0 {
+		// Not caused by any token, e.g. a read error
+		return fmt.Errorf("%s: %s", c.location(), diagnostic.Message)
+	}
+	return fmt.Errorf("%s: %s at %s", c.location(), diagnostic.Message, diagnostic.Range.Start)
+}
+
+// Compile compiles a single class. Returns the first syntax or semantic
+// error encountered or, if Options.RecoverErrors is set, CompileErrors
+// holding all of them.
+func (c *JackCompiler) Compile() (err error) {
+	defer func() {
+		stopped := false
+		if r := recover(); r == errTooManyErrors {
+			stopped = true
+		} else if r != nil {
+			if scanErr := c.tokenScanner.Err(); scanErr != nil {
+				r = scanErr
+			}
+			diagnostic := Diagnostic{Severity: ErrorSeverity, Message: diagnosticMessage(r), Range: diagnosticRange(r, c.nextToken())}
+			c.diagnostics = append(c.diagnostics, diagnostic)
+			err = c.compileError(diagnostic)
+		}
+
+		if c.Options.RecoverErrors {
+			var errs CompileErrors
+			for _, diagnostic := range c.diagnostics {
+				if diagnostic.Severity == ErrorSeverity {
+					errs = append(errs, c.compileError(diagnostic))
+				}
+			}
+			if stopped {
+				errs = append(errs, fmt.Errorf("%s: %s", c.location(), tooManyErrorsMessage(c.Options.MaxErrors)))
+			}
+			if errs != nil {
+				err = errs
+			}
+		}
+
+		if c.Options.DiagnosticReport != nil {
+			c.Options.DiagnosticReport.Add(c.Filename, c.diagnostics...)
+		}
+
+		if closeErr := c.output.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("%s: %v", c.location(), closeErr)
+		}
+
+		if c.Options.SharedStatics != nil && err == nil {
+			*c.Options.SharedStatics = c.staticBase + c.symbolTable.Count(StaticSymbol, ClassScope)
+		}
+	}()
+
+	if c.Options.SharedStatics != nil {
+		c.staticBase = *c.Options.SharedStatics
+	}
+	if !scanCode(c.tokenScanner) || IsTokenType(c.nextToken(), EOF) {
+		panic(emptySourceError)
+	}
+	for {
+		c.compileClass()
+		// The class has to end with } followed by the end of the source or,
+		// with MultipleClasses, the next class
+		if !IsTerminal(c.nextToken(), "}") || !scanCode(c.tokenScanner) {
+			panic("Unexpected end of class")
+		}
+		switch {
+		case IsTokenType(c.nextToken(), EOF):
+			return nil
+		case IsTerminal(c.nextToken(), "}"):
+			panic(unmatchedBraceError(c.nextToken()))
+		case !c.Options.MultipleClasses:
+			panic("Unexpected end of class")
+		}
+	}
 }
 
 func (c *JackCompiler) compileClass() {
 	c.consume("class")
 
+	c.openBraces = nil
+	c.staticBase += c.symbolTable.Count(StaticSymbol, ClassScope)
 	c.symbolTable.Clear(ClassScope)
+	c.classCalls = nil
 
-	if className, err := parseIdentifier(c.nextToken()); err == nil {
+	nameToken := c.nextToken()
+	if className, err := parseIdentifier(nameToken); err == nil {
 		c.currentClassName = className
 		c.advance()
 	} else {
 		panic(err)
 	}
 
+	if c.Filename != "" && !c.Options.MultipleClasses && getClassName(c.Filename) != c.currentClassName {
+		if c.Options.StrictClassNames {
+			panic(tokenError(nameToken, fmt.Sprintf("class %s must be declared in %s.jack", c.currentClassName, c.currentClassName)))
+		}
+		c.warnAt(tokenRange(nameToken), "class %s should be declared in %s.jack", c.currentClassName, c.currentClassName)
+	}
+
 	c.consume("{")
 	for c.compileClassVarDec() == nil {
 	}
+	if c.Options.SymbolReport != nil {
+		c.Options.SymbolReport.AddClass(c.currentClassName, &c.symbolTable)
+	}
 	for c.compileSubroutineDec() == nil {
 	}
-	// Return an error if the next terminal is not } or we are not at EOF
-	if c.nextToken().terminal != "}" || c.tokenScanner.Scan() {
-		panic("Unexpected end of class")
+	c.checkClassCalls()
+	if c.Options.Only != "" && strings.HasPrefix(c.Options.Only, c.currentClassName+".") {
+		if _, ok := c.subroutines[c.Options.Only]; !ok {
+			panic(fmt.Sprintf("no subroutine %s", c.Options.Only))
+		}
 	}
 }
 
@@ -168,13 +619,17 @@ func (c *JackCompiler) compileVarSequence(symbolType SymbolType, symbolScope Sco
 	c.consume()
 
 	for {
-		varName, _ := parseIdentifier(c.nextToken())
+		symbol.position = c.nextToken().position
+		varName, err := parseIdentifier(c.nextToken())
+		if err != nil {
+			panic(err)
+		}
 		c.consume() // consume identifier
 
 		numDeclarations += 1
 
 		// Register types in symbol table
-		c.symbolTable.Declare(symbol, varName, symbolScope)
+		c.declare(symbol, varName, symbolScope)
 		if IsTerminal(c.nextToken(), ",") {
 			c.consume(",")
 		} else {
@@ -187,6 +642,7 @@ func (c *JackCompiler) compileVarSequence(symbolType SymbolType, symbolScope Sco
 
 func (c *JackCompiler) compileSubroutineDec() error {
 	c.symbolTable.Clear(FunctionScope)
+	c.usedSymbols = make(map[string]bool)
 
 	methodType, err := parseSubroutineType(c.nextToken())
 	if err != nil {
@@ -200,13 +656,30 @@ func (c *JackCompiler) compileSubroutineDec() error {
 			variableType: c.currentClassName,
 		}
 
-		c.symbolTable.Declare(thisSymbol, "this", FunctionScope)
+		c.declare(thisSymbol, "this", FunctionScope)
 	}
 
 	c.consume()
-	name, _ := parseIdentifier(c.advance())
+	returnType, err := parseReturnType(c.nextToken())
+	if err != nil {
+		panic(err)
+	}
+	name, err := parseIdentifier(c.advance())
+	if err != nil {
+		panic(err)
+	}
 	c.consume() // Consume identfier
 
+	c.currentSubroutine = SubroutineInfo{
+		name:           name,
+		subroutineType: methodType,
+		returnType:     returnType,
+	}
+	if c.subroutines == nil {
+		c.subroutines = make(map[string]SubroutineInfo)
+	}
+	c.subroutines[c.currentClassName+"."+name] = c.currentSubroutine
+
 	c.consume("(")
 
 	if !IsTerminal(c.nextToken(), ")") {
@@ -215,6 +688,11 @@ func (c *JackCompiler) compileSubroutineDec() error {
 
 	c.consume(")")
 
+	if c.Options.Only != "" && c.Options.Only != c.currentClassName+"."+name {
+		output := c.output
+		c.output = NullWriter{}
+		defer func() { c.output = output }()
+	}
 	c.compileSubroutine(name, methodType)
 
 	return nil
@@ -222,16 +700,24 @@ func (c *JackCompiler) compileSubroutineDec() error {
 
 func (c *JackCompiler) compileSubroutine(name string, subroutineType SubroutineType) {
 	c.consume("{")
-	nlocals := MachineWord(0)
 	for {
 		varCount := c.compileVarDec()
 		if varCount == 0 {
 			break
 		}
-		nlocals += varCount
+		c.currentSubroutine.nlocals += varCount
 	}
 
-	c.writeFunction(name, nlocals)
+	output := c.output
+	var body *recordingWriter
+	if c.Options.RelaxedVarDecs {
+		// Further locals may be declared by the statements, defer the
+		// function header until their number is known.
+		body = &recordingWriter{}
+		c.output = body
+	} else {
+		c.writeFunction(name, c.currentSubroutine.nlocals)
+	}
 
 	switch subroutineType {
 	case ConstructorSubroutineType:
@@ -248,8 +734,35 @@ func (c *JackCompiler) compileSubroutine(name string, subroutineType SubroutineT
 		c.output.WritePop(PointerVMSegment, 0)
 	}
 
-	c.compileStatements()
+	if !c.compileStatements() {
+		if c.currentSubroutine.returnType != "void" {
+			c.warn("subroutine %s.%s of type %s may end without returning a value", c.currentClassName, name, c.currentSubroutine.returnType)
+		} else {
+			// Return implicitly from void subroutines, e.g. ones with empty bodies
+			c.output.WritePush(ConstVMSegment, 0)
+			c.output.WriteReturn()
+		}
+	}
 	c.consume("}")
+
+	if body != nil {
+		c.output = output
+		c.writeFunction(name, c.currentSubroutine.nlocals)
+		body.Replay(c.output)
+	}
+
+	if c.Options.WarnUnused {
+		c.symbolTable.Range(FunctionScope, func(varName string, symbol Symbol) bool {
+			if symbol.symbolType == VarSymbol && !c.usedSymbols[varName] {
+				c.warnAt(textRange(symbol.position, varName), "unused variable %q in %s.%s", varName, c.currentClassName, name)
+			}
+			return true
+		})
+	}
+
+	if c.Options.SymbolReport != nil {
+		c.Options.SymbolReport.AddSubroutine(name, &c.symbolTable)
+	}
 }
 
 func (c *JackCompiler) compileParameterList() {
@@ -257,11 +770,15 @@ func (c *JackCompiler) compileParameterList() {
 	for {
 		symbol.variableType, _ = parseType(c.nextToken())
 		c.consume()
-		varName, _ := parseVarName(c.nextToken())
+		symbol.position = c.nextToken().position
+		varName, err := parseVarName(c.nextToken())
+		if err != nil {
+			panic(err)
+		}
 		c.consume()
 
 		// Register types in symbol table
-		c.symbolTable.Declare(symbol, varName, FunctionScope)
+		c.declare(symbol, varName, FunctionScope)
 
 		if IsTerminal(c.nextToken(), ",") {
 			c.consume(",")
@@ -279,164 +796,462 @@ func (c *JackCompiler) compileVarDec() MachineWord {
 	return c.compileVarSequence(VarSymbol, FunctionScope)
 }
 
-func (c *JackCompiler) compileStatements() {
+// compileStatements compiles statements up to the closing "}" and reports
+// whether every control path through them ends in a return statement.
+func (c *JackCompiler) compileStatements() (returns bool) {
+	output := c.output
+	defer func() { c.output = output }()
+
+	unreachable := false
 	for !IsTerminal(c.nextToken(), "}") {
-		// Compile next statement
-		switch token := c.nextToken(); {
-		case IsTerminal(token, "let"):
-			c.compileLet()
-		case IsTerminal(token, "if"):
-			c.compileIf()
-		case IsTerminal(token, "while"):
-			c.compileWhile()
-		case IsTerminal(token, "do"):
-			c.compileDo()
-		case IsTerminal(token, "return"):
-			c.compileReturn()
-		default:
-			panic("unexpected token " + token.terminal)
+		if returns && !unreachable {
+			unreachable = true
+			c.warn("unreachable code after return in %s.%s", c.currentClassName, c.currentSubroutine.name)
+			if c.Options.DropDeadCode {
+				c.output = NullWriter{}
+			}
 		}
+
+		returns = c.compileStatement() || returns
+	}
+	return returns
+}
+
+// compileStatement compiles the next statement and reports whether it
+// returns on every control path. If errors are recovered, a failing
+// statement is recorded and skipped up to the next ";" or "}".
+func (c *JackCompiler) compileStatement() (returns bool) {
+	if c.Options.RecoverErrors {
+		start := c.nextToken()
+		defer func() {
+			if r := recover(); r != nil {
+				if c.tokenScanner.Err() != nil || r == errTooManyErrors {
+					panic(r)
+				}
+				c.addError(Diagnostic{Severity: ErrorSeverity, Message: diagnosticMessage(r), Range: diagnosticRange(r, c.nextToken())})
+				c.skipStatement(start)
+				// A failed return statement still ends the control path, it
+				// is not reported as missing as well
+				returns = IsTerminal(start, "return")
+			}
+		}()
+	}
+
+	switch token := c.nextToken(); {
+	case IsTerminal(token, "let"):
+		c.compileLet()
+	case IsTerminal(token, "if"):
+		return c.compileIf()
+	case IsTerminal(token, "while"):
+		c.compileWhile()
+	case IsTerminal(token, "do"):
+		c.compileDo()
+	case IsTerminal(token, "return"):
+		c.compileReturn()
+		return true
+	case IsTerminal(token, "var") && c.Options.RelaxedVarDecs:
+		c.currentSubroutine.nlocals += c.compileVarDec()
+	default:
+		panic("unexpected token " + token.terminal)
+	}
+	return false
+}
+
+// skipStatement advances past the next ";" or up to the next "}" or the
+// keyword starting the next statement, e.g. after a missing ";". start is
+// the first token of the failed statement, which is always skipped.
+func (c *JackCompiler) skipStatement(start Token) {
+	if c.nextToken() == start {
+		c.advance()
+	}
+	for !IsTerminal(c.nextToken(), ";", "}", "let", "if", "while", "do", "return") {
+		c.advance()
+	}
+	if IsTerminal(c.nextToken(), ";") {
+		c.advance()
 	}
 }
 
 func (c *JackCompiler) compileDo() {
 	c.consume("do")
-	c.compileSubroutineCall("")
+	nameToken := c.nextToken()
+	if _, err := parseIdentifier(nameToken); err != nil {
+		panic(err)
+	}
+	c.advance()
+
+	calls := len(c.classCalls)
+	c.compileSubroutineCall(nameToken)
+	if IsTerminal(c.nextToken(), "[") {
+		// Index the array returned by the call, e.g. for its bounds check
+		c.consume("[")
+		if err := c.compileExpression(); err != nil {
+			panic(err)
+		}
+		c.termType = ""
+		writeIndexedElement(c.output, c.temps, c.Options.CheckBounds)
+		c.consume("]")
+	} else if len(c.classCalls) > calls {
+		c.classCalls[calls].discarded = true
+	}
 
 	// Discard unused return value
-	c.output.WritePop(TempVMSegment, 0)
+	discard := c.temps.Reserve()
+	c.output.WritePop(TempVMSegment, discard)
+	c.temps.Release(discard)
 
 	c.consume(";")
 }
 
 func (c *JackCompiler) compileLet() {
-	varName := c.advance().terminal
+	varNameToken := c.advance()
+	if !IsTokenType(varNameToken, Identifier) {
+		panic(letTargetError(varNameToken))
+	}
 	// Where to store the result of the RHS expression
 	isArrayAccess := false
 
+	if IsTerminal(c.advance(), ".") {
+		panic(memberAccessError(varNameToken, c.advance()))
+	}
+	if _, err := c.symbolTable.Lookup(varNameToken.terminal); err != nil {
+		panic(tokenError(varNameToken, fmt.Sprintf("%s in %s.%s", undeclaredTargetMessage(varNameToken.terminal, IsTerminal(c.nextToken(), "[")), c.currentClassName, c.currentSubroutine.name)))
+	}
+
 	// Evaluate destination address if LHS is an array
-	if IsTerminal(c.advance(), "[") {
+	if IsTerminal(c.nextToken(), "[") {
 		isArrayAccess = true
 		c.consume("[")
-		c.generateArrayElemPointer(varName)
-		// Adress *varName + expr_result is not on top of stack
+		c.generateArrayElemPointer(varNameToken)
+		// Address varName + index is on top of the stack. It stays there
+		// while the RHS is compiled, so array accesses in the RHS, e.g.
+		// "let a[i] = b[j];", may set THAT freely.
 		c.consume("]")
 	}
 
 	// Handle RHS
 	c.consume("=")
-	c.compileExpression()
-	c.consume(";")
+	value := c.nextToken()
+	advances := c.advances
+	if err := c.compileExpression(); err != nil {
+		panic(err)
+	}
+	if c.Options.WarnStyle && !isArrayAccess && c.advances == advances+1 && IsTokenType(value, Identifier) && value.terminal == varNameToken.terminal {
+		c.warnAt(tokenRange(varNameToken), "let %s = %s in %s.%s has no effect", value.terminal, value.terminal, c.currentClassName, c.currentSubroutine.name)
+	}
 	// Layout: Value of expression is on top of stack.
 	//		   -> Pop last value into var Name
 	if isArrayAccess {
 		// Save result of RHS expression in temp
-		c.output.WritePop(TempVMSegment, 0)
+		value := c.temps.Reserve()
+		c.output.WritePop(TempVMSegment, value)
 		// Pop array element address into pointer (THAT)
 		c.output.WritePop(PointerVMSegment, 1)
 		// Restore rhs rexpression result from temp
-		c.output.WritePush(TempVMSegment, 0)
+		c.output.WritePush(TempVMSegment, value)
+		c.temps.Release(value)
 		// Pop into destination
 		c.output.WritePop(ThatVMSegment, 0)
 	} else {
-		segment, index := c.generateVariableAccess(varName)
-		c.output.WritePop(segment, index)
+		segment, index := c.generateVariableAccess(varNameToken)
+		writeVariablePop(c.output, segment, index, varNameToken.terminal, c.Options.Annotate)
+	}
+	// Consumed last so a failing lookup is reported within the statement
+	c.consume(";")
+}
+
+// warnEmptyBody warns about an empty body of the statement starting with
+// keyword, e.g. "while", if the next token closes it.
+func (c *JackCompiler) warnEmptyBody(keyword Token) {
+	if c.Options.WarnStyle && IsTerminal(c.nextToken(), "}") {
+		c.warnAt(tokenRange(keyword), "empty %s body in %s.%s", keyword.terminal, c.currentClassName, c.currentSubroutine.name)
 	}
 }
 
 func (c *JackCompiler) compileWhile() {
+	whileToken := c.nextToken()
 	c.consume("while", "(")
 
-	nextLabelPrefix := c.generateLabel()
+	nextLabelPrefix := c.generateLabel("WHILE")
 
-	c.output.WriteLabel(nextLabelPrefix + "BEGIN")
+	c.output.WriteLabel(nextLabelPrefix + "_BEGIN")
 
 	if err := c.compileExpression(); err != nil {
 		panic(err)
 	}
 
 	c.output.WriteArithmetic(NotVMOperation)
-	c.output.WriteIf(nextLabelPrefix + "EXIT")
+	c.output.WriteIf(nextLabelPrefix + "_EXIT")
 
 	c.consume(")", "{")
 
+	c.warnEmptyBody(whileToken)
 	c.compileStatements()
 	c.consume("}")
 
-	c.output.WriteGoto(nextLabelPrefix + "BEGIN")
-	c.output.WriteLabel(nextLabelPrefix + "EXIT")
+	c.output.WriteGoto(nextLabelPrefix + "_BEGIN")
+	c.output.WriteLabel(nextLabelPrefix + "_EXIT")
+}
+
+// checkReturn panics if a return statement at r, returning a value if
+// hasValue, does not match the return type of subroutine of class className.
+// Shared by JackCompiler and CodeGenerator.
+func checkReturn(r Range, className string, subroutine SubroutineInfo, hasValue bool) {
+	if !hasValue && subroutine.returnType != "void" {
+		panic(&CompileError{Message: fmt.Sprintf("subroutine %s.%s of type %s must return a value", className, subroutine.name, subroutine.returnType), Position: r.Start, End: r.End})
+	}
+	if hasValue && subroutine.returnType == "void" {
+		panic(&CompileError{Message: fmt.Sprintf("void subroutine %s.%s must not return a value", className, subroutine.name), Position: r.Start, End: r.End})
+	}
 }
 
 func (c *JackCompiler) compileReturn() {
+	returnToken := c.nextToken()
 	c.consume("return")
+	subroutine := c.currentSubroutine
 	// May have an expression, may not
-	if c.compileExpression() != nil {
+	checkReturn(tokenRange(returnToken), c.currentClassName, subroutine, !IsSymbol(c.nextToken(), ";"))
+	if IsSymbol(c.nextToken(), ";") {
 		// If not, push 0
 		c.output.WritePush(ConstVMSegment, 0)
+	} else {
+		// The return value will be on top of the stack
+		expressionToken := c.nextToken()
+		if err := c.compileExpression(); err != nil {
+			panic(err)
+		}
+		// A term following this would change the type, e.g. "this + 1"
+		isThis := IsTerminal(expressionToken, "this") && c.termType == c.currentClassName
+		if subroutine.subroutineType == ConstructorSubroutineType && !isThis {
+			c.warnAt(tokenRange(expressionToken), "constructor %s.%s should return this", c.currentClassName, subroutine.name)
+		}
 	}
 	c.output.WriteReturn()
-	// Otherwise the return value will already be on the stack
 	c.consume(";")
 }
 
-func (c *JackCompiler) compileIf() {
+// compileIf reports whether both branches of the if statement return.
+func (c *JackCompiler) compileIf() bool {
+	ifToken := c.nextToken()
 	c.consume("if", "(")
 
-	labelPrefix := c.generateLabel()
+	labelPrefix := c.generateLabel("IF")
 
 	if err := c.compileExpression(); err != nil {
 		panic(err)
 	}
 
 	c.output.WriteArithmetic(NotVMOperation)
-	c.output.WriteIf(labelPrefix + "ELSE")
+	c.output.WriteIf(labelPrefix + "_ELSE")
 
 	c.consume(")", "{")
-	c.compileStatements()
+	c.warnEmptyBody(ifToken)
+	returns := c.compileStatements()
 	c.consume("}")
 
-	c.output.WriteGoto(labelPrefix + "END")
-	c.output.WriteLabel(labelPrefix + "ELSE")
-
-	if IsTerminal(c.nextToken(), "else") {
-		c.consume("else", "{")
-		c.compileStatements()
-		c.consume("}")
+	// Without else the ELSE label ends the statement
+	if !IsTerminal(c.nextToken(), "else") {
+		c.output.WriteLabel(labelPrefix + "_ELSE")
+		return false
 	}
 
-	c.output.WriteLabel(labelPrefix + "END")
+	c.output.WriteGoto(labelPrefix + "_END")
+	c.output.WriteLabel(labelPrefix + "_ELSE")
+
+	elseToken := c.nextToken()
+	c.consume("else", "{")
+	c.warnEmptyBody(elseToken)
+	elseReturns := c.compileStatements()
+	c.consume("}")
+
+	c.output.WriteLabel(labelPrefix + "_END")
+	return returns && elseReturns
 }
 
+/*
+ * Expression: term (op term)*
+ *
+ * Operations are applied strictly from left to right, a "-" following a term
+ * is always a binary subtraction. Any "-" at the start of a term is a unary
+ * negation, e.g.:
+ *	- -5     push constant 5, neg, neg
+ *	a - -b   push a, push b, neg, sub
+ *	-(1+2)   push constant 1, push constant 2, add, neg
+ *
+ * Comparisons are no exception, "a < b < c" compares the boolean result of
+ * "a < b" with c.
+ */
 func (c *JackCompiler) compileExpression() error {
 	if err := c.compileTerm(); err != nil {
 		return err
 	}
-	token := c.nextToken()
-	if isBinaryOp(token) {
+	var previousOperator Token
+	for token := c.nextToken(); isBinaryOp(token); token = c.nextToken() {
+		lhsType := c.termType
 		op := parseBinaryOp(token)
+		if c.Options.WarnChainedCompare && isComparison(op) && isComparison(parseBinaryOp(previousOperator)) {
+			c.warn("chained comparison \"%s ... %s\" in %s.%s compares the result of the first comparison", previousOperator.terminal, token.terminal, c.currentClassName, c.currentSubroutine.name)
+		}
+		previousOperator = token
+		if op == ModVMOperation && !c.Options.ExtOperators {
+			c.fail(tokenRange(token), fmt.Sprintf("operator %% in %s.%s is an extension of Jack and not enabled", c.currentClassName, c.currentSubroutine.name))
+		}
 		c.advance()
-		c.compileTerm()
-		// Emit code
-		c.output.WriteArithmetic(op)
+		if divisor, err := parseIntegerConstant(c.nextToken()); err == nil && (op == DivVMOperation || op == ModVMOperation) && divisor == 0 {
+			c.fail(tokenRange(c.nextToken()), fmt.Sprintf("division by zero in %s.%s", c.currentClassName, c.currentSubroutine.name))
+		}
+		if c.Options.ShortCircuit && lhsType == "boolean" && (op == AndVMOperation || op == OrVMOperation) {
+			c.compileShortCircuit(token, op)
+		} else {
+			c.compileOperand(token)
+			c.output.WriteArithmetic(op)
+		}
+		c.termType = c.checkBinaryOpTypes(token.terminal, lhsType, c.termType)
 	}
 	return nil
 }
 
+// compileShortCircuit compiles the right operand of operator op, "&" or "|",
+// only if the boolean left operand on the stack does not determine the
+// result. true & b and false | b equal b.
+func (c *JackCompiler) compileShortCircuit(operator Token, op VMOperation) {
+	labelPrefix := c.generateLabel("SHORT")
+	if op == AndVMOperation {
+		c.output.WriteArithmetic(NotVMOperation)
+	}
+	c.output.WriteIf(labelPrefix + "_SKIP")
+	c.compileOperand(operator)
+	c.output.WriteGoto(labelPrefix + "_END")
+	c.output.WriteLabel(labelPrefix + "_SKIP")
+	// The left operand: false for "&", true for "|"
+	c.output.WritePush(ConstVMSegment, 0)
+	if op == OrVMOperation {
+		c.output.WriteArithmetic(NotVMOperation)
+	}
+	c.output.WriteLabel(labelPrefix + "_END")
+}
+
+// compileOperand compiles the term an operator is applied to.
+func (c *JackCompiler) compileOperand(operator Token) {
+	if err := c.compileTerm(); err != nil {
+		message := fmt.Sprintf("missing operand of %q", operator.terminal)
+		var termErr *CompileError
+		if errors.As(err, &termErr) {
+			// Keep the position of the missing term
+			panic(&CompileError{Message: message + ": " + termErr.Message, Position: termErr.Position, End: termErr.End})
+		}
+		panic(fmt.Sprintf("%s: %v", message, err))
+	}
+}
+
+// checkBinaryOpTypes warns about boolean arithmetic operands and returns the
+// type of the operation's result.
+func (c *JackCompiler) checkBinaryOpTypes(operator string, lhsType string, rhsType string) string {
+	switch operator {
+	case "+", "-", "*", "/", "%":
+		if c.Options.WarnTypes && (lhsType == "boolean" || rhsType == "boolean") {
+			c.warn("arithmetic operator %q applied to boolean operand", operator)
+		}
+		return "int"
+	case "<", ">", "=":
+		return "boolean"
+	default:
+		if lhsType == rhsType {
+			return lhsType
+		}
+		return ""
+	}
+}
+
 /*
 * Expression list: (expression (, expression)*)?
  */
 func (c *JackCompiler) compileExpressionList() (i MachineWord) {
-	for c.compileExpression() == nil {
+	if IsSymbol(c.nextToken(), ")") {
+		return 0
+	}
+	for {
+		if err := c.compileExpression(); err != nil {
+			panic(err)
+		}
 		i += 1
 		if !IsTerminal(c.nextToken(), ",") {
-			break
+			return i
 		}
 		c.consume(",")
 	}
-	return i
 }
 
-func (c *JackCompiler) compileSubroutineCall(name string) {
+// recordClassCall remembers a call of function for checkClassCalls if it is
+// a subroutine of the compiled class.
+func (c *JackCompiler) recordClassCall(function string, asMethod bool, nameToken Token) {
+	if strings.HasPrefix(function, c.currentClassName+".") {
+		c.classCalls = append(c.classCalls, classCall{
+			function: function,
+			asMethod: asMethod,
+			caller:   c.currentSubroutine.name,
+			source:   tokenRange(nameToken),
+		})
+	}
+}
+
+// checkClassCalls warns about do statements discarding the result of a
+// subroutine of the compiled class that returns a value. With
+// Options.StrictCalls, calling a method without an object or a function or
+// constructor with one is an error.
+func (c *JackCompiler) checkClassCalls() {
+	for _, call := range c.classCalls {
+		subroutine, ok := c.subroutines[call.function]
+		if !ok {
+			continue
+		}
+		if call.discarded && subroutine.returnType != "void" {
+			c.warnAt(call.source, "do in %s.%s discards the %s returned by %s", c.currentClassName, call.caller, subroutine.returnType, call.function)
+		}
+		if !c.Options.StrictCalls {
+			continue
+		}
+		switch isMethod := subroutine.subroutineType == MethodSubroutineType; {
+		case isMethod && !call.asMethod:
+			c.fail(call.source, fmt.Sprintf("method %s called as a function in %s.%s", call.function, c.currentClassName, call.caller))
+		case !isMethod && call.asMethod:
+			c.fail(call.source, fmt.Sprintf("%s %s called as a method in %s.%s", subroutine.subroutineType, call.function, c.currentClassName, call.caller))
+		}
+	}
+}
+
+// checkExternalCall checks a call of function of another class, passing
+// nargs arguments including the object, against Options.Signatures.
+func (c *JackCompiler) checkExternalCall(function string, asMethod bool, nargs MachineWord, nameToken Token) {
+	className := function[:strings.Index(function, ".")]
+	if c.Options.Signatures == nil || className == c.currentClassName {
+		return
+	}
+	signature, ok := c.Options.Signatures[function]
+	if !ok {
+		if c.Options.Signatures.hasClass(className) {
+			c.fail(tokenRange(nameToken), fmt.Sprintf("class %s has no subroutine %s, called in %s.%s", className, function, c.currentClassName, c.currentSubroutine.name))
+		}
+		return
+	}
+
+	arity := int(nargs)
+	if asMethod {
+		arity -= 1
+	}
+	switch isMethod := signature.Kind == MethodSubroutineType; {
+	case isMethod && !asMethod:
+		c.fail(tokenRange(nameToken), fmt.Sprintf("method %s called as a function in %s.%s", function, c.currentClassName, c.currentSubroutine.name))
+	case !isMethod && asMethod:
+		c.fail(tokenRange(nameToken), fmt.Sprintf("%s %s called as a method in %s.%s", signature.Kind, function, c.currentClassName, c.currentSubroutine.name))
+	case arity != signature.Arity:
+		c.fail(tokenRange(nameToken), fmt.Sprintf("%s expects %d arguments but got %d in %s.%s", function, signature.Arity, arity, c.currentClassName, c.currentSubroutine.name))
+	}
+}
+
+// compileSubroutineCall compiles a call following its already consumed first
+// identifier nameToken.
+func (c *JackCompiler) compileSubroutineCall(nameToken Token) {
 	/**
 	* Examples:
 	*	- do Memory.init();
@@ -444,26 +1259,21 @@ func (c *JackCompiler) compileSubroutineCall(name string) {
 	*	- do square.dispose();
 	*		 ^ name ^ method name
 	 */
-
-	// Try to determine variable/function name if not given
-	if name == "" {
-		var err error
-		name, err = parseIdentifier(c.nextToken())
-		if err != nil {
-			panic(err)
-		}
-		c.advance()
-	}
+	name := nameToken.terminal
 
 	switch c.nextToken().terminal {
 	case ".":
 		c.consume(".")
-		methodName, err := parseIdentifier(c.nextToken())
+		methodNameToken := c.nextToken()
+		methodName, err := parseIdentifier(methodNameToken)
 		if err != nil {
 			panic(err)
 		}
 		// Advance over identifier
 		c.advance()
+		if !IsTerminal(c.nextToken(), "(") {
+			panic(memberAccessError(nameToken, methodNameToken))
+		}
 
 		nargs := MachineWord(0)
 		// Check if name is a symbol! If it is, push the object on the stack
@@ -472,28 +1282,33 @@ func (c *JackCompiler) compileSubroutineCall(name string) {
 
 			// Push the address of the object a method is called on onto the stack.
 			// This will be argument 0 (this pointer)
-			segment, index := c.generateVariableAccess(name)
-			c.output.WritePush(segment, index)
+			segment, index := c.generateVariableAccess(nameToken)
+			writeVariablePush(c.output, segment, index, name, c.Options.Annotate)
 
 			name = symbol.variableType + "." + methodName
 		} else {
 			// Name refers to some function. Needs to be fully qualified
 			name = name + "." + methodName
 		}
+		asMethod := nargs == 1
+		c.recordClassCall(name, asMethod, nameToken)
 
 		c.consume("(")
 		nargs += c.compileExpressionList()
 		c.consume(")")
+		c.checkExternalCall(name, asMethod, nargs, nameToken)
 
-		c.output.WriteCall(name, nargs)
+		c.writeCall(name, nargs)
 	case "(":
+		c.recordClassCall(c.currentClassName+"."+name, true, nameToken)
+		c.requireThis(nameToken, fmt.Sprintf("method call %s()", name))
 		// Push pointer of this object
 		c.output.WritePush(PointerVMSegment, 0)
 		// We call a local method. It is not allowed to call functions without prefixing the class name.
 		c.consume("(")
 		nargs := 1 + c.compileExpressionList()
 		c.consume(")")
-		c.output.WriteCall(c.currentClassName+"."+name, nargs)
+		c.writeCall(c.currentClassName+"."+name, nargs)
 	default:
 		panic("Expected terminal ( or ., but got " + c.nextToken().terminal)
 	}
@@ -508,11 +1323,13 @@ func (c *JackCompiler) compileVarNameSubterm() error {
 	}
 	c.advance()
 
+	c.termType = ""
 	switch c.nextToken().terminal {
 	case "[":
 		c.consume("[")
 
-		c.generateArrayElemPointer(varName)
+		c.generateArrayElemPointer(varNameToken)
+		c.termType = ""
 		// Address *varName + expr_result is now on top of stack
 		// Pop into pointer (THAT)
 		c.output.WritePop(PointerVMSegment, 1)
@@ -521,11 +1338,25 @@ func (c *JackCompiler) compileVarNameSubterm() error {
 
 		c.consume("]")
 	case "(", ".":
-		c.compileSubroutineCall(varName)
+		c.compileSubroutineCall(varNameToken)
+		c.termType = ""
+		if IsTerminal(c.nextToken(), "[") {
+			// Index the array returned by the call
+			c.consume("[")
+			if err := c.compileExpression(); err != nil {
+				panic(err)
+			}
+			c.termType = ""
+			writeIndexedElement(c.output, c.temps, c.Options.CheckBounds)
+			c.consume("]")
+		}
 	default:
 		// Direct access to varName
-		segment, index := c.generateVariableAccess(varName)
-		c.output.WritePush(segment, index)
+		segment, index := c.generateVariableAccess(varNameToken)
+		writeVariablePush(c.output, segment, index, varName, c.Options.Annotate)
+		if symbol, err := c.symbolTable.Lookup(varName); err == nil {
+			c.termType = symbol.variableType
+		}
 	}
 	return nil
 }
@@ -533,11 +1364,12 @@ func (c *JackCompiler) compileVarNameSubterm() error {
 /*
  * Term:
  * integerConstant | stringConstant | keywordConstant | varName | varName '[' expression ']' |
- * subroutineCall | '(' expression ')' | unaryOp term*
+ * subroutineCall | subroutineCall '[' expression ']' | '(' expression ')' | unaryOp term*
  */
 func (c *JackCompiler) compileTerm() error {
 	switch token := c.nextToken(); {
 	case IsTokenType(token, IntegerConstant):
+		c.termType = "int"
 		if constant, err := parseIntegerConstant(token); err == nil {
 			c.output.WritePush(ConstVMSegment, constant)
 			c.advance()
@@ -545,12 +1377,30 @@ func (c *JackCompiler) compileTerm() error {
 			panic(err)
 		}
 		return nil
+	case IsTokenType(token, CharConstant):
+		c.termType = "char"
+		constant, err := parseCharConstant(token)
+		if err != nil {
+			panic(err)
+		}
+		c.output.WritePush(ConstVMSegment, constant)
+		c.advance()
+		return nil
 	case IsTokenType(token, StringConstant):
-		c.output.WriteStringConstant(token.terminal)
+		c.termType = "String"
+		constant, err := parseStringConstant(token)
+		if err != nil {
+			panic(err)
+		}
+		c.output.WriteStringConstant(constant)
 		// Consume string constant
 		c.advance()
 		return nil
 	case IsTokenType(token, Keyword):
+		c.termType = ""
+		if IsTerminal(token, "true", "false") {
+			c.termType = "boolean"
+		}
 		switch {
 		case IsTerminal(token, "true"):
 			c.output.WritePush(ConstVMSegment, 0)
@@ -560,6 +1410,8 @@ func (c *JackCompiler) compileTerm() error {
 		case IsTerminal(token, "null"):
 			c.output.WritePush(ConstVMSegment, 0)
 		case IsTerminal(token, "this"):
+			c.requireThis(token, "this")
+			c.termType = c.currentClassName
 			// Push "this" pointer onto stack
 			c.output.WritePush(PointerVMSegment, 0)
 		default:
@@ -569,24 +1421,37 @@ func (c *JackCompiler) compileTerm() error {
 		return nil
 	case IsTerminal(token, "("):
 		c.consume("(")
-		c.compileExpression()
+		if err := c.compileExpression(); err != nil {
+			panic(err)
+		}
 		c.consume(")")
 		return nil
 	case isUnaryOp(token):
 		op := parseUnaryOp(token)
 		c.advance()
-		c.compileTerm()
+		if next := c.nextToken(); op == NegVMOperation && next.isMinWordMagnitude() {
+			// 32768 is not a valid constant, use ~32767 instead
+			c.termType = "int"
+			c.output.WritePush(ConstVMSegment, MaxMachineWord)
+			c.output.WriteArithmetic(NotVMOperation)
+			c.advance()
+			return nil
+		}
+		c.compileOperand(token)
+		if op == NegVMOperation {
+			c.termType = "int"
+		}
 		c.output.WriteArithmetic(op)
 		return nil
-	default:
+	case IsTokenType(token, Identifier):
 		return c.compileVarNameSubterm()
+	default:
+		return expectedTermError(token)
 	}
-
-	panic(fmt.Errorf("unexpected token: %q", c.nextToken()))
 }
 
 func isBinaryOp(token Token) bool {
-	for _, term := range []string{"+", "-", "*", "/", "&", "|", "<", ">", "="} {
+	for _, term := range []string{"+", "-", "*", "/", "%", "&", "|", "<", ">", "="} {
 		if IsTerminal(token, term) {
 			return true
 		}
@@ -594,6 +1459,10 @@ func isBinaryOp(token Token) bool {
 	return false
 }
 
+func isComparison(op VMOperation) bool {
+	return op == LtVMOperation || op == GtVMOperation || op == EqVMOperation
+}
+
 func isUnaryOp(token Token) bool {
 	for _, term := range []string{"-", "~"} {
 		if IsTerminal(token, term) {
@@ -623,10 +1492,12 @@ func parseBinaryOp(token Token) VMOperation {
 		return MulVMOperation
 	case "/":
 		return DivVMOperation
+	case "%":
+		return ModVMOperation
 	case "&":
 		return AndVMOperation
 	case "|":
-		return OrvMOperation
+		return OrVMOperation
 	case "<":
 		return LtVMOperation
 	case ">":
@@ -644,7 +1515,46 @@ func parseType(token Token) (string, error) {
 	return parseIdentifier(token)
 }
 
+func parseReturnType(token Token) (string, error) {
+	if IsTerminal(token, "void") {
+		return token.terminal, nil
+	}
+	if returnType, err := parseType(token); err == nil {
+		return returnType, nil
+	}
+	return token.terminal, fmt.Errorf("invalid return type %q", token.terminal)
+}
+
+// expectedTermError reports token found where a term has to start.
+func expectedTermError(token Token) *CompileError {
+	return tokenError(token, fmt.Sprintf("expected term, got %q", token.terminal))
+}
+
+// letTargetError reports token, which is no identifier, used as the target of
+// a let statement, e.g. "let 5 = 1;".
+func letTargetError(token Token) *CompileError {
+	return tokenError(token, fmt.Sprintf("cannot assign to %q, let needs a variable", token.terminal))
+}
+
+// undeclaredTargetMessage reports assigning to the undeclared variable name,
+// or an element of it if indexed.
+func undeclaredTargetMessage(name string, indexed bool) string {
+	if indexed {
+		return fmt.Sprintf("cannot assign to an element of undeclared array %s", name)
+	}
+	return fmt.Sprintf("cannot assign to undeclared variable %s", name)
+}
+
+// memberAccessError reports "receiver.member" used other than as a call.
+// Jack only allows to access the variables of the own class and object.
+func memberAccessError(receiver Token, member Token) *CompileError {
+	return tokenError(receiver, fmt.Sprintf("%s.%s is not a subroutine call, variables of other classes or objects can not be accessed", receiver.terminal, member.terminal))
+}
+
 func parseIdentifier(token Token) (string, error) {
+	if token.tokenType == Keyword {
+		return token.terminal, tokenError(token, fmt.Sprintf("'%s' is a reserved keyword and cannot be used as a name", token.terminal))
+	}
 	if token.tokenType != Identifier {
 		return token.terminal, fmt.Errorf("invalid identifier %q", token.terminal)
 	}
@@ -659,13 +1569,45 @@ func parseIntegerConstant(token Token) (MachineWord, error) {
 	if token.tokenType != IntegerConstant {
 		return 0, fmt.Errorf("invalid integer constant %q", token.terminal)
 	}
-	return token.asInt(), nil
+	return token.asInt()
 }
 
+// parseCharConstant returns the code of a character literal in the Hack
+// character set. Supports the escapes \n (newline, 128), \b (backspace,
+// 129), \\, \' and \".
+func parseCharConstant(token Token) (MachineWord, error) {
+	if token.tokenType != CharConstant {
+		return 0, fmt.Errorf("invalid character constant %q", token.terminal)
+	}
+	literal := token.terminal
+	if len(literal) == 2 && literal[0] == '\\' {
+		switch literal[1] {
+		case 'n':
+			return 128, nil
+		case 'b':
+			return 129, nil
+		case '\\', '\'', '"':
+			return toWord(int(literal[1])), nil
+		}
+		return 0, fmt.Errorf("unknown escape sequence in character constant '%s'", literal)
+	}
+	if len(literal) != 1 || literal[0] < ' ' || literal[0] > '~' {
+		return 0, fmt.Errorf("character constant '%s' is not a printable ASCII character", literal)
+	}
+	return toWord(int(literal[0])), nil
+}
+
+// parseStringConstant returns the contents of a string literal. Characters
+// are emitted by their Unicode code point, which has to fit a MachineWord.
 func parseStringConstant(token Token) (string, error) {
 	if token.tokenType != StringConstant {
 		return "", fmt.Errorf("invalid string constant %q", token.terminal)
 	}
+	for _, char := range token.terminal {
+		if char == utf8.RuneError || char > math.MaxInt16 {
+			return "", fmt.Errorf("string constant \"%s\" contains unsupported character %q", token.terminal, char)
+		}
+	}
 	return token.terminal, nil
 }
 
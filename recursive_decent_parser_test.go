@@ -0,0 +1,1341 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCompileStatementsWithSliceScanner(t *testing.T) {
+	// let x = 1; return; }
+	tokens := []Token{
+		NewToken(Keyword, "let", Position{Line: 1, Column: 1}),
+		NewToken(Identifier, "x", Position{Line: 1, Column: 5}),
+		NewToken(SymbolTokenType, "=", Position{Line: 1, Column: 7}),
+		NewToken(IntegerConstant, "1", Position{Line: 1, Column: 9}),
+		NewToken(SymbolTokenType, ";", Position{Line: 1, Column: 10}),
+		NewToken(Keyword, "return", Position{Line: 2, Column: 1}),
+		NewToken(SymbolTokenType, ";", Position{Line: 2, Column: 7}),
+		NewToken(SymbolTokenType, "}", Position{Line: 3, Column: 1}),
+	}
+	writer := &RecordingWriter{}
+	compiler := newStatementCompiler(tokens, writer)
+	compiler.symbolTable.Declare(Symbol{symbolType: VarSymbol, variableType: "int"}, "x", FunctionScope)
+
+	if returns := compiler.compileStatements(); !returns {
+		t.Errorf("compileStatements() = false, want true for statements ending in return")
+	}
+
+	want := []RecordedCall{
+		{"WritePush", []any{ConstVMSegment, MachineWord(1)}},
+		{"WritePop", []any{LocalVMSegment, MachineWord(0)}},
+		{"WritePush", []any{ConstVMSegment, MachineWord(0)}},
+		{"WriteReturn", nil},
+	}
+	if !reflect.DeepEqual(writer.Calls, want) {
+		t.Errorf("recorded calls %v, want %v", writer.Calls, want)
+	}
+}
+
+func ExampleRecordingWriter() {
+	// do Output.printInt(7); }
+	tokens := []Token{
+		NewToken(Keyword, "do", Position{Line: 1, Column: 1}),
+		NewToken(Identifier, "Output", Position{Line: 1, Column: 4}),
+		NewToken(SymbolTokenType, ".", Position{Line: 1, Column: 10}),
+		NewToken(Identifier, "printInt", Position{Line: 1, Column: 11}),
+		NewToken(SymbolTokenType, "(", Position{Line: 1, Column: 19}),
+		NewToken(IntegerConstant, "7", Position{Line: 1, Column: 20}),
+		NewToken(SymbolTokenType, ")", Position{Line: 1, Column: 21}),
+		NewToken(SymbolTokenType, ";", Position{Line: 1, Column: 22}),
+		NewToken(SymbolTokenType, "}", Position{Line: 2, Column: 1}),
+	}
+	writer := &RecordingWriter{}
+	newStatementCompiler(tokens, writer).compileStatements()
+
+	for _, call := range writer.Calls {
+		fmt.Println(append([]any{call.Method}, call.Args...)...)
+	}
+	// Output:
+	// WritePush constant 7
+	// WriteCall Output.printInt 1
+	// WritePop temp 0
+}
+
+func TestErrorFormatIndependentOfRecovery(t *testing.T) {
+	source := `class Main {
+    function void main() {
+        var int x;
+        let x = ;
+        return;
+    }
+}`
+	want := `Main.jack: expected term, got ";" at line 4, col 17`
+	for _, recover := range []bool{false, true} {
+		_, _, err := compileSource("Main.jack", source, CompilerOptions{RecoverErrors: recover})
+		if err == nil || err.Error() != want {
+			t.Errorf("RecoverErrors %v: error %v, want %q", recover, err, want)
+		}
+	}
+}
+
+func TestRecoveredReturnErrorDoesNotWarnMissingReturn(t *testing.T) {
+	source := `class Main {
+    function int f() {
+        return;
+    }
+}`
+	_, warnings, err := compileSource("Main.jack", source, CompilerOptions{RecoverErrors: true})
+	errs, ok := err.(CompileErrors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("error %v, want a single CompileError", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("warnings %q, want none", warnings)
+	}
+}
+
+func TestWarnTypesBooleanOperands(t *testing.T) {
+	source := `class Main {
+    function void main() {
+        var boolean b;
+        var int x;
+        var Array a;
+        let x = b + 1;
+        let x = a[b];
+        let x = x + a[x];
+        return;
+    }
+}`
+	want := []string{
+		`Main.jack: arithmetic operator "+" applied to boolean operand`,
+		`Main.jack: boolean used as index of array "a"`,
+	}
+	_, warnings, err := compileSource("Main.jack", source, CompilerOptions{WarnTypes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings %q, want %q", warnings, want)
+	}
+	if _, warnings, _ := compileSource("Main.jack", source, CompilerOptions{}); len(warnings) != 0 {
+		t.Errorf("warnings %q without WarnTypes, want none", warnings)
+	}
+}
+
+func TestMissingReturnWarning(t *testing.T) {
+	tests := []struct {
+		source string
+		want   []string
+	}{
+		{`class Main { function int f(int x) { if (x) { return 1; } } }`, []string{"Main.jack: subroutine Main.f of type int may end without returning a value"}},
+		{`class Main { function int f(int x) { if (x) { return 1; } else { return 2; } } }`, nil},
+		{`class Main { function int f(int x) { while (x) { return 1; } return 0; } }`, nil},
+		{`class Main { function void f() { } }`, nil},
+	}
+	for _, test := range tests {
+		_, warnings, err := compileSource("Main.jack", test.source, CompilerOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(warnings, test.want) {
+			t.Errorf("%s: warnings %q, want %q", test.source, warnings, test.want)
+		}
+	}
+}
+
+func TestReturnStatementsCheckedAgainstReturnType(t *testing.T) {
+	source := `class Main {
+    method Main self() {
+        return;
+    }
+    method void nothing() {
+        return this;
+    }
+    function char letter() {
+        return 65;
+    }
+    constructor Main new() {
+        return this;
+    }
+}`
+	_, _, err := compileSource("Main.jack", source, CompilerOptions{RecoverErrors: true})
+	errs, ok := err.(CompileErrors)
+	if !ok {
+		t.Fatalf("error %v, want CompileErrors", err)
+	}
+	want := []string{
+		"Main.jack: subroutine Main.self of type Main must return a value at line 3, col 9",
+		"Main.jack: void subroutine Main.nothing must not return a value at line 6, col 9",
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("errors %v, want %q", errs, want)
+	}
+	for i, err := range errs {
+		if err.Error() != want[i] {
+			t.Errorf("error %d %q, want %q", i, err, want[i])
+		}
+	}
+}
+
+func TestDeadCodeAfterReturn(t *testing.T) {
+	source := `class Main {
+    function int f() {
+        return 1;
+        return 2;
+    }
+}`
+	wantWarnings := []string{"Main.jack: unreachable code after return in Main.f"}
+	for _, drop := range []bool{false, true} {
+		vm, warnings, err := compileSource("Main.jack", source, CompilerOptions{DropDeadCode: drop})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(warnings, wantWarnings) {
+			t.Errorf("DropDeadCode %v: warnings %q, want %q", drop, warnings, wantWarnings)
+		}
+		want := "function Main.f 0\npush constant 1\nreturn\n"
+		if !drop {
+			want += "push constant 2\nreturn\n"
+		}
+		if vm != want {
+			t.Errorf("DropDeadCode %v: VM code\n%s\nwant\n%s", drop, vm, want)
+		}
+	}
+}
+
+func TestDiagnosticsNameTheSource(t *testing.T) {
+	source := `class Main {
+    function int f() {
+        return 1;
+        return x;
+    }
+}`
+	_, warnings, err := compileSource("src/Main.jack", source, CompilerOptions{})
+	if len(warnings) != 1 || !strings.HasPrefix(warnings[0], "src/Main.jack: ") {
+		t.Errorf("warnings %q, want one prefixed by the file name", warnings)
+	}
+	if err == nil || !strings.HasPrefix(err.Error(), "src/Main.jack: ") {
+		t.Errorf("error %v, want one prefixed by the file name", err)
+	}
+
+	// Without a file name the class is named
+	tokenizer := NewTokenizer(strings.NewReader(source))
+	compiler := NewJackCompiler(&tokenizer, NullWriter{})
+	if err := compiler.Compile(); err == nil || !strings.HasPrefix(err.Error(), "class Main: ") {
+		t.Errorf("error %v, want one prefixed by the class name", err)
+	}
+}
+
+func TestClassNameMustMatchFileName(t *testing.T) {
+	source := "class Other {\n}"
+	_, warnings, err := compileSource("src/Main.jack", source, CompilerOptions{})
+	if want := []string{"src/Main.jack: class Other should be declared in Other.jack"}; err != nil || !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings %q, error %v, want warnings %q", warnings, err, want)
+	}
+
+	report := &DiagnosticReport{}
+	_, _, err = compileSource("src/Main.jack", source, CompilerOptions{StrictClassNames: true, DiagnosticReport: report})
+	if want := "src/Main.jack: class Other must be declared in Other.jack at line 1, col 7"; err == nil || err.Error() != want {
+		t.Errorf("error %v with StrictClassNames, want %q", err, want)
+	}
+	if len(report.Diagnostics) != 1 || report.Diagnostics[0].Range != (Range{Start: Position{Line: 1, Column: 7}, End: Position{Line: 1, Column: 12}}) {
+		t.Errorf("diagnostics %+v, want one spanning the class name", report.Diagnostics)
+	}
+
+	if _, warnings, err := compileSource("src/Other.jack", source, CompilerOptions{StrictClassNames: true}); err != nil || len(warnings) != 0 {
+		t.Errorf("warnings %q, error %v for a matching file name, want none", warnings, err)
+	}
+}
+
+func TestUnaryAndBinaryMinus(t *testing.T) {
+	source := "class Main { function int f(int x) { return -x - -1 - (x - 2); } }"
+	vm, _, err := compileSource("Main.jack", source, CompilerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `function Main.f 0
+push argument 0
+neg
+push constant 1
+neg
+sub
+push argument 0
+push constant 2
+sub
+sub
+return
+`
+	if vm != want {
+		t.Errorf("VM code\n%s\nwant\n%s", vm, want)
+	}
+}
+
+func TestMissingOperand(t *testing.T) {
+	sources := map[string]string{
+		"class Main { function int f(int x) { return x + ; } }": `Main.jack: missing operand of "+": expected term, got ";" at line 1, col 49`,
+		"class Main { function int f(int x) { return -; } }":    `Main.jack: missing operand of "-": expected term, got ";" at line 1, col 46`,
+	}
+	for source, want := range sources {
+		if _, _, err := compileSource("Main.jack", source, CompilerOptions{}); err == nil || err.Error() != want {
+			t.Errorf("%s: error %v, want %q", source, err, want)
+		}
+	}
+}
+
+func TestRelaxedVarDecs(t *testing.T) {
+	source := `class Main {
+    function int f() {
+        var int x;
+        let x = 1;
+        var int y;
+        let y = x;
+        return y;
+    }
+}`
+	vm, _, err := compileSource("Main.jack", source, CompilerOptions{RelaxedVarDecs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// All locals are counted, wherever they are declared
+	want := "function Main.f 2\npush constant 1\npop local 0\npush local 0\npop local 1\npush local 1\nreturn\n"
+	if vm != want {
+		t.Errorf("VM code\n%s\nwant\n%s", vm, want)
+	}
+
+	if _, _, err := compileSource("Main.jack", source, CompilerOptions{}); err == nil || !strings.Contains(err.Error(), "unexpected token var at line 5, col 9") {
+		t.Errorf("error %v without RelaxedVarDecs, want an unexpected var", err)
+	}
+}
+
+func TestDuplicateDeclarations(t *testing.T) {
+	source := `class Main {
+    field int x;
+    static int x;
+    function void f(int a, int a) {
+        var int a;
+        return;
+    }
+    function void g(int a) {
+        var int x;
+        return;
+    }
+}`
+	_, _, err := compileSource("Main.jack", source, CompilerOptions{RecoverErrors: true})
+	errs, ok := err.(CompileErrors)
+	if !ok || len(errs) != 3 {
+		t.Fatalf("error %v, want 3 CompileErrors", err)
+	}
+	// Redeclaring a class symbol or a name of another subroutine is allowed
+	for i, name := range []string{`"x"`, `"a"`, `"a"`} {
+		if message := errs[i].Error(); !strings.Contains(message, "symbol "+name) || !strings.Contains(message, "already declared") {
+			t.Errorf("error %d %q, want a redeclaration of %s", i, message, name)
+		}
+	}
+}
+
+func TestEmptyClassesAndSubroutines(t *testing.T) {
+	vm, _, err := compileSource("Main.jack", `class Main {}`, CompilerOptions{})
+	if err != nil || vm != "" {
+		t.Errorf("empty class compiled to %q, %v, want no code", vm, err)
+	}
+
+	vm, _, err = compileSource("Main.jack", `class Main { method void f() { } function void g() {} }`, CompilerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "function Main.f 0\npush argument 0\npop pointer 0\npush constant 0\nreturn\n" +
+		"function Main.g 0\npush constant 0\nreturn\n"
+	if vm != want {
+		t.Errorf("empty subroutines compiled to %q, want %q", vm, want)
+	}
+}
+
+func TestChainedComparison(t *testing.T) {
+	source := `class Main { function boolean f(int a, int b, int c) { return a < b < c; } }`
+	vm, warnings, err := compileSource("Main.jack", source, CompilerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Evaluated left to right as (a < b) < c
+	want := "function Main.f 0\npush argument 0\npush argument 1\nlt\npush argument 2\nlt\nreturn\n"
+	if vm != want {
+		t.Errorf("compiled to %q, want %q", vm, want)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("warnings %q without -warn-chained-compare, want none", warnings)
+	}
+
+	_, warnings, _ = compileSource("Main.jack", source, CompilerOptions{WarnChainedCompare: true})
+	if len(warnings) != 1 || !strings.Contains(warnings[0], `chained comparison "< ... <" in Main.f`) {
+		t.Errorf("warnings %q, want a chained comparison", warnings)
+	}
+
+	_, warnings, _ = compileSource("Main.jack", `class Main { function boolean f(int a, int b, int c) { return a + b < c; } }`, CompilerOptions{WarnChainedCompare: true})
+	if len(warnings) != 0 {
+		t.Errorf("warnings %q for a single comparison, want none", warnings)
+	}
+}
+
+func TestRedeclarationNamesBothPositions(t *testing.T) {
+	source := `class Main {
+  function void f(int a) {
+    var int x;
+    var boolean x, a;
+    return;
+  }
+}`
+	want := []string{
+		`Main.jack: symbol "x" already declared at line 3, col 13, redeclared in subroutine Main.f at line 4, col 17`,
+		`Main.jack: symbol "a" already declared at line 2, col 23, redeclared in subroutine Main.f at line 4, col 20`,
+	}
+	_, _, err := compileSource("Main.jack", source, CompilerOptions{RecoverErrors: true})
+	if err == nil || err.Error() != strings.Join(want, "\n") {
+		t.Errorf("recovered errors %v, want %q", err, want)
+	}
+	_, _, err = compileSource("Main.jack", source, CompilerOptions{})
+	if err == nil || err.Error() != want[0] {
+		t.Errorf("error %v, want %q", err, want[0])
+	}
+}
+
+func TestRecoverAtStatementBoundaries(t *testing.T) {
+	source := `class Main {
+  function void f() {
+    var int x;
+    let x = ;
+    let x = 2;
+    do Output.printInt(x;
+    return;
+  }
+}`
+	_, _, err := compileSource("Main.jack", source, CompilerOptions{})
+	if _, ok := err.(CompileErrors); ok || err == nil {
+		t.Errorf("error %v without recovery, want only the first", err)
+	}
+
+	_, _, err = compileSource("Main.jack", source, CompilerOptions{RecoverErrors: true})
+	errs, ok := err.(CompileErrors)
+	if !ok {
+		t.Fatalf("error %v, want CompileErrors", err)
+	}
+	want := []string{
+		`Main.jack: expected term, got ";" at line 4, col 13`,
+		`Main.jack: Expected terminal ")", got ";" at line 6, col 25`,
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("errors %v, want %q", errs, want)
+	}
+	for i, err := range errs {
+		if err.Error() != want[i] {
+			t.Errorf("error %d is %q, want %q", i, err, want[i])
+		}
+	}
+}
+
+func TestCharacterConstants(t *testing.T) {
+	codes := map[string]int{`'A'`: 65, `' '`: 32, `'\n'`: 128, `'\b'`: 129, `'\''`: 39, `'\\'`: 92, `'"'`: 34}
+	for literal, code := range codes {
+		source := "class Main { function void main() { var char c; let c = " + literal + "; return; } }"
+		want := fmt.Sprintf("push constant %d\npop local 0\n", code)
+		vm, _, err := compileSource("Main.jack", source, CompilerOptions{})
+		if err != nil || !strings.Contains(vm, want) {
+			t.Errorf("%s compiled to %q, %v, want %q", literal, vm, err, want)
+		}
+		if vm, err = generateSource(source); err != nil || !strings.Contains(vm, want) {
+			t.Errorf("%s generated %q, %v, want %q", literal, vm, err, want)
+		}
+	}
+
+	errors := map[string]string{
+		`'\q'`: `unknown escape sequence in character constant '\q' at line 1, col 41`,
+		`'ab'`: `invalid character constant 'ab' at line 1, col 41`,
+		`'é'`:  `character constant 'é' is not a printable ASCII character at line 1, col 41`,
+		`'a`:   `unterminated character constant at line 1, col 41`,
+	}
+	for literal, want := range errors {
+		_, _, err := compileSource("Main.jack", "class Main { function char f() { return "+literal+"; } }", CompilerOptions{})
+		if err == nil || err.Error() != "Main.jack: "+want {
+			t.Errorf("%s error %v, want %q", literal, err, want)
+		}
+	}
+}
+
+func TestMinimumIntegerConstant(t *testing.T) {
+	source := `class Main { function int f() { return -32768; } }`
+	want := "function Main.f 0\npush constant 32767\nnot\nreturn\n"
+	for _, options := range []CompilerOptions{{}, {FoldConstants: true}} {
+		vm, _, err := compileSource("Main.jack", source, options)
+		if err != nil || vm != want {
+			t.Errorf("%+v: compiled to %q, %v, want %q", options, vm, err, want)
+		}
+	}
+	if vm, err := generateSource(source); err != nil || vm != want {
+		t.Errorf("generated %q, %v, want %q", vm, err, want)
+	}
+
+	// 32768 is only allowed as operand of a unary minus
+	for _, expression := range []string{"32768", "-(32768)", "1 - 32768"} {
+		_, _, err := compileSource("Main.jack", "class Main { function int f() { return "+expression+"; } }", CompilerOptions{})
+		if err == nil || !strings.Contains(err.Error(), `cannot parse "32768" as 16 bit int`) {
+			t.Errorf("%s: error %v, want 32768 out of range", expression, err)
+		}
+	}
+}
+
+func TestDoDiscardingValueWarning(t *testing.T) {
+	source := `class Main {
+  function int foo() { return 1; }
+  function void v() { return; }
+  method int m() { return 2; }
+  method void main() {
+    do foo();
+    do v();
+    do m();
+    do later();
+    do Output.printInt(1);
+    do foo()[0];
+    return;
+  }
+  function Array later() { return null; }
+}`
+	_, warnings, err := compileSource("Main.jack", source, CompilerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"Main.jack: do in Main.main discards the int returned by Main.foo",
+		"Main.jack: do in Main.main discards the int returned by Main.m",
+		"Main.jack: do in Main.main discards the Array returned by Main.later",
+	}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings %q, want %q", warnings, want)
+	}
+
+	var starts []Position
+	for _, diagnostic := range Diagnostics(strings.NewReader(source), "Main.jack") {
+		if strings.Contains(diagnostic.Message, "discards") {
+			starts = append(starts, diagnostic.Range.Start)
+		}
+	}
+	if want := []Position{{Line: 6, Column: 8}, {Line: 8, Column: 8}, {Line: 9, Column: 8}}; !reflect.DeepEqual(starts, want) {
+		t.Errorf("warnings at %v, want %v", starts, want)
+	}
+}
+
+func TestCompileOnlyOneSubroutine(t *testing.T) {
+	source := `class Main {
+  field int a, b;
+  static int s;
+  method int first(int p) { var int l; let l = p; return l + a; }
+  method int second(int q) { var int m, n; let n = q + b + s; return n; }
+}`
+	want := "function Main.second 2\npush argument 0\npop pointer 0\npush argument 1\npush this 1\nadd\npush static 0\nadd\npop local 1\npush local 1\nreturn\n"
+	for _, options := range []CompilerOptions{{Only: "Main.second"}, {Only: "Main.second", FoldConstants: true}} {
+		vm, _, err := compileSource("Main.jack", source, options)
+		if err != nil || vm != want {
+			t.Errorf("%+v: compiled to %q, %v, want %q", options, vm, err, want)
+		}
+	}
+
+	_, _, err := compileSource("Main.jack", source, CompilerOptions{Only: "Main.third"})
+	if err == nil || !strings.Contains(err.Error(), "no subroutine Main.third") {
+		t.Errorf("error %v, want no subroutine Main.third", err)
+	}
+}
+
+func TestStrictCalls(t *testing.T) {
+	tests := []struct {
+		call string
+		want string
+	}{
+		{"do Main.m();", "method Main.m called as a function in Main.main"},
+		{"do obj.f();", "function Main.f called as a method in Main.main"},
+		{"do f();", "function Main.f called as a method in Main.main"},
+		{"do obj.new();", "constructor Main.new called as a method in Main.main"},
+		{"do m();", ""},
+		{"do obj.m();", ""},
+		{"do Main.f();", ""},
+		{"do Main.new();", ""},
+		{"do Other.g();", ""},
+	}
+	for _, test := range tests {
+		source := `class Main {
+  constructor Main new() { return this; }
+  method void m() { return; }
+  function void f() { return; }
+  method void main() { var Main obj; ` + test.call + ` return; }
+}`
+		if _, _, err := compileSource("Main.jack", source, CompilerOptions{}); err != nil {
+			t.Errorf("%s: error %v without StrictCalls", test.call, err)
+		}
+		_, _, err := compileSource("Main.jack", source, CompilerOptions{StrictCalls: true})
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("%s: error %v, want none", test.call, err)
+		case test.want != "" && (err == nil || err.Error() != "Main.jack: "+test.want+" at line 5, col 41"):
+			t.Errorf("%s: error %v, want %s at line 5, col 41", test.call, err, test.want)
+		}
+	}
+}
+
+func TestUTF8StringsAndIdentifiers(t *testing.T) {
+	vm, _, err := compileSource("Main.jack", `class Main { function String f() { return "aé€"; } }`, CompilerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var codes []string
+	lines := strings.Split(vm, "\n")
+	for i, line := range lines {
+		if line == "call String.appendChar 2" {
+			codes = append(codes, lines[i-1])
+		}
+	}
+	want := []string{"push constant 97", "push constant 233", "push constant 8364"}
+	if !strings.Contains(vm, "push constant 3\ncall String.new 1\n") || !reflect.DeepEqual(codes, want) {
+		t.Errorf("VM code\n%s\nwant a string of length 3 appending %q", vm, want)
+	}
+
+	errors := map[string]string{
+		`class Main { function String f() { return "a😀"; } }`:         `string constant "a😀" contains unsupported character '😀' at line 1, col 43`,
+		"class Main { function String f() { return \"a\xffb\"; } }":   `string constant "a` + "�" + `b" contains unsupported character '` + "�" + `' at line 1, col 43`,
+		`class Main { function int f() { var int café; return 0; } }`: `identifiers are limited to ASCII letters, digits and '_', got 'é' at line 1, col 44`,
+	}
+	for source, want := range errors {
+		if _, _, err := compileSource("Main.jack", source, CompilerOptions{}); err == nil || err.Error() != "Main.jack: "+want {
+			t.Errorf("error %v, want %q", err, want)
+		}
+	}
+}
+
+// labelSource uses every statement generating labels, in two subroutines.
+const labelSource = `class Main {
+  function int f(int x) {
+    while (x > 0) {
+      if (x = 3) { return 3; } else { let x = x - 1; }
+    }
+    if ((x > 1) & (x < 5)) { let x = 1; }
+    return x;
+  }
+  method void g() { while (true) { } return; }
+}`
+
+// labels returns the labels defined and the labels jumped to by vm.
+func labels(vm string) (defined []string, targets []string) {
+	for _, line := range strings.Split(vm, "\n") {
+		command, label, _ := strings.Cut(line, " ")
+		switch command {
+		case "label":
+			defined = append(defined, label)
+		case "goto", "if-goto":
+			targets = append(targets, label)
+		}
+	}
+	return defined, targets
+}
+
+func TestLabelsArePortable(t *testing.T) {
+	portable := regexp.MustCompile(`^[A-Za-z0-9_.$]+$`)
+	for _, options := range []CompilerOptions{{}, {ShortCircuit: true}} {
+		vm, _, err := compileSource("Main.jack", labelSource, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defined, targets := labels(vm)
+		if len(defined) == 0 {
+			t.Fatalf("%+v: no labels in\n%s", options, vm)
+		}
+		for _, label := range defined {
+			if !portable.MatchString(label) {
+				t.Errorf("%+v: label %q is not portable", options, label)
+			}
+		}
+		definedSet := make(map[string]bool)
+		for _, label := range defined {
+			definedSet[label] = true
+		}
+		for _, target := range targets {
+			if !definedSet[target] {
+				t.Errorf("%+v: jump to undefined label %q", options, target)
+			}
+		}
+	}
+}
+
+func TestLabelsNamespacedBySubroutine(t *testing.T) {
+	compiled, _, err := compileSource("Main.jack", labelSource, CompilerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, err := generateSource(labelSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, vm := range []string{compiled, generated} {
+		defined, _ := labels(vm)
+		seen := make(map[string]bool)
+		for _, label := range defined {
+			if seen[label] {
+				t.Errorf("label %q defined twice", label)
+			}
+			seen[label] = true
+		}
+		want := []string{
+			"Main.f$WHILE0_BEGIN", "Main.f$IF1_ELSE", "Main.f$IF1_END", "Main.f$WHILE0_EXIT", "Main.f$IF2_ELSE",
+			"Main.g$WHILE3_BEGIN", "Main.g$WHILE3_EXIT",
+		}
+		if !reflect.DeepEqual(defined, want) {
+			t.Errorf("labels %q, want %q", defined, want)
+		}
+
+		results := map[int16]int16{5: 3, 3: 3, 2: 0, 0: 0}
+		for x, want := range results {
+			if got := runVM(t, vm, "Main.f", x); got != want {
+				t.Errorf("Main.f(%d) = %d, want %d", x, got, want)
+			}
+		}
+	}
+}
+
+func TestShortCircuit(t *testing.T) {
+	source := `class Main {
+  function boolean f(boolean a, Array b) { return a & (b[0] = 1); }
+  function boolean g(boolean a, boolean b) { return a | b; }
+  function int h(int a, int b) { return a & b; }
+}`
+	vm, _, err := compileSource("Main.jack", source, CompilerOptions{ShortCircuit: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The array access is only evaluated if a is true
+	guarded := `function Main.f 0
+push argument 0
+not
+if-goto Main.f$SHORT0_SKIP
+push constant 0
+push argument 1
+add
+pop pointer 1
+push that 0
+push constant 1
+eq
+goto Main.f$SHORT0_END
+label Main.f$SHORT0_SKIP
+push constant 0
+label Main.f$SHORT0_END
+return
+`
+	if !strings.HasPrefix(vm, guarded) {
+		t.Errorf("VM code\n%s\nwant it to start with\n%s", vm, guarded)
+	}
+	// Integer operands are combined bitwise as before
+	if !strings.HasSuffix(vm, "function Main.h 0\npush argument 0\npush argument 1\nand\nreturn\n") {
+		t.Errorf("VM code\n%s\nwant Main.h unchanged", vm)
+	}
+
+	for _, a := range []int16{0, -1} {
+		for _, b := range []int16{0, -1} {
+			if got := runVM(t, vm, "Main.g", a, b); got != a|b {
+				t.Errorf("Main.g(%d, %d) = %d, want %d", a, b, got, a|b)
+			}
+		}
+	}
+	if got := runVM(t, vm, "Main.f", 0, 0); got != 0 {
+		t.Errorf("Main.f(false, null) = %d, want false", got)
+	}
+}
+
+func TestCheckBounds(t *testing.T) {
+	source := `class Main { function int f(Array a, int i) { let a[i] = a[i + 1]; return a[0]; } }`
+	for name, options := range map[string]CompilerOptions{"compiled": {}, "folded": {FoldConstants: true}} {
+		vm, _, err := compileSource("Main.jack", source, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(vm, boundsCheckFunction) {
+			t.Errorf("%s: %s called without CheckBounds:\n%s", name, boundsCheckFunction, vm)
+		}
+
+		options.CheckBounds = true
+		vm, _, err = compileSource("Main.jack", source, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Count(vm, "push argument 0\ncall Array.checkBounds 2\npush argument 0\nadd\n"); got != 3 {
+			t.Errorf("%s: %d checked accesses, want 3:\n%s", name, got, vm)
+		}
+
+		// A check logging the indices from address 3000 on, counted in
+		// static 0
+		check := `function Array.checkBounds 0
+push constant 3000
+push static 0
+add
+pop pointer 1
+push argument 0
+pop that 0
+push static 0
+push constant 1
+add
+pop static 0
+push argument 0
+return
+`
+		machine := newVMMachine(vm + check)
+		machine.steps = 1000
+		if _, err := machine.call("Main.f", 2048, 1); err != nil {
+			t.Fatal(err)
+		}
+		// The index assigned to is evaluated first
+		if indices, want := machine.memory[3000:3003], []int16{1, 2, 0}; !reflect.DeepEqual(indices, want) {
+			t.Errorf("%s: checked indices %v, want %v", name, indices, want)
+		}
+	}
+}
+
+func TestMultipleClassesInOneFile(t *testing.T) {
+	source := `class Main {
+  static int a, b;
+  function void set(int x) { let a = x; let b = x + 1; return; }
+  function int sum() { return Other.get() + a + b; }
+}
+class Other {
+  static int c;
+  field int d, e;
+  function void set(int x) { let c = x; return; }
+  function int get() { return c; }
+  method int second() { var int y; let y = e; return y; }
+}`
+	_, _, err := compileSource("Main.jack", source, CompilerOptions{})
+	if err == nil || err.Error() != "Main.jack: Unexpected end of class at line 6, col 1" {
+		t.Errorf("error %v without MultipleClasses, want an unexpected end of class", err)
+	}
+
+	vm, _, err := compileSource("Main.jack", source, CompilerOptions{MultipleClasses: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var functions []string
+	for _, line := range strings.Split(vm, "\n") {
+		if strings.HasPrefix(line, "function ") {
+			functions = append(functions, line)
+		}
+	}
+	want := []string{"function Main.set 0", "function Main.sum 0", "function Other.set 0", "function Other.get 0", "function Other.second 1"}
+	if !reflect.DeepEqual(functions, want) {
+		t.Errorf("functions %q, want %q", functions, want)
+	}
+	if !strings.Contains(vm, "function Other.second 1\npush argument 0\npop pointer 0\npush this 1\n") {
+		t.Errorf("Other.second does not access field e as this 1:\n%s", vm)
+	}
+
+	// Both classes share the static segment of Main.vm
+	machine := newVMMachine(vm)
+	machine.file = "Main"
+	machine.steps = 1000
+	for _, call := range []struct {
+		function string
+		arg      int16
+	}{{"Main.set", 10}, {"Other.set", 100}} {
+		if _, err := machine.call(call.function, call.arg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sum, err := machine.call("Main.sum"); err != nil || sum != 121 {
+		t.Errorf("Main.sum() = %d, %v, want 121", sum, err)
+	}
+}
+
+func TestResetCompilesAnotherSource(t *testing.T) {
+	first := `class A {
+  static int shared;
+  function void f() { var int unused; while (true) { } return; }
+}`
+	second := `class B {
+  function void g() { while (true) { } return; }
+}`
+	tokenizer := NewTokenizer(strings.NewReader(first))
+	var output strings.Builder
+	writer := NewVMWriter(&output)
+	compiler := NewJackCompiler(&tokenizer, &writer)
+	compiler.Options.WarnUnused = true
+	if err := compiler.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if len(compiler.Warnings()) != 1 {
+		t.Fatalf("warnings %q, want the unused variable", compiler.Warnings())
+	}
+
+	tokenizer = NewTokenizer(strings.NewReader(second))
+	output.Reset()
+	writer = NewVMWriter(&output)
+	compiler.Reset(&tokenizer, &writer)
+	if err := compiler.Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), "label B.g$WHILE0_BEGIN") {
+		t.Errorf("labels do not restart:\n%s", output.String())
+	}
+	if len(compiler.Warnings()) != 0 {
+		t.Errorf("warnings %q of the first source kept", compiler.Warnings())
+	}
+
+	// The statics of A are forgotten
+	tokenizer = NewTokenizer(strings.NewReader(`class B { function void g() { let shared = 1; return; } }`))
+	compiler.Reset(&tokenizer, NullWriter{})
+	if err := compiler.Compile(); err == nil || !strings.Contains(err.Error(), "undeclared variable shared") {
+		t.Errorf("error %v, want shared undeclared", err)
+	}
+}
+
+func TestIfControlFlow(t *testing.T) {
+	source := `class Main {
+  function int f(int x) { if (x) { let x = 1; } return x; }
+  function int g(int x) { if (x) { let x = 1; } else { let x = 2; } return x; }
+}`
+	want := `function Main.f 0
+push argument 0
+not
+if-goto Main.f$IF0_ELSE
+push constant 1
+pop argument 0
+label Main.f$IF0_ELSE
+push argument 0
+return
+function Main.g 0
+push argument 0
+not
+if-goto Main.g$IF1_ELSE
+push constant 1
+pop argument 0
+goto Main.g$IF1_END
+label Main.g$IF1_ELSE
+push constant 2
+pop argument 0
+label Main.g$IF1_END
+push argument 0
+return
+`
+	compiled, _, err := compileSource("Main.jack", source, CompilerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, err := generateSource(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, vm := range map[string]string{"compiled": compiled, "generated": generated} {
+		if vm != want {
+			t.Errorf("%s VM code\n%s\nwant\n%s", name, vm, want)
+		}
+	}
+
+	results := []struct {
+		function string
+		x, want  int16
+	}{{"Main.f", 0, 0}, {"Main.f", -1, 1}, {"Main.g", 0, 2}, {"Main.g", -1, 1}}
+	for _, result := range results {
+		if got := runVM(t, compiled, result.function, result.x); got != result.want {
+			t.Errorf("%s(%d) = %d, want %d", result.function, result.x, got, result.want)
+		}
+	}
+}
+
+func TestMissingSemicolonPosition(t *testing.T) {
+	source := `class Main {
+  function void f() {
+    var int x;
+    let x = 1
+    return;
+  }
+}`
+	want := `Main.jack: Expected terminal ";", got "return" at line 5, col 5`
+	for _, options := range []CompilerOptions{{}, {RecoverErrors: true}} {
+		if _, _, err := compileSource("Main.jack", source, options); err == nil || err.Error() != want {
+			t.Errorf("RecoverErrors %v: error %v, want %q", options.RecoverErrors, err, want)
+		}
+	}
+
+	// Recovery resumes after the next semicolon
+	source = `class Main {
+  function void f() {
+    var int x;
+    let x = 1
+    let x = 2;
+    do Output.printInt(x)
+    return;
+  }
+}`
+	_, _, err := compileSource("Main.jack", source, CompilerOptions{RecoverErrors: true})
+	want = `Main.jack: Expected terminal ";", got "let" at line 5, col 5` + "\n" +
+		`Main.jack: Expected terminal ";", got "return" at line 7, col 5`
+	if err == nil || err.Error() != want {
+		t.Errorf("error %v, want %q", err, want)
+	}
+}
+
+func TestQualifiedVariableAccess(t *testing.T) {
+	tests := []struct {
+		statement string
+		want      string
+	}{
+		{"return Foo.BAR + 1;", "Foo.BAR is not a subroutine call, variables of other classes or objects can not be accessed at line 3, col 12"},
+		{"return Main.x;", "Main.x is not a subroutine call, variables of other classes or objects can not be accessed at line 3, col 12"},
+		{"let Foo.bar = 1;", "Foo.bar is not a subroutine call, variables of other classes or objects can not be accessed at line 3, col 9"},
+	}
+	for _, test := range tests {
+		source := "class Main {\n  function int f() {\n    " + test.statement + "\n  }\n}"
+		if _, _, err := compileSource("Main.jack", source, CompilerOptions{}); err == nil || err.Error() != "Main.jack: "+test.want {
+			t.Errorf("%s: error %v, want %q", test.statement, err, test.want)
+		}
+		if _, err := generateSource(source); err == nil || err.Error() != test.want {
+			t.Errorf("%s: generator error %v, want %q", test.statement, err, test.want)
+		}
+	}
+}
+
+func TestConstructorReturnsThis(t *testing.T) {
+	tests := []struct {
+		body string
+		want []string
+	}{
+		{"return this;", nil},
+		{"return 0;", []string{"Main.jack: constructor Main.new should return this"}},
+		{"if (true) { return this; } return 0;", []string{"Main.jack: constructor Main.new should return this"}},
+		{"if (true) { return this; }", []string{"Main.jack: subroutine Main.new of type Main may end without returning a value"}},
+	}
+	for _, test := range tests {
+		source := "class Main {\n  field int x;\n  constructor Main new() {\n    " + test.body + "\n  }\n}"
+		for _, options := range []CompilerOptions{{}, {FoldConstants: true}} {
+			_, warnings, err := compileSource("Main.jack", source, options)
+			if err != nil || !reflect.DeepEqual(warnings, test.want) {
+				t.Errorf("%s, FoldConstants %v: warnings %q, %v, want %q", test.body, options.FoldConstants, warnings, err, test.want)
+			}
+		}
+	}
+
+	source := "class Main {\n  constructor Main new() {\n    return 0;\n  }\n}"
+	diagnostics := Diagnostics(strings.NewReader(source), "Main.jack")
+	if len(diagnostics) != 1 || diagnostics[0].Range.Start != (Position{Line: 3, Column: 12}) {
+		t.Errorf("diagnostics %v, want one at the returned 0", diagnostics)
+	}
+
+	_, _, err := compileSource("Main.jack", "class Main {\n  constructor Main new() {\n    return;\n  }\n}", CompilerOptions{})
+	if want := "Main.jack: subroutine Main.new of type Main must return a value at line 3, col 5"; err == nil || err.Error() != want {
+		t.Errorf("error %v, want %q", err, want)
+	}
+}
+
+func TestThisUsedInFunction(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"return x;", `field "x" used in function Main.f, which has no this at line 7, col 12`},
+		{"let x = 1; return 0;", `field "x" used in function Main.f, which has no this at line 7, col 9`},
+		{"let a[0] = 1; return 0;", `field "a" used in function Main.f, which has no this at line 7, col 9`},
+		{"return this;", "this used in function Main.f, which has no this at line 7, col 12"},
+		{"do g(); return 0;", "method call g() used in function Main.f, which has no this at line 7, col 8"},
+		{"return Main.h();", ""},
+	}
+	for _, test := range tests {
+		source := "class Main {\n  field int x;\n  field Array a;\n  method void g() { return; }\n  function int h() { return 0; }\n  function int f() {\n    " + test.body + "\n  }\n}"
+		_, _, compileErr := compileSource("Main.jack", source, CompilerOptions{})
+		_, generateErr := generateSource(source)
+		if test.want == "" {
+			if compileErr != nil || generateErr != nil {
+				t.Errorf("%s: errors %v and %v, want none", test.body, compileErr, generateErr)
+			}
+			continue
+		}
+		if compileErr == nil || compileErr.Error() != "Main.jack: "+test.want {
+			t.Errorf("%s: error %v, want %q", test.body, compileErr, test.want)
+		}
+		if generateErr == nil || generateErr.Error() != test.want {
+			t.Errorf("%s: generator error %v, want %q", test.body, generateErr, test.want)
+		}
+	}
+}
+
+func TestByteOrderMarkAndEmptySources(t *testing.T) {
+	vm, _, err := compileSource("Main.jack", "\uFEFFclass Main { function void f() { return; } }", CompilerOptions{})
+	if want := "function Main.f 0\npush constant 0\nreturn\n"; err != nil || vm != want {
+		t.Errorf("BOM-prefixed class compiled to %q, %v, want %q", vm, err, want)
+	}
+	tokens := scanTokens(t, "\uFEFFclass")
+	if want := []Token{NewToken(Keyword, "class", Position{Line: 1, Column: 1})}; !reflect.DeepEqual(tokens, want) {
+		t.Errorf("tokens %v, want %v", tokens, want)
+	}
+
+	sources := map[string]string{
+		"// nothing\n/* here */\n  \n": "line 4, col 1",
+		"":                             "line 1, col 1",
+		"\uFEFF":                       "line 1, col 1",
+	}
+	for source, position := range sources {
+		want := "Main.jack: empty source, no class found at " + position
+		for _, options := range []CompilerOptions{{}, {FoldConstants: true}} {
+			if _, _, err := compileSource("Main.jack", source, options); err == nil || err.Error() != want {
+				t.Errorf("%q: error %v, want %q", source, err, want)
+			}
+		}
+	}
+}
+
+func TestKeywordsAsNames(t *testing.T) {
+	sources := map[string]string{
+		"class Main { function void f() { var int if; return; } }": "Main.jack: 'if' is a reserved keyword and cannot be used as a name at line 1, col 42",
+		"class Main { function void class() { return; } }":         "Main.jack: 'class' is a reserved keyword and cannot be used as a name at line 1, col 28",
+	}
+	for source, want := range sources {
+		for _, options := range []CompilerOptions{{}, {FoldConstants: true}} {
+			if _, _, err := compileSource("Main.jack", source, options); err == nil || err.Error() != want {
+				t.Errorf("%s: error %v, want %q", source, err, want)
+			}
+		}
+	}
+}
+
+func TestAnnotateNamesVariables(t *testing.T) {
+	source := "class Main { static int s; function int f(int a) { var int x, y; let y = a; let x = s; return x + y; } }"
+	want := "function Main.f 2\npush argument 0 // a\npop local 1 // y\npush static 0 // s\npop local 0 // x\n" +
+		"push local 0 // x\npush local 1 // y\nadd\nreturn\n"
+	for _, options := range []CompilerOptions{{Annotate: true}, {Annotate: true, FoldConstants: true}} {
+		vm, _, err := compileSource("Main.jack", source, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vm != want {
+			t.Errorf("FoldConstants %v: got\n%s\nwant\n%s", options.FoldConstants, vm, want)
+		}
+		if got := runVM(t, vm, "Main.f", 21); got != 21 {
+			t.Errorf("annotated Main.f(21) = %d, want 21", got)
+		}
+	}
+}
+
+func TestStyleWarnings(t *testing.T) {
+	source := `class Main {
+		function void f() {
+			var int x;
+			while (x < 1) { }
+			let x = x;
+			if (x) { } else { }
+			let x = x + 0;
+			return;
+		}
+	}`
+	want := []string{
+		"Main.jack: empty while body in Main.f",
+		"Main.jack: let x = x in Main.f has no effect",
+		"Main.jack: empty if body in Main.f",
+		"Main.jack: empty else body in Main.f",
+	}
+	for _, options := range []CompilerOptions{{WarnStyle: true}, {WarnStyle: true, FoldConstants: true}} {
+		_, warnings, err := compileSource("Main.jack", source, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(warnings, want) {
+			t.Errorf("FoldConstants %v: warnings %q, want %q", options.FoldConstants, warnings, want)
+		}
+	}
+	if _, warnings, err := compileSource("Main.jack", source, CompilerOptions{}); err != nil || len(warnings) != 0 {
+		t.Errorf("warnings %q, %v without -warn-style", warnings, err)
+	}
+}
+
+func TestModuloOperator(t *testing.T) {
+	source := "class Main { function int f(int a, int b) { return a % b; } }"
+	want := "function Main.f 0\npush argument 0\npush argument 1\ncall Math.mod 2\nreturn\n"
+	var math strings.Builder
+	if err := WriteMathRoutines(&math); err != nil {
+		t.Fatal(err)
+	}
+	for _, options := range []CompilerOptions{{ExtOperators: true}, {ExtOperators: true, FoldConstants: true}} {
+		vm, _, err := compileSource("Main.jack", source, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if vm != want {
+			t.Errorf("FoldConstants %v: got\n%s\nwant\n%s", options.FoldConstants, vm, want)
+		}
+		if got := runVM(t, vm+math.String(), "Main.f", 17, 5); got != 2 {
+			t.Errorf("17 %% 5 = %d, want 2", got)
+		}
+	}
+
+	wantErr := "Main.jack: operator % in Main.f is an extension of Jack and not enabled at line 1, col 54"
+	for _, options := range []CompilerOptions{{}, {FoldConstants: true}} {
+		if _, _, err := compileSource("Main.jack", source, options); err == nil || err.Error() != wantErr {
+			t.Errorf("error %v without -ext-operators, want %q", err, wantErr)
+		}
+	}
+}
+
+func TestUnclosedBrace(t *testing.T) {
+	sources := map[string]string{
+		"class Main {\n function void f() {\n  if (true) {\n   return;\n  }\n }\n": "Main.jack: unclosed '{' opened at line 1, col 12",
+		"class Main {\n function void f() {\n  return;\n\n":                        "Main.jack: unclosed '{' opened at line 2, col 20",
+	}
+	for source, want := range sources {
+		for _, options := range []CompilerOptions{{}, {FoldConstants: true}} {
+			if _, _, err := compileSource("Main.jack", source, options); err == nil || err.Error() != want {
+				t.Errorf("%q: error %v, want %q", source, err, want)
+			}
+		}
+	}
+}
+
+func TestInvalidLetTargets(t *testing.T) {
+	sources := map[string]string{
+		"class Main { function void f() { let 5 = 1; return; } }":             `Main.jack: cannot assign to "5", let needs a variable at line 1, col 38`,
+		"class Main { function void f() { let undeclared = 1; return; } }":    "Main.jack: cannot assign to undeclared variable undeclared in Main.f at line 1, col 38",
+		"class Main { function void f() { let undeclared[0] = 1; return; } }": "Main.jack: cannot assign to an element of undeclared array undeclared in Main.f at line 1, col 38",
+	}
+	for source, want := range sources {
+		for _, options := range []CompilerOptions{{}, {FoldConstants: true}} {
+			if _, _, err := compileSource("Main.jack", source, options); err == nil || err.Error() != want {
+				t.Errorf("%s: error %v, want %q", source, err, want)
+			}
+		}
+	}
+}
+
+func TestCallsWithoutArguments(t *testing.T) {
+	source := `class Main {
+		function void f() {
+			var Main m;
+			do Main.g();
+			do m.h();
+			do Other.k( );
+			return;
+		}
+		function void g() { return; }
+		method void h() { return; }
+	}`
+	want := "function Main.f 1\ncall Main.g 0\npop temp 0\npush local 0\ncall Main.h 1\npop temp 0\ncall Other.k 0\npop temp 0\npush constant 0\nreturn\n"
+	for _, options := range []CompilerOptions{{}, {FoldConstants: true}} {
+		vm, _, err := compileSource("Main.jack", source, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(vm, want) {
+			t.Errorf("FoldConstants %v: got\n%s\nwant it to start with\n%s", options.FoldConstants, vm, want)
+		}
+	}
+
+	// A missing argument after "," is no empty list
+	wantErr := `Main.jack: expected term, got ")" at line 1, col 46`
+	if _, _, err := compileSource("Main.jack", "class Main { function void f() { do Main.g(1,); return; } }", CompilerOptions{}); err == nil || err.Error() != wantErr {
+		t.Errorf("error %v, want %q", err, wantErr)
+	}
+}
+
+func TestExpectedTerm(t *testing.T) {
+	tests := map[string]string{
+		"let x = ;":      `expected term, got ";" at line 1, col 53`,
+		"let x = );":     `expected term, got ")" at line 1, col 53`,
+		"let x = (;":     `expected term, got ";" at line 1, col 54`,
+		"let x = while;": `unexpected keyword "while" at line 1, col 53`,
+	}
+	for statement, want := range tests {
+		source := "class Main { function void f() { var int x; " + statement + " return; } }"
+		for _, options := range []CompilerOptions{{}, {FoldConstants: true}} {
+			if _, _, err := compileSource("Main.jack", source, options); err == nil || err.Error() != "Main.jack: "+want {
+				t.Errorf("%s: error %v, want %q", statement, err, want)
+			}
+		}
+	}
+}
+
+func TestStringArgumentOfClosingParen(t *testing.T) {
+	source := `class Main { function void f() { do Output.printString(")"); return; } }`
+	want := "function Main.f 0\npush constant 1\ncall String.new 1\npop temp 0\npush temp 0\npush constant 41\n" +
+		"call String.appendChar 2\npop temp 1\npush temp 0\ncall Output.printString 1\npop temp 0\npush constant 0\nreturn\n"
+	for _, options := range []CompilerOptions{{}, {FoldConstants: true}} {
+		vm, _, err := compileSource("Main.jack", source, options)
+		if err != nil {
+			t.Fatalf("FoldConstants %v: %v", options.FoldConstants, err)
+		}
+		if vm != want {
+			t.Errorf("FoldConstants %v: got\n%s\nwant\n%s", options.FoldConstants, vm, want)
+		}
+	}
+}
+
+func TestReturnStringOfSemicolon(t *testing.T) {
+	source := `class Main { function String f() { return ";"; } }`
+	want := "function Main.f 0\npush constant 1\ncall String.new 1\npop temp 0\npush temp 0\npush constant 59\n" +
+		"call String.appendChar 2\npop temp 1\npush temp 0\nreturn\n"
+	for _, options := range []CompilerOptions{{}, {FoldConstants: true}} {
+		vm, _, err := compileSource("Main.jack", source, options)
+		if err != nil {
+			t.Fatalf("FoldConstants %v: %v", options.FoldConstants, err)
+		}
+		if vm != want {
+			t.Errorf("FoldConstants %v: got\n%s\nwant\n%s", options.FoldConstants, vm, want)
+		}
+	}
+}
+
+// stripComments removes comments and the blank lines they leave from vm.
+func stripComments(vm string) string {
+	var lines []string
+	for _, line := range strings.Split(vm, "\n") {
+		if comment := strings.Index(line, "//"); comment >= 0 {
+			line = line[:comment]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestAnnotateKeepsOptimizedCommands(t *testing.T) {
+	source := `class Main {
+		static int s;
+		function int f(int a) {
+			var int x, y;
+			let x = x;
+			let y = a;
+			let s = y;
+			return s + x;
+		}
+	}`
+	for _, fold := range []bool{false, true} {
+		for _, options := range []CompilerOptions{{Peephole: true}, {Peephole: true, PoolStrings: true}, {RelaxedVarDecs: !fold}, {Stubs: NewStubs()}} {
+			options.FoldConstants = fold
+			plain, _, err := compileSource("Main.jack", source, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			options.Annotate = true
+			annotated, _, err := compileSource("Main.jack", source, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stripComments(annotated) != stripComments(plain) {
+				t.Errorf("%+v: annotated\n%s\nwant the commands of\n%s", options, annotated, plain)
+			}
+			if !strings.Contains(annotated, "pop static 0 // s\n") {
+				t.Errorf("%+v: annotation missing:\n%s", options, annotated)
+			}
+		}
+	}
+
+	// Annotated pushes and pops are recorded as such
+	writer := &CommandWriter{}
+	compiler := newStatementCompiler(scanTokens(t, "let x = y; }"), writer)
+	compiler.Options.Annotate = true
+	compiler.symbolTable.Declare(Symbol{symbolType: VarSymbol, variableType: "int"}, "x", FunctionScope)
+	compiler.symbolTable.Declare(Symbol{symbolType: VarSymbol, variableType: "int"}, "y", FunctionScope)
+	compiler.compileLet()
+	want := []VMCommand{{Op: "push", Segment: LocalVMSegment, Index: 1}, {Op: "pop", Segment: LocalVMSegment, Index: 0}}
+	if !reflect.DeepEqual(writer.Commands, want) {
+		t.Errorf("commands %v, want %v", writer.Commands, want)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Signature describes a subroutine of a class compiled separately.
+type Signature struct {
+	Kind SubroutineType
+	// Number of declared parameters, not counting the object of methods
+	Arity      int
+	ReturnType string
+}
+
+// Signatures maps qualified subroutine names, e.g. "Math.multiply", to their
+// signatures, for checking calls to classes not compiled in the same run.
+type Signatures map[string]Signature
+
+// Add parses the class read from r and adds the signatures of its
+// subroutines.
+func (s Signatures) Add(r io.Reader) error {
+	tokenizer := NewTokenizer(r)
+	class, err := Parse(&tokenizer)
+	if err != nil {
+		return err
+	}
+	for _, subroutine := range class.Subroutines {
+		s[class.Name+"."+subroutine.Name] = Signature{
+			Kind:       subroutine.Kind,
+			Arity:      len(subroutine.Parameters),
+			ReturnType: subroutine.ReturnType,
+		}
+	}
+	return nil
+}
+
+// hasClass reports whether s contains subroutines of the class className.
+func (s Signatures) hasClass(className string) bool {
+	for name := range s {
+		if strings.HasPrefix(name, className+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// ScanSignatures returns the signatures of the classes in the .jack files of
+// the directory dir.
+func ScanSignatures(dir string) (Signatures, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open directory %q: %v", dir, err)
+	}
+
+	signatures := make(Signatures)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".jack" {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		handle, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("Could not open file %q for reading: %v", path, err)
+		}
+		err = signatures.Add(handle)
+		handle.Close()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse %q: %v", path, err)
+		}
+	}
+	return signatures, nil
+}
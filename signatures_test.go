@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const librarySource = `class Lib {
+  method int get(int i) { return i; }
+  function void init() { return; }
+  constructor Lib new(int size, int step) { return this; }
+}`
+
+func TestScanSignatures(t *testing.T) {
+	dir := writeSources(t, map[string]string{"Lib.jack": librarySource, "notes.txt": "not scanned"})
+	if err := os.Mkdir(filepath.Join(dir, "sub.jack"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	signatures, err := ScanSignatures(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Signatures{
+		"Lib.get":  {Kind: MethodSubroutineType, Arity: 1, ReturnType: "int"},
+		"Lib.init": {Kind: FunctionSubroutineType, Arity: 0, ReturnType: "void"},
+		"Lib.new":  {Kind: ConstructorSubroutineType, Arity: 2, ReturnType: "Lib"},
+	}
+	if !reflect.DeepEqual(signatures, want) {
+		t.Errorf("signatures %+v, want %+v", signatures, want)
+	}
+}
+
+func TestExternalCallsCheckedAgainstSignatures(t *testing.T) {
+	signatures := make(Signatures)
+	if err := signatures.Add(strings.NewReader(librarySource)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		call string
+		want string
+	}{
+		{"do l.get(1);", ""},
+		{"do Lib.init();", ""},
+		{"let l = Lib.new(1, 2);", ""},
+		{"do Output.printInt(1, 2);", ""},
+		{"do l.get();", "Lib.get expects 1 arguments but got 0 in Main.main"},
+		{"do Lib.init(1);", "Lib.init expects 0 arguments but got 1 in Main.main"},
+		{"do Lib.get(1);", "method Lib.get called as a function in Main.main"},
+		{"do l.init();", "function Lib.init called as a method in Main.main"},
+		{"do Lib.none();", "class Lib has no subroutine Lib.none, called in Main.main"},
+	}
+	for _, test := range tests {
+		source := "class Main {\n  function void main() {\n    var Lib l;\n    " + test.call + "\n    return;\n  }\n}"
+		_, _, err := compileSource("Main.jack", source, CompilerOptions{Signatures: signatures})
+		switch {
+		case test.want == "" && err != nil:
+			t.Errorf("%s: error %v, want none", test.call, err)
+		case test.want != "" && (err == nil || !strings.HasPrefix(err.Error(), "Main.jack: "+test.want+" at line 4, col ")):
+			t.Errorf("%s: error %v, want %q", test.call, err, test.want)
+		}
+		if _, _, err := compileSource("Main.jack", source, CompilerOptions{}); err != nil {
+			t.Errorf("%s: error %v without signatures", test.call, err)
+		}
+	}
+}
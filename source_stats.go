@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+type FileStats struct {
+	File        string `json:"file"`
+	Class       string `json:"class"`
+	Tokens      int    `json:"tokens"`
+	Subroutines int    `json:"subroutines"`
+	Fields      int    `json:"fields"`
+	Statics     int    `json:"statics"`
+	// Lines containing at least one token, i.e. neither blank nor only comments
+	Lines int `json:"lines"`
+}
+
+// SourceStats lists the size of .jack files for grading and profiling.
+type SourceStats struct {
+	Files []FileStats `json:"files"`
+}
+
+// Add tokenizes and parses the class read from r and adds its statistics
+// under the name file.
+func (s *SourceStats) Add(file string, r io.Reader) error {
+	tokenizer := NewTokenizer(r)
+	var tokens []Token
+	lines := make(map[int]bool)
+	for tokenizer.Scan() && !IsTokenType(tokenizer.Token(), EOF) {
+		token := tokenizer.Token()
+		tokens = append(tokens, token)
+		lines[token.position.Line] = true
+	}
+	if err := tokenizer.Err(); err != nil {
+		return err
+	}
+
+	class, err := Parse(newTokenBuffer(tokens))
+	if err != nil {
+		return err
+	}
+
+	stats := FileStats{
+		File:        file,
+		Class:       class.Name,
+		Tokens:      len(tokens),
+		Subroutines: len(class.Subroutines),
+		Lines:       len(lines),
+	}
+	for _, varDec := range class.ClassVarDecs {
+		switch varDec.Kind {
+		case FieldSymbol:
+			stats.Fields += len(varDec.Names)
+		case StaticSymbol:
+			stats.Statics += len(varDec.Names)
+		}
+	}
+	s.Files = append(s.Files, stats)
+	return nil
+}
+
+func (s *SourceStats) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(s)
+}
+
+func (s *SourceStats) WriteText(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(table, "file\tclass\ttokens\tsubroutines\tfields\tstatics\tlines\n")
+	for _, file := range s.Files {
+		fmt.Fprintf(table, "%s\t%s\t%d\t%d\t%d\t%d\t%d\n", file.File, file.Class, file.Tokens, file.Subroutines, file.Fields, file.Statics, file.Lines)
+	}
+	return table.Flush()
+}
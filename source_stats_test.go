@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const statsSource = `// A counter
+class Counter {
+    field int count, step;
+    static int instances;
+
+    /* Creates a counter */
+    constructor Counter new() {
+        let count = 0;
+        return this;
+    }
+
+    method void increment() { let count = count + step; return; }
+}
+`
+
+func TestSourceStats(t *testing.T) {
+	stats := &SourceStats{}
+	if err := stats.Add("Counter.jack", strings.NewReader(statsSource)); err != nil {
+		t.Fatal(err)
+	}
+	want := []FileStats{{File: "Counter.jack", Class: "Counter", Tokens: 45, Subroutines: 2, Fields: 2, Statics: 1, Lines: 9}}
+	if !reflect.DeepEqual(stats.Files, want) {
+		t.Errorf("stats %+v, want %+v", stats.Files, want)
+	}
+
+	var text strings.Builder
+	if err := stats.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	wantText := "file          class    tokens  subroutines  fields  statics  lines\n" +
+		"Counter.jack  Counter  45      2            2       1        9\n"
+	if text.String() != wantText {
+		t.Errorf("text\n%s\nwant\n%s", text.String(), wantText)
+	}
+
+	if err := stats.Add("Broken.jack", strings.NewReader("class Broken {")); err == nil {
+		t.Error("no error for a broken class")
+	}
+}
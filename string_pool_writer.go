@@ -0,0 +1,138 @@
+package main
+
+type stringPoolCommand struct {
+	// Literal of a string constant, only valid if write is nil
+	literal string
+	write   func(OutputWriter)
+}
+
+// StringPoolWriter wraps an OutputWriter and builds string constants used
+// more than once in a function only once. The pooled strings are constructed
+// into additional local variables when the function is entered, every use
+// pushes the same String object. Note that modifications of a pooled string
+// are thus visible to all of its uses.
+//
+// Functions are buffered until the next function declaration or Flush.
+type StringPoolWriter struct {
+	output      OutputWriter
+	function    string
+	nlocals     MachineWord
+	hasFunction bool
+	pending     []stringPoolCommand
+	uses        map[string]int
+}
+
+func NewStringPoolWriter(output OutputWriter) *StringPoolWriter {
+	return &StringPoolWriter{output: output, uses: make(map[string]int)}
+}
+
+func (w *StringPoolWriter) add(write func(OutputWriter)) {
+	w.pending = append(w.pending, stringPoolCommand{write: write})
+}
+
+// Close flushes the buffered function and closes the wrapped OutputWriter.
+func (w *StringPoolWriter) Close() error {
+	w.Flush()
+	return w.output.Close()
+}
+
+// Flush writes the buffered function to the wrapped OutputWriter.
+func (w *StringPoolWriter) Flush() {
+	slots := make(map[string]MachineWord)
+	if w.hasFunction {
+		nlocals := w.nlocals
+		var pooled []string
+		for _, command := range w.pending {
+			if _, seen := slots[command.literal]; command.write == nil && !seen && w.uses[command.literal] > 1 {
+				slots[command.literal] = nlocals
+				pooled = append(pooled, command.literal)
+				nlocals += 1
+			}
+		}
+
+		w.output.WriteFunction(w.function, nlocals)
+		for _, literal := range pooled {
+			w.output.WriteStringConstant(literal)
+			w.output.WritePop(LocalVMSegment, slots[literal])
+		}
+	}
+
+	for _, command := range w.pending {
+		switch slot, pooled := slots[command.literal]; {
+		case command.write != nil:
+			command.write(w.output)
+		case pooled:
+			w.output.WritePush(LocalVMSegment, slot)
+		default:
+			w.output.WriteStringConstant(command.literal)
+		}
+	}
+
+	w.hasFunction = false
+	w.pending = w.pending[:0]
+	w.uses = make(map[string]int)
+}
+
+func (w *StringPoolWriter) useTemps(temps *TempSlots) {
+	shareTemps(w.output, temps)
+}
+
+func (w *StringPoolWriter) WriteCommand(command string) {
+	w.add(func(o OutputWriter) { o.WriteCommand(command) })
+}
+
+func (w *StringPoolWriter) WritePush(segment VMSegmentType, index MachineWord) {
+	w.add(func(o OutputWriter) { o.WritePush(segment, index) })
+}
+
+func (w *StringPoolWriter) WritePop(segment VMSegmentType, index MachineWord) {
+	w.add(func(o OutputWriter) { o.WritePop(segment, index) })
+}
+
+func (w *StringPoolWriter) writeAnnotatedPush(segment VMSegmentType, index MachineWord, comment string) {
+	w.add(func(o OutputWriter) { writeVariablePush(o, segment, index, comment, true) })
+}
+
+func (w *StringPoolWriter) writeAnnotatedPop(segment VMSegmentType, index MachineWord, comment string) {
+	w.add(func(o OutputWriter) { writeVariablePop(o, segment, index, comment, true) })
+}
+
+func (w *StringPoolWriter) WriteArithmetic(operation VMOperation) {
+	w.add(func(o OutputWriter) { o.WriteArithmetic(operation) })
+}
+
+func (w *StringPoolWriter) WriteLabel(label string) {
+	w.add(func(o OutputWriter) { o.WriteLabel(label) })
+}
+
+func (w *StringPoolWriter) WriteGoto(label string) {
+	w.add(func(o OutputWriter) { o.WriteGoto(label) })
+}
+
+func (w *StringPoolWriter) WriteIf(label string) {
+	w.add(func(o OutputWriter) { o.WriteIf(label) })
+}
+
+func (w *StringPoolWriter) WriteCall(label string, nargs MachineWord) {
+	w.add(func(o OutputWriter) { o.WriteCall(label, nargs) })
+}
+
+func (w *StringPoolWriter) WriteFunction(label string, nlocals MachineWord) {
+	w.Flush()
+	w.function = label
+	w.nlocals = nlocals
+	w.hasFunction = true
+}
+
+func (w *StringPoolWriter) WriteStringConstant(constant string) {
+	w.pending = append(w.pending, stringPoolCommand{literal: constant})
+	w.uses[constant] += 1
+}
+
+func (w *StringPoolWriter) WriteReturn() {
+	w.add(func(o OutputWriter) { o.WriteReturn() })
+}
+
+func (w *StringPoolWriter) WriteComment(comment string) {
+	w.add(func(o OutputWriter) { o.WriteComment(comment) })
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStringPoolWriterPoolsRepeatedConstants(t *testing.T) {
+	recorder := &RecordingWriter{}
+	writer := NewStringPoolWriter(recorder)
+	writer.WriteFunction("Main.f", 1)
+	writer.WriteStringConstant("hi")
+	writer.WriteCall("Output.printString", 1)
+	writer.WriteStringConstant("ho")
+	writer.WriteStringConstant("hi")
+	writer.WriteReturn()
+	// Pools are per function
+	writer.WriteFunction("Main.g", 0)
+	writer.WriteStringConstant("hi")
+	writer.WriteReturn()
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []RecordedCall{
+		{"WriteFunction", []any{"Main.f", MachineWord(2)}},
+		{"WriteStringConstant", []any{"hi"}},
+		{"WritePop", []any{LocalVMSegment, MachineWord(1)}},
+		{"WritePush", []any{LocalVMSegment, MachineWord(1)}},
+		{"WriteCall", []any{"Output.printString", MachineWord(1)}},
+		{"WriteStringConstant", []any{"ho"}},
+		{"WritePush", []any{LocalVMSegment, MachineWord(1)}},
+		{"WriteReturn", nil},
+		{"WriteFunction", []any{"Main.g", MachineWord(0)}},
+		{"WriteStringConstant", []any{"hi"}},
+		{"WriteReturn", nil},
+		{"Close", nil},
+	}
+	if !reflect.DeepEqual(recorder.Calls, want) {
+		t.Errorf("recorded calls\n%v\nwant\n%v", recorder.Calls, want)
+	}
+}
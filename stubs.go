@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Stubs collects the functions called and defined by the compiled VM code,
+// including the calls emitted for "*", "/" and string constants, for listing
+// or writing stubs of the called functions no compiled class defines, e.g.
+// those of the OS.
+type Stubs struct {
+	// Number of arguments of the first call of each called function
+	called  map[string]MachineWord
+	defined map[string]bool
+}
+
+func NewStubs() *Stubs {
+	return &Stubs{
+		called:  make(map[string]MachineWord),
+		defined: make(map[string]bool),
+	}
+}
+
+// Writer returns an OutputWriter passing all commands on to output and
+// recording the calls and functions in s.
+func (s *Stubs) Writer(output OutputWriter) OutputWriter {
+	return &stubWriter{OutputWriter: output, stubs: s}
+}
+
+func (s *Stubs) call(function string, nargs MachineWord) {
+	if _, ok := s.called[function]; !ok {
+		s.called[function] = nargs
+	}
+}
+
+// Define marks function as defined elsewhere, e.g. by WriteMathRoutines.
+func (s *Stubs) Define(function string) {
+	s.defined[function] = true
+}
+
+// Missing returns the called functions that are not defined, sorted.
+func (s *Stubs) Missing() []string {
+	var missing []string
+	for function := range s.called {
+		if !s.defined[function] {
+			missing = append(missing, function)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// WriteVM writes a stub for every missing function to w. A stub only
+// returns 0, so calls of it link and run but do nothing.
+func (s *Stubs) WriteVM(w io.Writer) error {
+	vmWriter := NewVMWriter(w)
+	for _, function := range s.Missing() {
+		vmWriter.WriteComment(fmt.Sprintf("%s, called with %d argument(s)", function, s.called[function]))
+		vmWriter.WriteFunction(function, 0)
+		vmWriter.WritePush(ConstVMSegment, 0)
+		vmWriter.WriteReturn()
+	}
+	return vmWriter.Close()
+}
+
+// stubWriter records the calls and functions written to the wrapped writer.
+type stubWriter struct {
+	OutputWriter
+	stubs *Stubs
+}
+
+func (w *stubWriter) useTemps(temps *TempSlots) {
+	shareTemps(w.OutputWriter, temps)
+}
+
+func (w *stubWriter) writeAnnotatedPush(segment VMSegmentType, index MachineWord, comment string) {
+	writeVariablePush(w.OutputWriter, segment, index, comment, true)
+}
+
+func (w *stubWriter) writeAnnotatedPop(segment VMSegmentType, index MachineWord, comment string) {
+	writeVariablePop(w.OutputWriter, segment, index, comment, true)
+}
+
+func (w *stubWriter) WriteCall(label string, nargs MachineWord) {
+	w.stubs.call(label, nargs)
+	w.OutputWriter.WriteCall(label, nargs)
+}
+
+func (w *stubWriter) WriteFunction(label string, nlocals MachineWord) {
+	w.stubs.Define(label)
+	w.OutputWriter.WriteFunction(label, nlocals)
+}
+
+func (w *stubWriter) WriteArithmetic(operation VMOperation) {
+	if function, ok := arithmeticFunction(operation); ok {
+		w.stubs.call(function, 2)
+	}
+	w.OutputWriter.WriteArithmetic(operation)
+}
+
+func (w *stubWriter) WriteStringConstant(constant string) {
+	w.stubs.call("String.new", 1)
+	if constant != "" {
+		w.stubs.call("String.appendChar", 2)
+	}
+	w.OutputWriter.WriteStringConstant(constant)
+}
@@ -14,4 +14,6 @@ type Symbol struct {
 	symbolType   SymbolType
 	variableType string
 	index        MachineWord
+	// Where the symbol has been declared
+	position Position
 }
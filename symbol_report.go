@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+type SymbolReportEntry struct {
+	Name  string      `json:"name"`
+	Kind  SymbolType  `json:"kind"`
+	Type  string      `json:"type"`
+	Index MachineWord `json:"index"`
+}
+
+type SubroutineSymbolReport struct {
+	Name    string              `json:"name"`
+	Symbols []SymbolReportEntry `json:"symbols"`
+}
+
+type ClassSymbolReport struct {
+	Name        string                   `json:"name"`
+	Symbols     []SymbolReportEntry      `json:"symbols"`
+	Subroutines []SubroutineSymbolReport `json:"subroutines"`
+}
+
+// SymbolReport lists the statics and fields of compiled classes and the
+// arguments and locals of their subroutines.
+type SymbolReport struct {
+	Classes []ClassSymbolReport `json:"classes"`
+}
+
+func reportEntries(table *SymbolTable, scope Scope) []SymbolReportEntry {
+	entries := []SymbolReportEntry{}
+	table.Range(scope, func(name string, symbol Symbol) bool {
+		entries = append(entries, SymbolReportEntry{
+			Name:  name,
+			Kind:  symbol.symbolType,
+			Type:  symbol.variableType,
+			Index: symbol.index,
+		})
+		return true
+	})
+
+	// Group by kind
+	kindOrder := map[SymbolType]int{StaticSymbol: 0, FieldSymbol: 1, ArgumentSymbol: 2, VarSymbol: 3}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return kindOrder[entries[i].Kind] < kindOrder[entries[j].Kind]
+	})
+	return entries
+}
+
+// AddClass adds the class scope symbols of table as a new class.
+func (r *SymbolReport) AddClass(name string, table *SymbolTable) {
+	r.Classes = append(r.Classes, ClassSymbolReport{
+		Name:        name,
+		Symbols:     reportEntries(table, ClassScope),
+		Subroutines: []SubroutineSymbolReport{},
+	})
+}
+
+// AddSubroutine adds the function scope symbols of table to the last added
+// class.
+func (r *SymbolReport) AddSubroutine(name string, table *SymbolTable) {
+	class := &r.Classes[len(r.Classes)-1]
+	class.Subroutines = append(class.Subroutines, SubroutineSymbolReport{
+		Name:    name,
+		Symbols: reportEntries(table, FunctionScope),
+	})
+}
+
+func (r *SymbolReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+func (r *SymbolReport) WriteText(w io.Writer) error {
+	table := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, class := range r.Classes {
+		fmt.Fprintf(table, "class %s\n", class.Name)
+		for _, entry := range class.Symbols {
+			fmt.Fprintf(table, "  %s\t%s\t%s\t%d\n", entry.Kind, entry.Type, entry.Name, entry.Index)
+		}
+		for _, subroutine := range class.Subroutines {
+			fmt.Fprintf(table, "  subroutine %s\n", subroutine.Name)
+			for _, entry := range subroutine.Symbols {
+				fmt.Fprintf(table, "    %s\t%s\t%s\t%d\n", entry.Kind, entry.Type, entry.Name, entry.Index)
+			}
+		}
+	}
+	return table.Flush()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const symbolReportSource = `class Point {
+  static int count;
+  field int x, y;
+  method int add(Point other) { var int sum; let sum = x + other.getX(); return sum; }
+  function void reset() { return; }
+}`
+
+func TestSymbolReportListsSymbols(t *testing.T) {
+	report := &SymbolReport{}
+	if _, _, err := compileSource("Point.jack", symbolReportSource, CompilerOptions{SymbolReport: report}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []ClassSymbolReport{{
+		Name: "Point",
+		Symbols: []SymbolReportEntry{
+			{"count", StaticSymbol, "int", 0},
+			{"x", FieldSymbol, "int", 0},
+			{"y", FieldSymbol, "int", 1},
+		},
+		Subroutines: []SubroutineSymbolReport{
+			{"add", []SymbolReportEntry{
+				{"this", ArgumentSymbol, "Point", 0},
+				{"other", ArgumentSymbol, "Point", 1},
+				{"sum", VarSymbol, "int", 0},
+			}},
+			{"reset", []SymbolReportEntry{}},
+		},
+	}}
+	if !reflect.DeepEqual(report.Classes, want) {
+		t.Errorf("report %+v, want %+v", report.Classes, want)
+	}
+
+	var text strings.Builder
+	if err := report.WriteText(&text); err != nil {
+		t.Fatal(err)
+	}
+	wantText := `class Point
+  static  int  count  0
+  field   int  x      0
+  field   int  y      1
+  subroutine add
+    argument  Point  this   0
+    argument  Point  other  1
+    var       int    sum    0
+  subroutine reset
+`
+	if text.String() != wantText {
+		t.Errorf("text report\n%s\nwant\n%s", text.String(), wantText)
+	}
+
+	var encoded strings.Builder
+	if err := report.WriteJSON(&encoded); err != nil {
+		t.Fatal(err)
+	}
+	var decoded SymbolReport
+	if err := json.Unmarshal([]byte(encoded.String()), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Classes, want) {
+		t.Errorf("JSON report %s, want %+v", encoded.String(), want)
+	}
+}
@@ -1,6 +1,9 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+)
 
 type Scope string
 
@@ -30,10 +33,13 @@ func nextIndex(table *map[string]Symbol, symbolType SymbolType) (index MachineWo
 	return
 }
 
-func registerSymbol(table *map[string]Symbol, name string, symbol Symbol) Symbol {
+func registerSymbol(table *map[string]Symbol, name string, symbol Symbol) (Symbol, error) {
+	if existing, exists := (*table)[name]; exists {
+		return Symbol{}, fmt.Errorf("symbol %q already declared at %s", name, existing.position)
+	}
 	symbol.index = nextIndex(table, symbol.symbolType)
 	(*table)[name] = symbol
-	return symbol
+	return symbol, nil
 }
 
 func (s *SymbolTable) Count(symbolType SymbolType, scope Scope) (index MachineWord) {
@@ -46,14 +52,16 @@ func (s *SymbolTable) Count(symbolType SymbolType, scope Scope) (index MachineWo
 	return
 }
 
-func (s *SymbolTable) Declare(symbol Symbol, name string, scope Scope) Symbol {
+// Declare registers symbol under name in scope. Returns an error if scope
+// already contains a symbol with the same name.
+func (s *SymbolTable) Declare(symbol Symbol, name string, scope Scope) (Symbol, error) {
 	switch scope {
 	case ClassScope:
-		symbol = registerSymbol(&s.classScopeTable, name, symbol)
+		return registerSymbol(&s.classScopeTable, name, symbol)
 	case FunctionScope:
-		symbol = registerSymbol(&s.functionScopeTable, name, symbol)
+		return registerSymbol(&s.functionScopeTable, name, symbol)
 	}
-	return symbol
+	return symbol, nil
 }
 
 func (s *SymbolTable) Lookup(name string) (Symbol, error) {
@@ -69,6 +77,38 @@ func (s *SymbolTable) Lookup(name string) (Symbol, error) {
 	return Symbol{}, fmt.Errorf("no symbol with name %q declared", name)
 }
 
+// Range calls fn for each symbol declared in scope ordered by index, symbols
+// of different types sharing an index are ordered by type and name. Stops
+// once fn returns false.
+func (s *SymbolTable) Range(scope Scope, fn func(name string, symbol Symbol) bool) {
+	table := s.functionScopeTable
+	if scope == ClassScope {
+		table = s.classScopeTable
+	}
+
+	names := make([]string, 0, len(table))
+	for name := range table {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		lhs, rhs := table[names[i]], table[names[j]]
+		switch {
+		case lhs.index != rhs.index:
+			return lhs.index < rhs.index
+		case lhs.symbolType != rhs.symbolType:
+			return lhs.symbolType < rhs.symbolType
+		default:
+			return names[i] < names[j]
+		}
+	})
+
+	for _, name := range names {
+		if !fn(name, table[name]) {
+			return
+		}
+	}
+}
+
 func (s *SymbolTable) Clear(scope Scope) {
 	switch scope {
 	case ClassScope:
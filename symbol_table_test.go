@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSymbolTableRangeOrdersByIndex(t *testing.T) {
+	table := NewSymbolTable()
+	declarations := []struct {
+		name       string
+		symbolType SymbolType
+		scope      Scope
+	}{
+		{"z", FieldSymbol, ClassScope},
+		{"count", StaticSymbol, ClassScope},
+		{"a", FieldSymbol, ClassScope},
+		{"y", ArgumentSymbol, FunctionScope},
+		{"x", VarSymbol, FunctionScope},
+		{"b", ArgumentSymbol, FunctionScope},
+	}
+	for _, declaration := range declarations {
+		if _, err := table.Declare(Symbol{symbolType: declaration.symbolType, variableType: "int"}, declaration.name, declaration.scope); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	collect := func(scope Scope, limit int) (names []string, indices []MachineWord) {
+		table.Range(scope, func(name string, symbol Symbol) bool {
+			names = append(names, name)
+			indices = append(indices, symbol.index)
+			return len(names) < limit
+		})
+		return
+	}
+	// Ties of indices are ordered by kind, then name
+	names, indices := collect(ClassScope, 10)
+	if want := []string{"z", "count", "a"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("class scope names %q, want %q", names, want)
+	}
+	if want := []MachineWord{0, 0, 1}; !reflect.DeepEqual(indices, want) {
+		t.Errorf("class scope indices %v, want %v", indices, want)
+	}
+	names, _ = collect(FunctionScope, 10)
+	if want := []string{"y", "x", "b"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("function scope names %q, want %q", names, want)
+	}
+	if names, _ = collect(FunctionScope, 2); len(names) != 2 {
+		t.Errorf("Range visited %q after fn returned false", names)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import "fmt"
+
+// tempSlotCount is the size of the temp segment
+const tempSlotCount = 8
+
+// TempSlots allocates the slots of the temp segment used as scratch space.
+// A slot is reserved for the commands storing and loading a value and
+// released afterwards, so nested uses get distinct slots. A compiler shares
+// its TempSlots with the writers it writes to, see shareTemps, so the
+// commands a writer expands, e.g. for WriteStringConstant, never use a slot
+// held by the compiler.
+type TempSlots struct {
+	reserved [tempSlotCount]bool
+}
+
+// Reserve marks the lowest free slot as used and returns it. Panics if all
+// slots are in use.
+func (t *TempSlots) Reserve() MachineWord {
+	for slot, reserved := range t.reserved {
+		if !reserved {
+			t.reserved[slot] = true
+			return MachineWord(slot)
+		}
+	}
+	panic("all temp slots are in use")
+}
+
+// Release frees slot, which has to be reserved.
+func (t *TempSlots) Release(slot MachineWord) {
+	if slot < 0 || slot >= tempSlotCount || !t.reserved[slot] {
+		panic(fmt.Sprintf("temp slot %d is not reserved", slot))
+	}
+	t.reserved[slot] = false
+}
+
+// tempUser is implemented by OutputWriters reserving temp slots for the
+// commands they expand, or passing them on to such a writer.
+type tempUser interface {
+	// useTemps makes the writer reserve its slots from temps
+	useTemps(temps *TempSlots)
+}
+
+// shareTemps makes output reserve the temp slots it uses from temps.
+func shareTemps(output OutputWriter, temps *TempSlots) {
+	if user, ok := output.(tempUser); ok {
+		user.useTemps(temps)
+	}
+}
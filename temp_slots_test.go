@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTempSlotsReserveLowestFreeSlot(t *testing.T) {
+	var temps TempSlots
+	first := temps.Reserve()
+	second := temps.Reserve()
+	if first != 0 || second != 1 {
+		t.Fatalf("Reserve() = %d, %d, want 0, 1", first, second)
+	}
+	temps.Release(first)
+	if slot := temps.Reserve(); slot != 0 {
+		t.Errorf("Reserve() after releasing 0 = %d, want 0", slot)
+	}
+}
+
+func TestTempSlotsReleaseUnreservedPanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Release of an unreserved slot did not panic")
+		}
+	}()
+	var temps TempSlots
+	temps.Release(3)
+}
+
+func TestCompilerSharesTempsThroughWrappers(t *testing.T) {
+	vmWriter, _ := NewBufferVMWriter()
+	output := NewPeepholeWriter(NewStringPoolWriter(NewStubs().Writer(vmWriter)))
+	compiler := NewJackCompiler(NewSliceScanner(nil), output)
+	if vmWriter.temps != compiler.temps {
+		t.Error("VMWriter does not reserve from the compiler's TempSlots")
+	}
+}
+
+func TestStringConstantAvoidsSlotsHeldByCompiler(t *testing.T) {
+	vmWriter, buffer := NewBufferVMWriter()
+	compiler := NewJackCompiler(NewSliceScanner(nil), NewStubs().Writer(vmWriter))
+
+	held := compiler.temps.Reserve()
+	vmWriter.WriteStringConstant("a")
+	compiler.temps.Release(held)
+	vmWriter.Close()
+
+	if strings.Contains(buffer.String(), "temp 0") {
+		t.Errorf("string constant uses temp 0 held by the compiler:\n%s", buffer)
+	}
+}
+
+func TestArrayAssignmentOfStringUsesDistinctSlots(t *testing.T) {
+	source := `class Main {
+    function void main() {
+        var Array a;
+        let a[Main.f()[0]] = "x";
+        return;
+    }
+    function Array f() {
+        return null;
+    }
+}`
+	vm, _, err := compileSource("Main.jack", source, CompilerOptions{CheckBounds: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The element address stays on the stack while the string is built, the
+	// value is stored in temp 0 only afterwards
+	want := "push constant 120\ncall String.appendChar 2\npop temp 1\npush temp 0\npop temp 0\npop pointer 1\npush temp 0\npop that 0\n"
+	if !strings.Contains(vm, want) {
+		t.Errorf("VM code does not contain\n%s\ngot:\n%s", want, vm)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// NewToken returns a token of tokenType with the text terminal at position.
+func NewToken(tokenType TokenType, terminal string, position Position) Token {
+	return Token{tokenType: tokenType, terminal: terminal, position: position}
+}
+
+// SliceScanner is a TokenScanner yielding a fixed list of tokens, for driving
+// the compiler without a Tokenizer.
+type SliceScanner struct {
+	*tokenBuffer
+}
+
+// NewSliceScanner returns a SliceScanner yielding tokens, followed by an EOF
+// token unless tokens already end with one.
+func NewSliceScanner(tokens []Token) SliceScanner {
+	return SliceScanner{newTokenBuffer(tokens)}
+}
+
+// RecordedCall is a call of an OutputWriter method captured by
+// RecordingWriter, e.g. {"WritePush", []any{ConstVMSegment, MachineWord(1)}}.
+type RecordedCall struct {
+	Method string
+	Args   []any
+}
+
+// RecordingWriter is an OutputWriter that captures all calls instead of
+// emitting VM code, for asserting on the output of the compiler.
+type RecordingWriter struct {
+	Calls []RecordedCall
+}
+
+func (w *RecordingWriter) record(method string, args ...any) {
+	w.Calls = append(w.Calls, RecordedCall{Method: method, Args: args})
+}
+
+func (w *RecordingWriter) WriteCommand(command string) {
+	w.record("WriteCommand", command)
+}
+
+func (w *RecordingWriter) WritePush(segment VMSegmentType, index MachineWord) {
+	w.record("WritePush", segment, index)
+}
+
+func (w *RecordingWriter) WritePop(segment VMSegmentType, index MachineWord) {
+	w.record("WritePop", segment, index)
+}
+
+func (w *RecordingWriter) WriteArithmetic(operation VMOperation) {
+	w.record("WriteArithmetic", operation)
+}
+
+func (w *RecordingWriter) WriteLabel(label string) {
+	w.record("WriteLabel", label)
+}
+
+func (w *RecordingWriter) WriteGoto(label string) {
+	w.record("WriteGoto", label)
+}
+
+func (w *RecordingWriter) WriteIf(label string) {
+	w.record("WriteIf", label)
+}
+
+func (w *RecordingWriter) WriteCall(label string, nargs MachineWord) {
+	w.record("WriteCall", label, nargs)
+}
+
+func (w *RecordingWriter) WriteFunction(label string, nlocals MachineWord) {
+	w.record("WriteFunction", label, nlocals)
+}
+
+func (w *RecordingWriter) WriteStringConstant(constant string) {
+	w.record("WriteStringConstant", constant)
+}
+
+func (w *RecordingWriter) WriteReturn() {
+	w.record("WriteReturn")
+}
+
+func (w *RecordingWriter) WriteComment(comment string) {
+	w.record("WriteComment", comment)
+}
+
+func (w *RecordingWriter) Close() error {
+	w.record("Close")
+	return nil
+}
+
+// compileSource compiles the class in source, named filename, with options
+// and returns the VM code.
+func compileSource(filename string, source string, options CompilerOptions) (vm string, warnings []string, err error) {
+	tokenizer := NewTokenizer(strings.NewReader(source))
+	var output strings.Builder
+	warnings, err = compileFile(filename, &tokenizer, &output, options)
+	return output.String(), warnings, err
+}
+
+// newStatementCompiler returns a compiler positioned at the first of tokens,
+// compiling statements of the function Main.main.
+func newStatementCompiler(tokens []Token, output OutputWriter) *JackCompiler {
+	compiler := NewJackCompiler(NewSliceScanner(tokens), output)
+	compiler.currentClassName = "Main"
+	compiler.currentSubroutine = SubroutineInfo{name: "main", subroutineType: FunctionSubroutineType, returnType: "void"}
+	compiler.tokenScanner.Scan()
+	return compiler
+}
+
+// scanTokens returns the tokens of source up to, excluding, the EOF token.
+func scanTokens(t *testing.T, source string) []Token {
+	t.Helper()
+	tokenizer := NewTokenizer(strings.NewReader(source))
+	var tokens []Token
+	for tokenizer.Scan() && !IsTokenType(tokenizer.Token(), EOF) {
+		tokens = append(tokens, tokenizer.Token())
+	}
+	if err := tokenizer.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// writeSources writes the files, mapping names to contents, to a new
+// temporary directory and returns its path.
+func writeSources(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// runCaptured calls run with args and returns the exit code and what was
+// printed to stdout and stderr.
+func runCaptured(t *testing.T, args ...string) (code int, stdout string, stderr string) {
+	t.Helper()
+	capture := func(file **os.File) (restore func() string) {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := *file
+		*file = writer
+		output := make(chan string)
+		go func() {
+			contents, _ := io.ReadAll(reader)
+			output <- string(contents)
+		}()
+		return func() string {
+			*file = original
+			writer.Close()
+			return <-output
+		}
+	}
+	restoreStdout := capture(&os.Stdout)
+	restoreStderr := capture(&os.Stderr)
+	code = run(args)
+	return code, restoreStdout(), restoreStderr()
+}
@@ -7,6 +7,20 @@ import (
 
 type MachineWord int16
 
+// Range of MachineWord. Integer constants in Jack source are limited to
+// 0..MaxMachineWord, MinMachineWord can only be written as -32768.
+const (
+	MaxMachineWord MachineWord = 32767
+	MinMachineWord MachineWord = -32768
+)
+
+// toWord converts value to a MachineWord with 16 bit two's complement
+// wraparound like the Hack ALU, e.g. 32767+1 becomes -32768. All compile time
+// arithmetic on words goes through toWord.
+func toWord(value int) MachineWord {
+	return MachineWord(int16(uint16(value)))
+}
+
 type TokenType string
 
 const (
@@ -16,11 +30,30 @@ const (
 	IntegerConstant TokenType = "integerConstant"
 	StringConstant  TokenType = "stringConstant"
 	Identifier      TokenType = "identifier"
+	// Character literal like 'A', an extension of Jack
+	CharConstant TokenType = "charConstant"
+	// Comment including its delimiters, only produced by
+	// NewCommentTokenizer
+	Comment TokenType = "comment"
+	// End of the source, produced once after the last token. Its terminal
+	// is empty.
+	EOF TokenType = "eof"
 )
 
+// Position in a source file. Lines and columns start at 1.
+type Position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("line %d, col %d", p.Line, p.Column)
+}
+
 type Token struct {
 	tokenType TokenType
 	terminal  string
+	position  Position
 }
 
 func IsTokenType(t Token, tt TokenType) bool {
@@ -36,12 +69,23 @@ func IsTerminal(t Token, terminals ...string) bool {
 	return false
 }
 
-func (t *Token) asInt() MachineWord {
+// IsSymbol reports whether t is the symbol symbol. Unlike IsTerminal it does
+// not match a string constant of the same text, e.g. ")".
+func IsSymbol(t Token, symbol string) bool {
+	return t.tokenType == SymbolTokenType && t.terminal == symbol
+}
+
+func (t *Token) asInt() (MachineWord, error) {
 	word, err := strconv.Atoi(t.terminal)
 	// < 0 as - is an operator
-	if err != nil || word > 32767 || word < 0 {
-		fmt.Printf("Cannot parse %q as 16 bit int!", t)
-		return MachineWord(0)
+	if err != nil || word > int(MaxMachineWord) || word < 0 {
+		return 0, fmt.Errorf("cannot parse %q as 16 bit int", t.terminal)
 	}
-	return MachineWord(word)
+	return MachineWord(word), nil
+}
+
+// isMinWordMagnitude reports whether t is the integer constant 32768, which
+// is only representable negated, i.e. as -32768.
+func (t *Token) isMinWordMagnitude() bool {
+	return t.tokenType == IntegerConstant && t.terminal == "32768"
 }
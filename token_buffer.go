@@ -0,0 +1,66 @@
+package main
+
+// tokenBuffer is a TokenScanner yielding tokens scanned ahead of time, for
+// parsing a source more than once or telling how much of it was consumed.
+type tokenBuffer struct {
+	tokens []Token
+	// Index of the current token, -1 before the first Scan
+	index int
+}
+
+// newTokenBuffer returns a tokenBuffer yielding tokens, followed by an EOF
+// token unless tokens already end with one.
+func newTokenBuffer(tokens []Token) *tokenBuffer {
+	if n := len(tokens); n == 0 || !IsTokenType(tokens[n-1], EOF) {
+		end := Token{tokenType: EOF}
+		if n > 0 {
+			end.position = tokens[n-1].position
+		}
+		tokens = append(tokens[:n:n], end)
+	}
+	return &tokenBuffer{tokens: tokens, index: -1}
+}
+
+func (b *tokenBuffer) Scan() bool {
+	if b.index+1 >= len(b.tokens) {
+		return false
+	}
+	b.index += 1
+	return true
+}
+
+func (b *tokenBuffer) Token() Token {
+	if b.index < 0 || b.index >= len(b.tokens) {
+		return Token{}
+	}
+	return b.tokens[b.index]
+}
+
+func (b *tokenBuffer) Err() error {
+	return nil
+}
+
+func (b *tokenBuffer) Position() (line, col int) {
+	position := b.Token().position
+	return position.Line, position.Column
+}
+
+// consumed returns the share of the tokens scanned so far, from 0 to 1.
+func (b *tokenBuffer) consumed() float64 {
+	return float64(b.index) / float64(len(b.tokens))
+}
+
+// tokenRecorder passes on the tokens of the wrapped TokenScanner and keeps
+// them, for a tokenBuffer parsing them again.
+type tokenRecorder struct {
+	TokenScanner
+	tokens []Token
+}
+
+func (r *tokenRecorder) Scan() bool {
+	if !r.TokenScanner.Scan() {
+		return false
+	}
+	r.tokens = append(r.tokens, r.Token())
+	return true
+}
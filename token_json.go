@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// JSONToken is a token as written by TokenDump.WriteJSON.
+type JSONToken struct {
+	Type   TokenType `json:"type"`
+	Value  string    `json:"value"`
+	Line   int       `json:"line"`
+	Column int       `json:"col"`
+}
+
+type FileTokens struct {
+	File   string      `json:"file"`
+	Tokens []JSONToken `json:"tokens"`
+}
+
+// TokenDump lists the tokens of .jack files for tools that do not want to
+// tokenize Jack themselves. Values of string constants are unquoted.
+type TokenDump struct {
+	Files []FileTokens `json:"files"`
+	// TabWidth of the tokenizer, see Tokenizer.SetTabWidth
+	TabWidth int `json:"-"`
+}
+
+// Add tokenizes the source read from r and adds its tokens under the name
+// file. Nothing is added if the source can not be tokenized.
+func (d *TokenDump) Add(file string, r io.Reader) error {
+	tokenizer := NewTokenizer(r)
+	tokenizer.SetTabWidth(d.TabWidth)
+	tokens := []JSONToken{}
+	for tokenizer.Scan() && !IsTokenType(tokenizer.Token(), EOF) {
+		token := tokenizer.Token()
+		tokens = append(tokens, JSONToken{
+			Type:   token.tokenType,
+			Value:  token.terminal,
+			Line:   token.position.Line,
+			Column: token.position.Column,
+		})
+	}
+	if err := tokenizer.Err(); err != nil {
+		return err
+	}
+	d.Files = append(d.Files, FileTokens{File: file, Tokens: tokens})
+	return nil
+}
+
+func (d *TokenDump) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	// Keep the symbols <, > and & readable
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(d)
+}
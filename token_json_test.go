@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTokenDumpJSON(t *testing.T) {
+	dump := &TokenDump{TabWidth: 4}
+	if err := dump.Add("Main.jack", strings.NewReader("let x = \"<&>\";\n\tx < 1")); err != nil {
+		t.Fatal(err)
+	}
+	var output strings.Builder
+	if err := dump.WriteJSON(&output); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(output.String(), `"value": "<&>"`) {
+		t.Errorf("symbols are escaped:\n%s", output.String())
+	}
+
+	var decoded TokenDump
+	if err := json.Unmarshal([]byte(output.String()), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	want := []FileTokens{{File: "Main.jack", Tokens: []JSONToken{
+		{Type: Keyword, Value: "let", Line: 1, Column: 1},
+		{Type: Identifier, Value: "x", Line: 1, Column: 5},
+		{Type: SymbolTokenType, Value: "=", Line: 1, Column: 7},
+		{Type: StringConstant, Value: "<&>", Line: 1, Column: 9},
+		{Type: SymbolTokenType, Value: ";", Line: 1, Column: 14},
+		{Type: Identifier, Value: "x", Line: 2, Column: 5},
+		{Type: SymbolTokenType, Value: "<", Line: 2, Column: 7},
+		{Type: IntegerConstant, Value: "1", Line: 2, Column: 9},
+	}}}
+	if !reflect.DeepEqual(decoded.Files, want) {
+		t.Errorf("tokens\n%v\nwant\n%v", decoded.Files, want)
+	}
+
+	if err := dump.Add("Bad.jack", strings.NewReader("let x = \"open")); err == nil {
+		t.Error("unterminated string constant was tokenized")
+	}
+	if len(dump.Files) != 1 {
+		t.Errorf("failed file was added, files %v", dump.Files)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestToWordWrapsAround(t *testing.T) {
+	tests := map[int]MachineWord{
+		0:          0,
+		32767:      32767,
+		32767 + 1:  -32768,
+		-32768:     -32768,
+		-32768 - 1: 32767,
+		65535:      -1,
+		65536:      0,
+		200 * 200:  -25536,
+		-70000:     -4464,
+	}
+	for value, want := range tests {
+		if got := toWord(value); got != want {
+			t.Errorf("toWord(%d) = %d, want %d", value, got, want)
+		}
+	}
+}
+
+func TestAsIntRange(t *testing.T) {
+	for _, value := range []int{0, 1, int(MaxMachineWord)} {
+		token := NewToken(IntegerConstant, strconv.Itoa(value), Position{})
+		if word, err := token.asInt(); err != nil || int(word) != value {
+			t.Errorf("asInt(%d) = %d, %v", value, word, err)
+		}
+	}
+	for _, value := range []int{int(MaxMachineWord) + 1, -int(MinMachineWord), 65536, -1} {
+		token := NewToken(IntegerConstant, strconv.Itoa(value), Position{})
+		if word, err := token.asInt(); err == nil {
+			t.Errorf("asInt(%d) = %d, want an error", value, word)
+		}
+	}
+}
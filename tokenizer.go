@@ -5,246 +5,354 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"regexp"
 	"strings"
 	"unicode"
-	"unicode/utf8"
 )
 
-var (
-	keywordRegex         = regexp.MustCompile(`(class|constructor|function|method|field|static|var|int|char|boolean|void|true|false|null|this|let|do|if|else|while|return)`)
-	symbolRegex          = regexp.MustCompile(`[\{\}\[\]\(\)\.\,\;\+\-\*\/\&\|\<\>\)\=\~]`)
-	integerConstantRegex = regexp.MustCompile(`\d{1,5}`)
-	stringConstantRegex  = regexp.MustCompile(`"[^"\n]*"`)
-	identifierRegex      = regexp.MustCompile(`[a-zA-Z_]\w*`)
-	regexes              = []*regexp.Regexp{keywordRegex, symbolRegex, integerConstantRegex, stringConstantRegex, identifierRegex}
-	whitespaceRegex      = regexp.MustCompile(`^\s*$`)
-
-	regexTokenTypeMapping = map[*regexp.Regexp]TokenType{
-		keywordRegex:         Keyword,
-		symbolRegex:          SymbolTokenType,
-		integerConstantRegex: IntegerConstant,
-		stringConstantRegex:  StringConstant,
-		identifierRegex:      Identifier,
-	}
-)
+var keywords = map[string]bool{
+	"class": true, "constructor": true, "function": true, "method": true, "field": true, "static": true,
+	"var": true, "int": true, "char": true, "boolean": true, "void": true, "true": true, "false": true,
+	"null": true, "this": true, "let": true, "do": true, "if": true, "else": true, "while": true, "return": true,
+}
 
-type FilteredReader struct {
-	reader *bufio.Reader
+func isIdentifierStart(char rune) bool {
+	return char == '_' || ('a' <= char && char <= 'z') || ('A' <= char && char <= 'Z')
 }
 
-func NewFilteredReader(r io.Reader) FilteredReader {
-	return FilteredReader{reader: bufio.NewReader(r)}
-}
-
-func (r *FilteredReader) Read(b []byte) (int, error) {
-	var (
-		err  error
-		char rune
-		n    int
-	)
-
-	i := 0
-	for i < cap(b) {
-		char, n, err = r.reader.ReadRune()
-
-		if n == 0 {
-			break
-		}
-
-		if err != nil && !errors.Is(err, io.EOF) {
-			return 0, err
-		}
-
-		if char == '/' {
-			nextChar, _, nextErr := r.reader.ReadRune()
-			if nextErr != nil {
-				if !errors.Is(nextErr, io.EOF) {
-					return i, nextErr
-				} else {
-					err = io.EOF
-				}
-			} else if nextChar == '/' {
-				// Discard until newline character
-				_, err := r.reader.ReadString('\n')
-				if err != nil {
-					return i, err
-				}
-				continue
-			} else if nextChar == '*' {
-				// Discard until */
-				for {
-					str, err := r.reader.ReadString('/')
-					if err != nil {
-						return i, fmt.Errorf("Unclosed comment! (%v)", err)
-					}
-					if len(str) == 0 {
-						return i, fmt.Errorf("Unclosed comment!")
-					}
-					if str[len(str)-2] == '*' {
-						break
-					}
-				}
-				continue
-			} else {
-				unreadErr := r.reader.UnreadRune()
-				if unreadErr != nil {
-					return i, unreadErr
-				} else {
-					// Reset EOF error
-					err = nil
-				}
-			}
-		}
-
-		if n == 0 {
-			return n, err
-		} else if i+n <= len(b) {
-			i += utf8.EncodeRune(b[i:], char)
-			if errors.Is(err, io.EOF) {
-				break
-			}
-		} else {
-			unreadErr := r.reader.UnreadRune()
-			if unreadErr != nil {
-				return i, nil
-			}
-		}
-
-	}
-
-	return i, err
+func isIdentifierPart(char rune) bool {
+	return isIdentifierStart(char) || isDigit(char)
 }
 
-type Tokenizer struct {
-	scanner   *bufio.Scanner
-	nextToken Token
-	err       error
+func isDigit(char rune) bool {
+	return '0' <= char && char <= '9'
 }
 
-func NewTokenizer(r io.Reader) Tokenizer {
-	// Switch regex to longest mode
-	for _, regex := range regexes {
-		regex.Longest()
-	}
+func isSymbol(char rune) bool {
+	return strings.ContainsRune("{}[]().,;+-*/%&|<>=~", char)
+}
 
-	commentFilter := NewFilteredReader(r)
-	scanner := bufio.NewScanner(&commentFilter)
-	scanner.Split(splitToken)
-	return Tokenizer{scanner: scanner}
+// eof is returned by lexer.peek at the end of the input
+const eof rune = -1
+
+// byteOrderMark is skipped at the start of the input
+const byteOrderMark rune = '\uFEFF'
+
+// lexer splits its input into tokens character by character.
+type lexer struct {
+	reader *bufio.Reader
+	// Position of the next character
+	position Position
+	// Tab stops are every tabWidth columns, each column is one if < 2
+	tabWidth int
+	err      error
 }
 
-func matchToken(line string) ([]int, error) {
-	minRegexIndex := len(regexes)
-	minRegexMatch := []int{10000, 10000}
-	for i, regex := range regexes {
-		if match := regex.FindStringIndex(line); match != nil && match[0] <= minRegexMatch[0] {
-			if match[0] < minRegexMatch[0] || (match[1]-match[0]) > (minRegexMatch[1]-minRegexMatch[0]) {
-				minRegexIndex = i
-				minRegexMatch = match
-			}
-		}
+func newLexer(r io.Reader) *lexer {
+	l := &lexer{reader: bufio.NewReader(r), position: Position{Line: 1, Column: 1}}
+	if l.peek() == byteOrderMark {
+		// Not counted as a column
+		l.reader.ReadRune()
 	}
+	return l
+}
 
-	if minRegexIndex == len(regexes) {
-		return []int{}, fmt.Errorf("Unknown token %q", line)
+// peek returns the next character without consuming it, eof at the end of
+// the input or on read errors. CRLF and bare CR line endings read as '\n'.
+func (l *lexer) peek() rune {
+	char, _, err := l.reader.ReadRune()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			l.err = err
+		}
+		return eof
 	}
-
-	// Check if only whitespace
-	if !whitespaceRegex.MatchString(line[0:minRegexMatch[0]]) {
-		return []int{}, fmt.Errorf("Could not parse %q, matched %q but %q contains characters", line, line[minRegexMatch[0]:minRegexMatch[1]], line[0:minRegexMatch[0]])
+	l.reader.UnreadRune()
+	if char == '\r' {
+		return '\n'
 	}
+	return char
+}
 
-	return append(minRegexMatch, minRegexIndex), nil
+// next consumes and returns the next character, eof at the end of the input.
+func (l *lexer) next() rune {
+	char := l.peek()
+	if char == eof {
+		return eof
+	}
+	if raw, _, _ := l.reader.ReadRune(); raw == '\r' {
+		// Consume the LF of a CRLF line ending as well
+		if following, _, err := l.reader.ReadRune(); err == nil && following != '\n' {
+			l.reader.UnreadRune()
+		}
+	}
+	switch {
+	case char == '\n':
+		l.position.Line += 1
+		l.position.Column = 1
+	case char == '\t' && l.tabWidth > 1:
+		l.position.Column = (l.position.Column-1)/l.tabWidth*l.tabWidth + l.tabWidth + 1
+	default:
+		l.position.Column += 1
+	}
+	return char
 }
 
-func splitToken(data []byte, atEOF bool) (advance int, token []byte, err error) {
-	dataString := strings.TrimLeftFunc(string(data), unicode.IsSpace)
-	if len(dataString) == 0 {
-		advance = 0
-		token = nil
-		return
+// startsComment reports whether the input continues with "//" or "/*".
+func (l *lexer) startsComment() bool {
+	next, err := l.reader.Peek(2)
+	if err != nil && !errors.Is(err, io.EOF) {
+		l.err = err
 	}
+	return len(next) == 2 && next[0] == '/' && (next[1] == '/' || next[1] == '*')
+}
 
-	matchIndex, matchErr := matchToken(dataString)
+// scan returns the next token, an EOF token at the end of the input.
+func (l *lexer) scan() (Token, error) {
+	for unicode.IsSpace(l.peek()) {
+		l.next()
+	}
 
-	if matchErr != nil {
-		if atEOF {
-			err = fmt.Errorf("Unkown token %q", token)
-		} else {
-			advance = 0
-			token = nil
+	token := Token{position: l.position}
+	var text strings.Builder
+	var err error
+	switch char := l.peek(); {
+	case char == eof:
+		token.tokenType = EOF
+	case isIdentifierStart(char):
+		for isIdentifierPart(l.peek()) {
+			text.WriteRune(l.next())
+		}
+		token.tokenType = Identifier
+		if keywords[text.String()] {
+			token.tokenType = Keyword
+		}
+	case isDigit(char):
+		// At most 5 digits, longer numbers are split
+		for i := 0; i < 5 && isDigit(l.peek()); i++ {
+			text.WriteRune(l.next())
 		}
-		return
+		token.tokenType = IntegerConstant
+	case char == '"':
+		token.tokenType = StringConstant
+		err = l.scanString(&text)
+	case char == '\'':
+		token.tokenType = CharConstant
+		err = l.scanChar(&text)
+	case l.startsComment():
+		token.tokenType = Comment
+		err = l.scanComment(&text)
+	case isSymbol(char):
+		text.WriteRune(l.next())
+		token.tokenType = SymbolTokenType
+	case unicode.IsLetter(char):
+		err = fmt.Errorf("identifiers are limited to ASCII letters, digits and '_', got '%c'", char)
+	default:
+		err = fmt.Errorf("unexpected character '%c'", char)
 	}
 
-	matchBegin := matchIndex[0]
-	matchEnd := matchIndex[1]
+	if l.err != nil {
+		return Token{}, l.err
+	}
+	if err != nil {
+		end := l.position
+		if end == token.position {
+			// Nothing was consumed, the error is about the next character
+			end = Position{}
+		}
+		return Token{}, &CompileError{Message: err.Error(), Position: token.position, End: end}
+	}
+	token.terminal = text.String()
+	return token, nil
+}
 
-	if matchEnd == len(dataString) && !atEOF {
-		// Could possibly match a longer slice. -> Try to read more
-		advance = 0
-		token = nil
-		return
+// scanString writes the contents of a string constant to text.
+func (l *lexer) scanString(text *strings.Builder) error {
+	l.next()
+	for {
+		switch char := l.next(); char {
+		case '"':
+			return nil
+		case '\n', eof:
+			return fmt.Errorf("unterminated string constant")
+		default:
+			text.WriteRune(char)
+		}
 	}
+}
 
-	advance = matchEnd + (len(data) - len(dataString))
-	token = []byte(dataString[matchBegin:matchEnd])
+// scanChar writes the contents of a character constant, a single character
+// or an escape sequence, to text.
+func (l *lexer) scanChar(text *strings.Builder) error {
+	l.next()
+	char := l.peek()
+	if char != '\'' && char != '\n' && char != eof {
+		text.WriteRune(l.next())
+		if char == '\\' && l.peek() != '\n' && l.peek() != eof {
+			text.WriteRune(l.next())
+		}
+		if l.peek() == '\'' {
+			l.next()
+			return nil
+		}
+	}
 
-	return
+	// Report the text up to the closing quote if there is one on this line
+	for {
+		switch char := l.next(); char {
+		case '\'':
+			return fmt.Errorf("invalid character constant '%s'", text.String())
+		case '\n', eof:
+			return fmt.Errorf("unterminated character constant")
+		default:
+			text.WriteRune(char)
+		}
+	}
 }
 
-func parseToken(tokenString string) (token Token, err error) {
-	var regexMatch []int
+// scanComment writes a comment including its delimiters to text.
+func (l *lexer) scanComment(text *strings.Builder) error {
+	text.WriteRune(l.next())
+	if l.next() == '/' {
+		text.WriteRune('/')
+		for l.peek() != '\n' && l.peek() != eof {
+			text.WriteRune(l.next())
+		}
+		return nil
+	}
 
-	regexMatch, err = matchToken(tokenString)
-	if err != nil {
-		return
+	text.WriteRune('*')
+	previous := rune(0)
+	for {
+		char := l.next()
+		if char == eof {
+			return fmt.Errorf("Unclosed comment!")
+		}
+		text.WriteRune(char)
+		if previous == '*' && char == '/' {
+			return nil
+		}
+		previous = char
 	}
+}
 
-	regexIndex := regexMatch[2]
+type Tokenizer struct {
+	lexer *lexer
+	// Whether Comment tokens are yielded instead of discarded
+	keepComments bool
+	nextToken    Token
+	// Tokens scanned ahead by Peek
+	lookahead []Token
+	err       error
+	// Whether the EOF token was scanned
+	atEOF bool
+}
 
-	token.terminal = tokenString
+// NewTokenizer returns a Tokenizer that discards comments.
+func NewTokenizer(r io.Reader) Tokenizer {
+	return Tokenizer{lexer: newLexer(r)}
+}
 
-	switch regexIndex {
-	case 0:
-		token.tokenType = Keyword
-	case 1:
-		token.tokenType = SymbolTokenType
-	case 2:
-		token.tokenType = IntegerConstant
-	case 3:
-		token.tokenType = StringConstant
-		token.terminal = tokenString[1 : len(tokenString)-1]
-	case 4:
-		token.tokenType = Identifier
-	default:
-		err = fmt.Errorf("Unknown token %q", tokenString)
+// NewCommentTokenizer returns a Tokenizer that yields comments as Comment
+// tokens instead of discarding them.
+func NewCommentTokenizer(r io.Reader) Tokenizer {
+	return Tokenizer{lexer: newLexer(r), keepComments: true}
+}
+
+// scanCode advances scanner to the next token that is not a comment.
+func scanCode(scanner TokenScanner) bool {
+	for scanner.Scan() {
+		if !IsTokenType(scanner.Token(), Comment) {
+			return true
+		}
 	}
+	return false
+}
 
-	return
+// SetTabWidth makes a tab advance the column of the following tokens to the
+// next tab stop, with a tab stop every width columns like in an editor. By
+// default a tab counts as one column.
+func (t *Tokenizer) SetTabWidth(width int) {
+	t.lexer.tabWidth = width
 }
 
 func (t *Tokenizer) Err() error {
 	return t.err
 }
 
+func (t *Tokenizer) scanToken() (Token, bool) {
+	if t.err != nil || t.atEOF {
+		return Token{}, false
+	}
+	token, err := t.lexer.scan()
+	for err == nil && !t.keepComments && IsTokenType(token, Comment) {
+		token, err = t.lexer.scan()
+	}
+	if err != nil {
+		t.err = err
+		return Token{}, false
+	}
+	t.atEOF = IsTokenType(token, EOF)
+	return token, true
+}
+
 func (t *Tokenizer) Scan() bool {
-	for t.scanner.Scan() {
-		tokenString := t.scanner.Text()
-		// Parse instruction
-		token, err := parseToken(tokenString)
-		if err != nil {
-			t.err = err
-			return false
-		}
-		t.nextToken = token
+	if len(t.lookahead) > 0 {
+		t.nextToken = t.lookahead[0]
+		t.lookahead = t.lookahead[1:]
 		return true
 	}
 
-	return false
+	token, ok := t.scanToken()
+	if ok {
+		t.nextToken = token
+	}
+	return ok
+}
+
+// Peek returns the n-th token following the current one without consuming
+// it, i.e. Peek(1) returns the token the next call to Scan will yield.
+// Returns false if fewer than n tokens remain.
+func (t *Tokenizer) Peek(n int) (Token, bool) {
+	if n <= 0 {
+		return t.nextToken, true
+	}
+	for len(t.lookahead) < n {
+		token, ok := t.scanToken()
+		if !ok {
+			return Token{}, false
+		}
+		t.lookahead = append(t.lookahead, token)
+	}
+	return t.lookahead[n-1], true
 }
 
 func (t *Tokenizer) Token() Token {
 	return t.nextToken
 }
+
+func (t *Tokenizer) Position() (line, col int) {
+	return t.nextToken.position.Line, t.nextToken.position.Column
+}
+
+// Tokens tokenizes r in the background. The token channel is closed at the
+// end of the input or on the first error, which is then sent on the error
+// channel. The consumer has to drain the token channel.
+func Tokens(r io.Reader) (<-chan Token, <-chan error) {
+	tokens := make(chan Token)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer close(errs)
+		defer close(tokens)
+
+		tokenizer := NewTokenizer(r)
+		for tokenizer.Scan() && !IsTokenType(tokenizer.Token(), EOF) {
+			tokens <- tokenizer.Token()
+		}
+		if err := tokenizer.Err(); err != nil {
+			errs <- err
+		}
+	}()
+
+	return tokens, errs
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode"
+	"unicode/utf8"
+)
+
+// scanAll returns the tokens tokenizer yields up to, excluding, the EOF
+// token.
+func scanAll(t *testing.T, tokenizer Tokenizer) []Token {
+	t.Helper()
+	var tokens []Token
+	for tokenizer.Scan() && !IsTokenType(tokenizer.Token(), EOF) {
+		tokens = append(tokens, tokenizer.Token())
+	}
+	if err := tokenizer.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func TestCommentTokenizerYieldsComments(t *testing.T) {
+	tokens := scanAll(t, NewCommentTokenizer(strings.NewReader("// hello\nlet")))
+	want := []Token{
+		NewToken(Comment, "// hello", Position{Line: 1, Column: 1}),
+		NewToken(Keyword, "let", Position{Line: 2, Column: 1}),
+	}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("tokens %v, want %v", tokens, want)
+	}
+
+	tokens = scanAll(t, NewTokenizer(strings.NewReader("// hello\nlet")))
+	if !reflect.DeepEqual(tokens, want[1:]) {
+		t.Errorf("NewTokenizer tokens %v, want %v", tokens, want[1:])
+	}
+}
+
+func TestTokenizerKeepsCommentMarkersInStrings(t *testing.T) {
+	tokens := scanAll(t, NewTokenizer(strings.NewReader(`"a // b" "/* c */"`)))
+	want := []Token{
+		NewToken(StringConstant, "a // b", Position{Line: 1, Column: 1}),
+		NewToken(StringConstant, "/* c */", Position{Line: 1, Column: 10}),
+	}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("tokens %v, want %v", tokens, want)
+	}
+}
+
+func TestTokenizerNormalizesLineEndings(t *testing.T) {
+	want := []Token{
+		NewToken(Comment, "// a", Position{Line: 1, Column: 1}),
+		NewToken(Keyword, "let", Position{Line: 2, Column: 1}),
+		NewToken(Identifier, "x", Position{Line: 3, Column: 2}),
+		NewToken(Comment, "/* b\nc */", Position{Line: 4, Column: 1}),
+		NewToken(SymbolTokenType, "=", Position{Line: 5, Column: 5}),
+	}
+	for _, newline := range []string{"\n", "\r\n", "\r"} {
+		source := strings.Join([]string{"// a", "let", " x", "/* b", "c */="}, newline)
+		tokens := scanAll(t, NewCommentTokenizer(strings.NewReader(source)))
+		if !reflect.DeepEqual(tokens, want) {
+			t.Errorf("newline %q: tokens %v, want %v", newline, tokens, want)
+		}
+	}
+}
+
+// regexPatterns are the token patterns of the regex based tokenizer the
+// lexer replaced, anchored at the start of the input.
+var regexPatterns = []struct {
+	regex     *regexp.Regexp
+	tokenType TokenType
+}{
+	{regexp.MustCompile(`^(class|constructor|function|method|field|static|var|int|char|boolean|void|true|false|null|this|let|do|if|else|while|return)\b`), Keyword},
+	{regexp.MustCompile(`^[\{\}\[\]\(\)\.\,\;\+\-\*\/\&\|\<\>\=\~]`), SymbolTokenType},
+	{regexp.MustCompile(`^\d{1,5}`), IntegerConstant},
+	{regexp.MustCompile(`^"[^"\n]*"`), StringConstant},
+	{regexp.MustCompile(`^[a-zA-Z_]\w*`), Identifier},
+	{regexp.MustCompile(`^'(\\.|[^'\\\n])'`), CharConstant},
+	{regexp.MustCompile(`^//[^\n]*`), Comment},
+	{regexp.MustCompile(`^/\*([^*]|\*+[^*/])*\*+/`), Comment},
+}
+
+func init() {
+	for _, pattern := range regexPatterns {
+		pattern.regex.Longest()
+	}
+}
+
+// regexTokens splits source into tokens, including comments, like the regex
+// based tokenizer did: the longest match of all patterns wins, the first
+// pattern on ties. Only meant for sources without '\r' and '%'.
+func regexTokens(source string) ([]Token, error) {
+	position := Position{Line: 1, Column: 1}
+	advance := func(text string) {
+		for _, char := range text {
+			if char == '\n' {
+				position.Line += 1
+				position.Column = 1
+			} else {
+				position.Column += 1
+			}
+		}
+	}
+
+	var tokens []Token
+	for {
+		rest := strings.TrimLeftFunc(source, unicode.IsSpace)
+		advance(source[:len(source)-len(rest)])
+		source = rest
+		if source == "" {
+			return tokens, nil
+		}
+
+		match, tokenType := "", InvalidToken
+		for _, pattern := range regexPatterns {
+			if candidate := pattern.regex.FindString(source); len(candidate) > len(match) {
+				match, tokenType = candidate, pattern.tokenType
+			}
+		}
+		if match == "" || (strings.HasPrefix(source, "/*") && tokenType != Comment) {
+			return tokens, fmt.Errorf("no token at %s", position)
+		}
+		terminal := match
+		if tokenType == StringConstant || tokenType == CharConstant {
+			terminal = match[1 : len(match)-1]
+		}
+		tokens = append(tokens, Token{tokenType: tokenType, terminal: terminal, position: position})
+		advance(match)
+		source = source[len(match):]
+	}
+}
+
+// syntheticSource returns a class with n methods exercising all token types.
+func syntheticSource(n int) string {
+	var source strings.Builder
+	source.WriteString("/** A large generated class */\nclass Large {\n    field int count;\n    static Array items;\n\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&source, `    // Method number %d
+    method int method%d(int x, char c) {
+        var int i, sum;
+        var String s;
+        let s = "method %d: sum";
+        let i = 0;
+        while (i < 12345) {
+            if ((x > i) & ~(c = 'a')) {
+                let sum = sum + (items[i] * 2) - count;
+            } else {
+                /* skip */
+                let sum = sum / 3;
+            }
+            let i = i + 1;
+        }
+        do Output.printString(s);
+        return sum;
+    }
+
+`, i, i, i)
+	}
+	source.WriteString("}\n")
+	return source.String()
+}
+
+func TestTokenizerMatchesRegexTokenizer(t *testing.T) {
+	sources := []string{
+		syntheticSource(3),
+		"class thistle { field int x_1; method void do_it() { let x_1 = 123456; return; } }",
+		"let s = \"a // b /* c */\";\n\t// trailing comment",
+		"/**/ /*/ */ /* a\n * b\n */ x",
+		"'a' '\\n' '\\'' c",
+	}
+	for _, source := range sources {
+		want, err := regexTokens(source)
+		if err != nil {
+			t.Fatalf("%q: %v", source, err)
+		}
+		got := scanAll(t, NewCommentTokenizer(strings.NewReader(source)))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: tokens\n%v\nwant\n%v", source, got, want)
+		}
+	}
+}
+
+func BenchmarkTokenizer(b *testing.B) {
+	source := syntheticSource(500)
+	b.SetBytes(int64(len(source)))
+	for i := 0; i < b.N; i++ {
+		tokenizer := NewTokenizer(strings.NewReader(source))
+		for tokenizer.Scan() && !IsTokenType(tokenizer.Token(), EOF) {
+		}
+		if err := tokenizer.Err(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRegexTokenizer(b *testing.B) {
+	source := syntheticSource(500)
+	b.SetBytes(int64(len(source)))
+	for i := 0; i < b.N; i++ {
+		if _, err := regexTokens(source); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func FuzzLexerMatchesRegexTokenizer(f *testing.F) {
+	f.Add(syntheticSource(1))
+	f.Add("class Main { function void main() { do Output.printInt(-32767); return; } }")
+	f.Add("let s = \"a // b\"; /* c\n */ let c = 'x';")
+	f.Add("123456 thisX this_ _1 '\\'' /*/ */")
+	f.Fuzz(func(t *testing.T, source string) {
+		// The regex tokenizer got '\r' only after line endings were
+		// normalized and had no '%'. Invalid UTF-8 is replaced by the lexer.
+		if strings.ContainsAny(source, "\r%") || !utf8.ValidString(source) {
+			t.Skip()
+		}
+		want, err := regexTokens(source)
+		if err != nil {
+			t.Skip()
+		}
+		tokenizer := NewCommentTokenizer(strings.NewReader(source))
+		var got []Token
+		for tokenizer.Scan() && !IsTokenType(tokenizer.Token(), EOF) {
+			got = append(got, tokenizer.Token())
+		}
+		if err := tokenizer.Err(); err != nil {
+			t.Fatalf("lexer error %v, regex tokenizer tokens %v", err, want)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("lexer tokens\n%v\nregex tokenizer tokens\n%v", got, want)
+		}
+	})
+}
+
+func FuzzTokenizer(f *testing.F) {
+	f.Add([]byte(syntheticSource(1)))
+	f.Add([]byte("/*/"))
+	f.Add([]byte("\"unterminated\n'x"))
+	f.Add([]byte("\xef\xbb\xbfclass \xff"))
+	f.Fuzz(func(t *testing.T, source []byte) {
+		tokenizer := NewCommentTokenizer(bytes.NewReader(source))
+		for tokenizer.Scan() {
+		}
+		if err := tokenizer.Err(); err != nil {
+			if _, ok := err.(*CompileError); !ok {
+				t.Errorf("error %v is no CompileError", err)
+			}
+		}
+	})
+}
+
+func TestBlockCommentEnd(t *testing.T) {
+	tokenizer := NewTokenizer(strings.NewReader("/*/"))
+	for tokenizer.Scan() {
+	}
+	if err := tokenizer.Err(); err == nil || !strings.HasPrefix(err.Error(), "Unclosed comment!") {
+		t.Errorf("error %v for \"/*/\", want an unclosed comment", err)
+	}
+
+	tokens := scanAll(t, NewCommentTokenizer(strings.NewReader("/**/x /*/ */y")))
+	want := []Token{
+		NewToken(Comment, "/**/", Position{Line: 1, Column: 1}),
+		NewToken(Identifier, "x", Position{Line: 1, Column: 5}),
+		NewToken(Comment, "/*/ */", Position{Line: 1, Column: 7}),
+		NewToken(Identifier, "y", Position{Line: 1, Column: 13}),
+	}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("tokens %v, want %v", tokens, want)
+	}
+}
+
+func TestTokenizerPeek(t *testing.T) {
+	tokenizer := NewTokenizer(strings.NewReader("let x = 1;"))
+	if !tokenizer.Scan() || !IsTerminal(tokenizer.Token(), "let") {
+		t.Fatalf("first token %v, want let", tokenizer.Token())
+	}
+	if token, ok := tokenizer.Peek(0); !ok || !IsTerminal(token, "let") {
+		t.Errorf("Peek(0) = %v, %v, want the current token let", token, ok)
+	}
+	if token, ok := tokenizer.Peek(2); !ok || !IsTerminal(token, "=") {
+		t.Errorf("Peek(2) = %v, %v, want =", token, ok)
+	}
+	if token, ok := tokenizer.Peek(1); !ok || !IsTerminal(token, "x") {
+		t.Errorf("Peek(1) = %v, %v, want x", token, ok)
+	}
+	// 1, ; and EOF remain after =
+	if _, ok := tokenizer.Peek(6); ok {
+		t.Error("Peek(6) succeeded past the EOF token")
+	}
+
+	var terminals []string
+	for tokenizer.Scan() {
+		terminals = append(terminals, tokenizer.Token().terminal)
+	}
+	if want := []string{"x", "=", "1", ";", ""}; !reflect.DeepEqual(terminals, want) {
+		t.Errorf("scanned %q after peeking, want %q", terminals, want)
+	}
+}
+
+func TestCRLFSourceMatchesLF(t *testing.T) {
+	source := syntheticSource(2)
+	want := scanAll(t, NewTokenizer(strings.NewReader(source)))
+	for _, newline := range []string{"\r\n", "\r"} {
+		got := scanAll(t, NewTokenizer(strings.NewReader(strings.ReplaceAll(source, "\n", newline))))
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("newline %q: tokens differ from the LF source", newline)
+		}
+	}
+}
+
+func TestTokensChannel(t *testing.T) {
+	tokens, errs := Tokens(strings.NewReader("let x = @"))
+	var terminals []string
+	for token := range tokens {
+		terminals = append(terminals, token.terminal)
+	}
+	if want := []string{"let", "x", "="}; !reflect.DeepEqual(terminals, want) {
+		t.Errorf("tokens %q, want %q", terminals, want)
+	}
+	if err := <-errs; err == nil || err.Error() != "unexpected character '@' at line 1, col 9" {
+		t.Errorf("error %v, want the unexpected character", err)
+	}
+
+	tokens, errs = Tokens(strings.NewReader("x"))
+	for range tokens {
+	}
+	if err, ok := <-errs; ok {
+		t.Errorf("error %v, want the error channel closed", err)
+	}
+}
+
+func TestIdentifiersStartingWithKeywords(t *testing.T) {
+	for _, name := range []string{"thistle", "classy", "returnValue", "thisValue", "letterCount", "do_it", "int2"} {
+		tokens := scanTokens(t, name)
+		if want := []Token{NewToken(Identifier, name, Position{Line: 1, Column: 1})}; !reflect.DeepEqual(tokens, want) {
+			t.Errorf("%q tokenized as %v, want %v", name, tokens, want)
+		}
+	}
+	tokens := scanTokens(t, "this.x")
+	if len(tokens) != 3 || !IsTokenType(tokens[0], Keyword) {
+		t.Errorf(`"this.x" tokenized as %v, want the keyword this first`, tokens)
+	}
+}
+
+func TestScannerPositionAdvances(t *testing.T) {
+	source := "class Main {\n  field int x;\n}"
+	want := [][2]int{{1, 1}, {1, 7}, {1, 12}, {2, 3}, {2, 9}, {2, 13}, {2, 14}, {3, 1}}
+
+	tokenizer := NewTokenizer(strings.NewReader(source))
+	scanners := map[string]TokenScanner{
+		"Tokenizer":   &tokenizer,
+		"tokenBuffer": newTokenBuffer(scanTokens(t, source)),
+	}
+	for name, scanner := range scanners {
+		var positions [][2]int
+		for scanner.Scan() && !IsTokenType(scanner.Token(), EOF) {
+			line, col := scanner.Position()
+			positions = append(positions, [2]int{line, col})
+		}
+		if !reflect.DeepEqual(positions, want) {
+			t.Errorf("%s positions %v, want %v", name, positions, want)
+		}
+	}
+}
+
+func TestSingleEOFToken(t *testing.T) {
+	tests := map[string][]Token{
+		"class Main\n": {
+			NewToken(Keyword, "class", Position{Line: 1, Column: 1}),
+			NewToken(Identifier, "Main", Position{Line: 1, Column: 7}),
+			NewToken(EOF, "", Position{Line: 2, Column: 1}),
+		},
+		"x // c": {
+			NewToken(Identifier, "x", Position{Line: 1, Column: 1}),
+			NewToken(EOF, "", Position{Line: 1, Column: 7}),
+		},
+		"": {NewToken(EOF, "", Position{Line: 1, Column: 1})},
+	}
+	for source, want := range tests {
+		tokenizer := NewTokenizer(strings.NewReader(source))
+		var tokens []Token
+		for tokenizer.Scan() {
+			tokens = append(tokens, tokenizer.Token())
+		}
+		if err := tokenizer.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(tokens, want) {
+			t.Errorf("%q: tokens %v, want %v", source, tokens, want)
+		}
+		if tokenizer.Scan() {
+			t.Errorf("%q: Scan succeeded after the EOF token", source)
+		}
+	}
+}
+
+func TestTabWidth(t *testing.T) {
+	source := "\tx\n  \ty\nab\t\tz"
+	columns := map[int][]int{
+		1: {2, 4, 5},
+		4: {5, 5, 9},
+		8: {9, 9, 17},
+	}
+	for width, want := range columns {
+		tokenizer := NewTokenizer(strings.NewReader(source))
+		tokenizer.SetTabWidth(width)
+		var got []int
+		for tokenizer.Scan() && !IsTokenType(tokenizer.Token(), EOF) {
+			if token := tokenizer.Token(); token.terminal != "ab" {
+				got = append(got, token.position.Column)
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("tab width %d: columns %v, want %v", width, got, want)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// vmMachine runs VM code on the memory layout of the nand2tetris VM: SP,
+// LCL, ARG, THIS and THAT at 0 to 4, temp at 5, statics at 16, the stack at
+// 256 and the heap at 2048. Memory.alloc is built in as a bump allocator,
+// calls of other undefined functions fail.
+type vmMachine struct {
+	commands [][]string
+	// Class of the function each command belongs to
+	classes   []string
+	labels    map[string]int
+	functions map[string]int
+	statics   map[string]int
+	memory    [32768]int16
+	heap      int16
+	// Name of the VM file all code is loaded from. If set, the statics of
+	// all classes are those of the file, else each class has its own.
+	file string
+	// Steps left before the program is assumed not to terminate
+	steps int
+}
+
+const (
+	vmSP   = 0
+	vmLCL  = 1
+	vmARG  = 2
+	vmTHIS = 3
+	vmTHAT = 4
+)
+
+// newVMMachine loads the VM code vm.
+func newVMMachine(vm string) *vmMachine {
+	m := &vmMachine{labels: map[string]int{}, functions: map[string]int{}, statics: map[string]int{}, heap: 2048}
+	class := ""
+	for _, line := range strings.Split(vm, "\n") {
+		if comment := strings.Index(line, "//"); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case fields[0] == "label":
+			m.labels[fields[1]] = len(m.commands)
+		case fields[0] == "function":
+			m.functions[fields[1]] = len(m.commands)
+			class, _, _ = strings.Cut(fields[1], ".")
+		}
+		m.commands = append(m.commands, fields)
+		m.classes = append(m.classes, class)
+	}
+	return m
+}
+
+func (m *vmMachine) push(value int16) {
+	m.memory[m.memory[vmSP]] = value
+	m.memory[vmSP] += 1
+}
+
+func (m *vmMachine) pop() int16 {
+	m.memory[vmSP] -= 1
+	return m.memory[m.memory[vmSP]]
+}
+
+// address returns the address of index in segment of the function in class.
+func (m *vmMachine) address(class string, segment string, index int16) (int16, error) {
+	switch segment {
+	case "local":
+		return m.memory[vmLCL] + index, nil
+	case "argument":
+		return m.memory[vmARG] + index, nil
+	case "this":
+		return m.memory[vmTHIS] + index, nil
+	case "that":
+		return m.memory[vmTHAT] + index, nil
+	case "pointer":
+		return vmTHIS + index, nil
+	case "temp":
+		return 5 + index, nil
+	case "static":
+		if m.file != "" {
+			class = m.file
+		}
+		name := fmt.Sprintf("%s.%d", class, index)
+		if _, ok := m.statics[name]; !ok {
+			m.statics[name] = 16 + len(m.statics)
+		}
+		return int16(m.statics[name]), nil
+	}
+	return 0, fmt.Errorf("unknown segment %q", segment)
+}
+
+// call runs function with args and returns its result.
+func (m *vmMachine) call(function string, args ...int16) (int16, error) {
+	m.memory[vmSP] = 256
+	for _, arg := range args {
+		m.push(arg)
+	}
+	// The return address -1 ends the run
+	if err := m.enter(function, int16(len(args)), -1); err != nil {
+		return 0, err
+	}
+
+	for pc := m.functions[function]; pc != -1; {
+		if m.steps -= 1; m.steps < 0 {
+			return 0, fmt.Errorf("no return from %s", function)
+		}
+		command := m.commands[pc]
+		class := m.classes[pc]
+		pc += 1
+		argument := func(i int) int16 {
+			value, _ := strconv.Atoi(command[i])
+			return int16(value)
+		}
+		switch command[0] {
+		case "push", "pop":
+			if command[1] == "constant" {
+				m.push(argument(2))
+				continue
+			}
+			address, err := m.address(class, command[1], argument(2))
+			if err != nil {
+				return 0, err
+			}
+			if command[0] == "push" {
+				m.push(m.memory[address])
+			} else {
+				m.memory[address] = m.pop()
+			}
+		case "add", "sub", "and", "or", "eq", "gt", "lt":
+			y, x := m.pop(), m.pop()
+			m.push(map[string]int16{
+				"add": x + y, "sub": x - y, "and": x & y, "or": x | y,
+				"eq": vmBool(x == y), "gt": vmBool(x > y), "lt": vmBool(x < y),
+			}[command[0]])
+		case "neg":
+			m.push(-m.pop())
+		case "not":
+			m.push(^m.pop())
+		case "label", "function":
+		case "goto":
+			pc = m.labels[command[1]]
+		case "if-goto":
+			if m.pop() != 0 {
+				pc = m.labels[command[1]]
+			}
+		case "call":
+			if command[1] == "Memory.alloc" {
+				size := m.pop()
+				m.push(m.heap)
+				m.heap += size
+				continue
+			}
+			if _, ok := m.functions[command[1]]; !ok {
+				return 0, fmt.Errorf("call of undefined function %s", command[1])
+			}
+			if err := m.enter(command[1], argument(2), int16(pc)); err != nil {
+				return 0, err
+			}
+			pc = m.functions[command[1]]
+		case "return":
+			frame := m.memory[vmLCL]
+			returnAddress := m.memory[frame-5]
+			m.memory[m.memory[vmARG]] = m.pop()
+			m.memory[vmSP] = m.memory[vmARG] + 1
+			m.memory[vmTHAT] = m.memory[frame-1]
+			m.memory[vmTHIS] = m.memory[frame-2]
+			m.memory[vmARG] = m.memory[frame-3]
+			m.memory[vmLCL] = m.memory[frame-4]
+			pc = int(returnAddress)
+		default:
+			return 0, fmt.Errorf("unknown command %q", strings.Join(command, " "))
+		}
+	}
+	return m.memory[m.memory[vmSP]-1], nil
+}
+
+// enter pushes the frame of a call of function with nargs arguments, which
+// returns to returnAddress, and its locals.
+func (m *vmMachine) enter(function string, nargs int16, returnAddress int16) error {
+	start, ok := m.functions[function]
+	if !ok {
+		return fmt.Errorf("call of undefined function %s", function)
+	}
+	m.push(returnAddress)
+	for _, pointer := range []int16{vmLCL, vmARG, vmTHIS, vmTHAT} {
+		m.push(m.memory[pointer])
+	}
+	m.memory[vmARG] = m.memory[vmSP] - nargs - 5
+	m.memory[vmLCL] = m.memory[vmSP]
+	nlocals, _ := strconv.Atoi(m.commands[start][2])
+	for i := 0; i < nlocals; i++ {
+		m.push(0)
+	}
+	return nil
+}
+
+func vmBool(value bool) int16 {
+	if value {
+		return -1
+	}
+	return 0
+}
+
+// runVM calls function of the VM code vm with args and returns its result.
+func runVM(t *testing.T, vm string, function string, args ...int16) int16 {
+	t.Helper()
+	machine := newVMMachine(vm)
+	machine.steps = 1000000
+	result, err := machine.call(function, args...)
+	if err != nil {
+		t.Fatalf("%s%v: %v", function, args, err)
+	}
+	return result
+}
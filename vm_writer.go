@@ -1,9 +1,12 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"io"
 	"strconv"
+	"unicode/utf8"
 )
 
 type VMSegmentType string
@@ -31,23 +34,56 @@ const (
 	GtVMOperation      VMOperation = "gt"
 	LtVMOperation      VMOperation = "lt"
 	AndVMOperation     VMOperation = "and"
-	OrvMOperation      VMOperation = "or"
+	OrVMOperation      VMOperation = "or"
 	NotVMOperation     VMOperation = "not"
-	MulVMOperation     VMOperation = "mul"
-	DivVMOperation     VMOperation = "div"
+	// mul, div and mod are no VM commands, VMWriter emits calls of
+	// Math.multiply, Math.divide and Math.mod instead
+	MulVMOperation VMOperation = "mul"
+	DivVMOperation VMOperation = "div"
+	ModVMOperation VMOperation = "mod"
 )
 
+// IsValid reports whether o is one of the VMOperation constants other than
+// InvalidVMOperation.
+func (o VMOperation) IsValid() bool {
+	switch o {
+	case AddVMOperation, SubVMOperation, NegVMOperation, EqVMOperation, GtVMOperation, LtVMOperation,
+		AndVMOperation, OrVMOperation, NotVMOperation, MulVMOperation, DivVMOperation, ModVMOperation:
+		return true
+	}
+	return false
+}
+
 type VMWriter struct {
-	output io.Writer
+	// SeparateFunctions precedes every function but the first by a blank
+	// line and every function by a comment naming it
+	SeparateFunctions bool
+
+	// Buffers the commands until Close. Errors are sticky, commands
+	// following a failed write are dropped.
+	output *bufio.Writer
+	// Reserved for building string constants, shared with the compiler
+	// writing to w if any
+	temps *TempSlots
+	// Whether a function was written yet
+	wroteFunction bool
 }
 
 func NewVMWriter(w io.Writer) VMWriter {
-	return VMWriter{output: w}
+	return VMWriter{output: bufio.NewWriter(w)}
+}
+
+// NewBufferVMWriter returns a VMWriter writing to the returned in-memory
+// buffer. The buffer is complete once the VMWriter is closed.
+func NewBufferVMWriter() (*VMWriter, *bytes.Buffer) {
+	buffer := &bytes.Buffer{}
+	writer := NewVMWriter(buffer)
+	return &writer, buffer
 }
 
 func (w *VMWriter) WriteCommand(command string) {
-	io.WriteString(w.output, command)
-	io.WriteString(w.output, "\n")
+	w.output.WriteString(command)
+	w.output.WriteByte('\n')
 }
 
 func (w *VMWriter) WritePush(segment VMSegmentType, index MachineWord) {
@@ -58,34 +94,70 @@ func (w *VMWriter) WritePop(segment VMSegmentType, index MachineWord) {
 	w.WriteCommand(fmt.Sprintf("pop %s %d", segment, index))
 }
 
+func (w *VMWriter) writeAnnotatedPush(segment VMSegmentType, index MachineWord, comment string) {
+	w.WriteCommand(fmt.Sprintf("push %s %d // %s", segment, index, comment))
+}
+
+func (w *VMWriter) writeAnnotatedPop(segment VMSegmentType, index MachineWord, comment string) {
+	w.WriteCommand(fmt.Sprintf("pop %s %d // %s", segment, index, comment))
+}
+
 func (w *VMWriter) WriteStringConstant(constant string) {
-	w.WritePush(ConstVMSegment, MachineWord(len(constant)))
-	w.WriteCall("String.new", 1)
+	if w.temps == nil {
+		w.temps = &TempSlots{}
+	}
+	writeStringConstant(w, w.temps, constant)
+}
+
+func (w *VMWriter) useTemps(temps *TempSlots) {
+	w.temps = temps
+}
+
+// writeStringConstant writes the commands building the string constant by
+// calls of String.new and String.appendChar to output.
+func writeStringConstant(output OutputWriter, temps *TempSlots, constant string) {
+	output.WritePush(ConstVMSegment, MachineWord(utf8.RuneCountInString(constant)))
+	output.WriteCall("String.new", 1)
 	// Store allocated string pointer in temp segment
-	w.WritePop(TempVMSegment, 0)
+	pointer := temps.Reserve()
+	discard := temps.Reserve()
+	output.WritePop(TempVMSegment, pointer)
 	for _, c := range constant {
 		// Push stored pointer to object
-		w.WritePush(TempVMSegment, 0)
+		output.WritePush(TempVMSegment, pointer)
 		// Push the character
-		w.WritePush(ConstVMSegment, MachineWord(c))
+		output.WritePush(ConstVMSegment, MachineWord(c))
 		// Append another character
-		w.WriteCall("String.appendChar", 2)
+		output.WriteCall("String.appendChar", 2)
 		// Remove 0 return value
-		w.WritePop(TempVMSegment, 1)
+		output.WritePop(TempVMSegment, discard)
 	}
 	// Leave pointer to string constant on top of stack
-	w.WritePush(TempVMSegment, 0)
+	output.WritePush(TempVMSegment, pointer)
+	temps.Release(discard)
+	temps.Release(pointer)
 }
 
-func (w *VMWriter) WriteArithmetic(operation VMOperation) {
+// arithmeticFunction returns the OS function implementing operation if it is
+// no VM command.
+func arithmeticFunction(operation VMOperation) (string, bool) {
 	switch operation {
 	case DivVMOperation:
-		w.WriteCall("Math.divide", 2)
+		return "Math.divide", true
 	case MulVMOperation:
-		w.WriteCall("Math.multiply", 2)
-	default:
-		w.WriteCommand(string(operation))
+		return "Math.multiply", true
+	case ModVMOperation:
+		return "Math.mod", true
 	}
+	return "", false
+}
+
+func (w *VMWriter) WriteArithmetic(operation VMOperation) {
+	if function, ok := arithmeticFunction(operation); ok {
+		w.WriteCall(function, 2)
+		return
+	}
+	w.WriteCommand(string(operation))
 }
 
 func (w *VMWriter) WriteLabel(label string) {
@@ -105,6 +177,13 @@ func (w *VMWriter) WriteCall(label string, nargs MachineWord) {
 }
 
 func (w *VMWriter) WriteFunction(label string, nlocals MachineWord) {
+	if w.SeparateFunctions {
+		if w.wroteFunction {
+			w.WriteCommand("")
+		}
+		w.WriteComment("function " + label)
+	}
+	w.wroteFunction = true
 	w.WriteCommand("function " + label + " " + strconv.FormatUint(uint64(nlocals), 10))
 }
 
@@ -112,5 +191,12 @@ func (w *VMWriter) WriteReturn() {
 	w.WriteCommand("return")
 }
 
-func (w *VMWriter) Close() {
+func (w *VMWriter) WriteComment(comment string) {
+	w.WriteCommand("// " + comment)
+}
+
+// Close flushes the buffered commands and returns the first error
+// encountered while writing. The underlying io.Writer is not closed.
+func (w *VMWriter) Close() error {
+	return w.output.Flush()
 }
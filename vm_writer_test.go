@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestVMOperationMnemonics(t *testing.T) {
+	mnemonics := map[VMOperation]string{
+		AddVMOperation: "add",
+		SubVMOperation: "sub",
+		NegVMOperation: "neg",
+		EqVMOperation:  "eq",
+		GtVMOperation:  "gt",
+		LtVMOperation:  "lt",
+		AndVMOperation: "and",
+		OrVMOperation:  "or",
+		NotVMOperation: "not",
+		MulVMOperation: "mul",
+		DivVMOperation: "div",
+		ModVMOperation: "mod",
+	}
+	for operation, mnemonic := range mnemonics {
+		if string(operation) != mnemonic {
+			t.Errorf("operation %q, want mnemonic %q", operation, mnemonic)
+		}
+		if !operation.IsValid() {
+			t.Errorf("%q.IsValid() = false, want true", operation)
+		}
+	}
+	for _, operation := range []VMOperation{InvalidVMOperation, "foo", "Or"} {
+		if operation.IsValid() {
+			t.Errorf("%q.IsValid() = true, want false", operation)
+		}
+	}
+}
+
+func TestVMWriterWritesArithmetic(t *testing.T) {
+	vmWriter, buffer := NewBufferVMWriter()
+	for _, operation := range []VMOperation{AddVMOperation, OrVMOperation, MulVMOperation, DivVMOperation, ModVMOperation} {
+		vmWriter.WriteArithmetic(operation)
+	}
+	if err := vmWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := "add\nor\ncall Math.multiply 2\ncall Math.divide 2\ncall Math.mod 2\n"
+	if buffer.String() != want {
+		t.Errorf("output\n%s\nwant\n%s", buffer, want)
+	}
+}
+
+// countingWriter counts the calls of Write, each a syscall for a file.
+type countingWriter struct {
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes += 1
+	return len(p), nil
+}
+
+func BenchmarkCompileLargeClass(b *testing.B) {
+	source := syntheticSource(500)
+	b.SetBytes(int64(len(source)))
+	output := &countingWriter{}
+	for i := 0; i < b.N; i++ {
+		tokenizer := NewTokenizer(strings.NewReader(source))
+		if _, err := compileFile("Large.jack", &tokenizer, output, CompilerOptions{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(output.writes)/float64(b.N), "writes/op")
+}
+
+func TestWriteCommentPassesThroughWrappers(t *testing.T) {
+	vmWriter, buffer := NewBufferVMWriter()
+	writer := NewPeepholeWriter(NewStringPoolWriter(vmWriter))
+	writer.WriteFunction("Main.main", 0)
+	writer.WriteComment("x is unused")
+	writer.WritePush(ConstVMSegment, 0)
+	writer.WriteReturn()
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := "function Main.main 0\n// x is unused\npush constant 0\nreturn\n"
+	if buffer.String() != want {
+		t.Errorf("output\n%s\nwant\n%s", buffer, want)
+	}
+}
+
+func TestCompileIntoBufferVMWriter(t *testing.T) {
+	vmWriter, buffer := NewBufferVMWriter()
+	tokenizer := NewTokenizer(strings.NewReader(`class Main { function int f() { return 1 + 2; } }`))
+	if err := NewJackCompiler(&tokenizer, vmWriter).Compile(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vmWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := "function Main.f 0\npush constant 1\npush constant 2\nadd\nreturn\n"
+	if buffer.String() != want {
+		t.Errorf("buffer %q, want %q", buffer.String(), want)
+	}
+}
+
+func TestSeparateFunctions(t *testing.T) {
+	source := "class Main { function void f() { return; } method void g() { return; } }"
+	want := "// function Main.f\nfunction Main.f 0\npush constant 0\nreturn\n" +
+		"\n// function Main.g\nfunction Main.g 0\npush argument 0\npop pointer 0\npush constant 0\nreturn\n"
+	for _, options := range []CompilerOptions{{SeparateFunctions: true}, {SeparateFunctions: true, FoldConstants: true}, {SeparateFunctions: true, Peephole: true}} {
+		vm, _, err := compileSource("Main.jack", source, options)
+		if err != nil || vm != want {
+			t.Errorf("%+v: compiled to %q, %v, want %q", options, vm, err, want)
+		}
+		// The separated output is still valid VM code
+		if got := runVM(t, vm, "Main.f"); got != 0 {
+			t.Errorf("Main.f() = %d, want 0", got)
+		}
+	}
+
+	vm, _, err := compileSource("Main.jack", source, CompilerOptions{})
+	if err != nil || strings.Contains(vm, "//") || strings.Contains(vm, "\n\n") {
+		t.Errorf("compiled to %q, %v without SeparateFunctions, want no comments or blank lines", vm, err)
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestVMWriterCloseFlushes(t *testing.T) {
+	var output strings.Builder
+	vmWriter := NewVMWriter(&output)
+	vmWriter.WriteFunction("Main.main", 0)
+	vmWriter.WriteReturn()
+	if output.Len() != 0 {
+		t.Errorf("wrote %q before Close, want the commands buffered", output.String())
+	}
+	if err := vmWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "function Main.main 0\nreturn\n"; output.String() != want {
+		t.Errorf("wrote %q, want %q", output.String(), want)
+	}
+
+	// Compile closes the output and reports its errors
+	for _, options := range []CompilerOptions{{}, {Peephole: true}, {PoolStrings: true}} {
+		tokenizer := NewTokenizer(strings.NewReader(`class Main { function void main() { return; } }`))
+		_, err := compileFile("Main.jack", &tokenizer, failingWriter{}, options)
+		if err == nil || !strings.Contains(err.Error(), "disk full") {
+			t.Errorf("%+v: error %v, want the write error", options, err)
+		}
+	}
+}